@@ -18,21 +18,216 @@ package v1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+const (
+	// SuspendAnnotation annotation used to exclude a single generated object from management
+	SuspendAnnotation = "template.k8s.ericogr.com.br/suspend"
+	// Finalizer finalizer used to cleanup generated objects
+	Finalizer = "template.k8s.ericogr.com.br/finalizer"
+	// ManagedByLabel label added to every generated object
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ManagedByValue value of ManagedByLabel
+	ManagedByValue = "k8s-object-template"
+	// TemplateAnnotation name of the template that generated the object
+	TemplateAnnotation = "template.k8s.ericogr.com.br/template"
+	// ParamsAnnotation name of the parameters that generated the object
+	ParamsAnnotation = "template.k8s.ericogr.com.br/params"
+	// ParamsNamespaceAnnotation namespace of the parameters that generated the object
+	ParamsNamespaceAnnotation = "template.k8s.ericogr.com.br/params-namespace"
+	// AdoptAnnotation allow adoption of unmanaged objects when adoption policy is IfLabeled
+	AdoptAnnotation = "template.k8s.ericogr.com.br/adopt"
+	// TemplateLabel name of the template of a revision
+	TemplateLabel = "template.k8s.ericogr.com.br/template"
+)
+
+// AdoptionPolicy what to do when an unmanaged object with the same name already exists
+// +kubebuilder:validation:Enum=Never;IfLabeled;Always
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyNever fail if an unmanaged object exists
+	AdoptionPolicyNever AdoptionPolicy = "Never"
+	// AdoptionPolicyIfLabeled adopt only unmanaged objects with AdoptAnnotation
+	AdoptionPolicyIfLabeled AdoptionPolicy = "IfLabeled"
+	// AdoptionPolicyAlways adopt any unmanaged object
+	AdoptionPolicyAlways AdoptionPolicy = "Always"
+)
+
+// DeletionPolicy what to do with generated objects when template or parameters are deleted
+// +kubebuilder:validation:Enum=Delete;Orphan;Retain
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete delete generated objects
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan remove owner references and keep generated objects
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyRetain remove owner references and keep generated objects with managed markers, so they are adopted again by recreated parameters
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
+// UpdateStrategy how generated objects are updated
+// +kubebuilder:validation:Enum=Patch;Recreate;Replace
+type UpdateStrategy string
+
+const (
+	// UpdateStrategyPatch update templated fields of existing object
+	UpdateStrategyPatch UpdateStrategy = "Patch"
+	// UpdateStrategyRecreate update templated fields, delete and create object again if an immutable field changed
+	UpdateStrategyRecreate UpdateStrategy = "Recreate"
+	// UpdateStrategyReplace replace existing object with the full rendered body
+	UpdateStrategyReplace UpdateStrategy = "Replace"
+)
+
+// Engine rendering engine of object templates
+// +kubebuilder:validation:Enum=gotemplate;jsonnet;cue
+type Engine string
+
+const (
+	// EngineGoTemplate go text/template producing YAML
+	EngineGoTemplate Engine = "gotemplate"
+	// EngineJsonnet jsonnet program producing the object (values are available with std.extVar("values"))
+	EngineJsonnet Engine = "jsonnet"
+	// EngineCUE cue source producing the object (values are available as _values)
+	EngineCUE Engine = "cue"
+)
+
+// PatchType type of patch applied to an object base
+// +kubebuilder:validation:Enum=strategic;json6902
+type PatchType string
+
+const (
+	// PatchTypeStrategicMerge strategic merge patch (json merge patch for kinds unknown to the operator)
+	PatchTypeStrategicMerge PatchType = "strategic"
+	// PatchTypeJSON6902 list of JSON patch (RFC 6902) operations
+	PatchTypeJSON6902 PatchType = "json6902"
+)
+
+// ObjectPatch templated patch applied to an object base
+type ObjectPatch struct {
+	// Type strategic or json6902 (default strategic)
+	Type PatchType `json:"type,omitempty"`
+	// Patch go template producing the patch as YAML or JSON
+	Patch string `json:"patch"`
+}
+
+// ChartArchiveRef key of a config map or secret with a packaged helm chart (.tgz)
+type ChartArchiveRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Key data key with the chart archive (default chart.tgz)
+	Key string `json:"key,omitempty"`
+}
+
+// OCILayoutRef helm chart stored in a local OCI image layout
+type OCILayoutRef struct {
+	// Path directory of the OCI image layout in the operator filesystem (e.g. a mounted volume)
+	Path string `json:"path"`
+	// Reference tag or digest of the chart manifest (optional if the layout has a single manifest)
+	Reference string `json:"reference,omitempty"`
+}
+
+// ChartSource helm chart rendered with parameters values (only one source must be set)
+type ChartSource struct {
+	// ConfigMap config map with the chart archive in binaryData
+	ConfigMap *ChartArchiveRef `json:"configMap,omitempty"`
+	// Secret secret with the chart archive
+	Secret *ChartArchiveRef `json:"secret,omitempty"`
+	// OCILayout local OCI image layout with the chart
+	OCILayout *OCILayoutRef `json:"ociLayout,omitempty"`
+	// ReleaseName helm release name (default parameters name)
+	ReleaseName string `json:"releaseName,omitempty"`
+	// Values go template producing helm values as YAML (merged over parameters values)
+	Values string `json:"values,omitempty"`
+	// UpdateStrategy how chart objects are updated (default Replace)
+	UpdateStrategy UpdateStrategy `json:"updateStrategy,omitempty"`
+}
+
+// FailurePolicy what to do when an object fails to render or apply
+// +kubebuilder:validation:Enum=Abort;Continue
+type FailurePolicy string
+
+const (
+	// FailurePolicyAbort stop applying remaining objects
+	FailurePolicyAbort FailurePolicy = "Abort"
+	// FailurePolicyContinue apply remaining objects and report all errors
+	FailurePolicyContinue FailurePolicy = "Continue"
+)
+
+// RolloutPhase state of a template revision rollout
+type RolloutPhase string
+
+const (
+	// RolloutPhaseProgressing rollout is updating parameters in batches
+	RolloutPhaseProgressing RolloutPhase = "Progressing"
+	// RolloutPhasePaused rollout paused by strategy
+	RolloutPhasePaused RolloutPhase = "Paused"
+	// RolloutPhaseHalted rollout stopped because failures exceeded the threshold
+	RolloutPhaseHalted RolloutPhase = "Halted"
+	// RolloutPhaseCompleted all parameters use the latest revision
+	RolloutPhaseCompleted RolloutPhase = "Completed"
+)
+
+// RolloutStrategy progressive rollout of template revisions across namespaces
+type RolloutStrategy struct {
+	// BatchSize number of parameters updated per batch (default 1)
+	BatchSize int32 `json:"batchSize,omitempty"`
+	// BatchPercentage percentage of parameters updated per batch (used when BatchSize is not set)
+	BatchPercentage int32 `json:"batchPercentage,omitempty"`
+	// PauseBetweenBatches time to wait after a batch before starting the next one
+	PauseBetweenBatches *metav1.Duration `json:"pauseBetweenBatches,omitempty"`
+	// HealthGate wait for objects of updated parameters to be healthy before starting the next batch
+	HealthGate bool `json:"healthGate,omitempty"`
+	// CanarySelector namespaces selected by label are updated in the first batch
+	CanarySelector *metav1.LabelSelector `json:"canarySelector,omitempty"`
+	// FailureThreshold percentage of failed updated parameters that halts the rollout (default 0, any failure halts it)
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+	// Paused do not start new batches
+	Paused bool `json:"paused,omitempty"`
+}
+
 // Metadata metadata for object
 type Metadata struct {
 	Labels      map[string]string `json:"labels,omitempty"`
 	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
+// ReadinessCheck check used to decide if an object is ready
+type ReadinessCheck struct {
+	// ConditionType condition type that must have True status
+	ConditionType string `json:"conditionType,omitempty"`
+	// JSONPath expression that must return Value (or any non empty value if Value is not set)
+	JSONPath string `json:"jsonPath,omitempty"`
+	// Value expected value returned by JSONPath
+	Value string `json:"value,omitempty"`
+	// Health use built-in health checks (object must be Current)
+	Health bool `json:"health,omitempty"`
+}
+
 // Object defines a single object to be created
 type Object struct {
 	Kind         string   `json:"kind"`
 	APIVersion   string   `json:"apiVersion"`
 	Metadata     Metadata `json:"metadata,omitempty"`
 	Name         string   `json:"name"`
-	TemplateBody string   `json:"templateBody"`
+	TemplateBody string   `json:"templateBody,omitempty"`
+	// Base static object body used instead of templateBody
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Base *runtime.RawExtension `json:"base,omitempty"`
+	// Patches templated patches applied in order to base
+	Patches []ObjectPatch `json:"patches,omitempty"`
+	// Engine rendering engine of templateBody (overrides template engine)
+	Engine Engine `json:"engine,omitempty"`
+	// DeletionPolicy overrides template deletion policy for this object
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// UpdateStrategy how this object is updated (default Patch)
+	UpdateStrategy UpdateStrategy `json:"updateStrategy,omitempty"`
+	// DependsOn names of other objects in this template that must be applied and ready before this one
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// ReadinessCheck check used by dependent objects to decide if this object is ready (exists by default)
+	ReadinessCheck *ReadinessCheck `json:"readinessCheck,omitempty"`
 }
 
 // Parameter defines a single parameter
@@ -41,21 +236,130 @@ type Parameter struct {
 	Default string `json:"default"`
 }
 
+// ParameterMigration sets a parameter from values of old parameters when it is not informed
+type ParameterMigration struct {
+	// Name parameter that receives the migrated value
+	Name string `json:"name"`
+	// From old parameter name renamed to Name (migration is applied only if it has a value)
+	From string `json:"from,omitempty"`
+	// Expression template used to derive the value from parameters values (e.g. {{ .firstName }} {{ .lastName }})
+	Expression string `json:"expression,omitempty"`
+}
+
+// AllowedNamespaces namespaces allowed to use a template (a namespace is allowed if it matches any name or the selector)
+type AllowedNamespaces struct {
+	// Names namespace names or globs (e.g. platform-*)
+	Names []string `json:"names,omitempty"`
+	// Selector namespace label selector
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
 // ObjectTemplateSpec defines the desired state of ObjectTemplate
 type ObjectTemplateSpec struct {
 	Description string      `json:"description,omitempty"`
 	Parameters  []Parameter `json:"parameters"`
-	Objects     []Object    `json:"objects"`
+	Objects     []Object    `json:"objects,omitempty"`
+	// Chart helm chart producing objects in addition to objects
+	Chart *ChartSource `json:"chart,omitempty"`
+	// Migrations parameters renamed or derived from old parameters
+	Migrations []ParameterMigration `json:"migrations,omitempty"`
+	// ResyncPeriod re-render and re-apply objects periodically (uses operator default if not set)
+	ResyncPeriod *metav1.Duration `json:"resyncPeriod,omitempty"`
+	// DryRun render and validate objects using server side dry-run without persisting them
+	DryRun bool `json:"dryRun,omitempty"`
+	// Suspend stop applying objects from this template
+	Suspend bool `json:"suspend,omitempty"`
+	// DeletionPolicy what to do with generated objects when template or parameters are deleted (default Delete)
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// AdoptionPolicy what to do when an unmanaged object with the same name already exists (default Always)
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+	// Engine rendering engine of objects templateBody (default gotemplate)
+	Engine Engine `json:"engine,omitempty"`
+	// FailurePolicy what to do when an object fails to render or apply (default Continue)
+	FailurePolicy FailurePolicy `json:"failurePolicy,omitempty"`
+	// ServiceAccountName service account of the target namespace impersonated to apply objects (restricted by parameters service account)
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// AllowedNamespaces namespaces allowed to use this template (all namespaces if not set)
+	AllowedNamespaces *AllowedNamespaces `json:"allowedNamespaces,omitempty"`
+	// Rollout update parameters with new revisions progressively (all at once if not set)
+	Rollout *RolloutStrategy `json:"rollout,omitempty"`
+	// RollbackTo restore template spec from a revision and apply it to all parameters at once
+	RollbackTo *int64 `json:"rollbackTo,omitempty"`
+	// RevisionHistoryLimit number of revisions to keep, revisions in use are never removed (uses operator default if not set)
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+}
+
+// ObjectPreview rendered object produced by a dry-run
+type ObjectPreview struct {
+	Namespace  string `json:"namespace"`
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	// Action Create, Update, Recreate or None
+	Action string `json:"action"`
+	// Manifest object returned by the server side dry-run
+	Manifest string `json:"manifest,omitempty"`
+	// Diff merge patch from the live object to the dry-run object
+	Diff string `json:"diff,omitempty"`
+}
+
+// ObjectPreviewSummary actions of a dry-run by parameters (rendered manifests are reported by parameters status)
+type ObjectPreviewSummary struct {
+	Namespace string `json:"namespace"`
+	Params    string `json:"params"`
+	Create    int32  `json:"create"`
+	Update    int32  `json:"update"`
+	Recreate  int32  `json:"recreate"`
+	None      int32  `json:"none"`
 }
 
+// ObjectsHealth health of generated objects in a namespace
+type ObjectsHealth struct {
+	Namespace  string `json:"namespace"`
+	Current    int32  `json:"current"`
+	InProgress int32  `json:"inProgress"`
+	Failed     int32  `json:"failed"`
+}
+
+// RolloutStatus progress of the latest revision rollout
+type RolloutStatus struct {
+	// Revision revision being rolled out
+	Revision int64        `json:"revision"`
+	Phase    RolloutPhase `json:"phase"`
+	// Total parameters following the latest revision
+	Total int32 `json:"total"`
+	// Updated parameters using the latest revision
+	Updated int32 `json:"updated"`
+	// Failed updated parameters with errors or failed objects
+	Failed int32 `json:"failed"`
+	// Batch number of batches started
+	Batch int32 `json:"batch"`
+	// LastBatchTime time of the last batch
+	LastBatchTime *metav1.Time `json:"lastBatchTime,omitempty"`
+	Message       string       `json:"message,omitempty"`
+}
+
+const (
+	// ConditionRolledBack last rollbackTo was applied
+	ConditionRolledBack = "RolledBack"
+)
+
 // ObjectTemplateStatus defines the observed state of ObjectTemplate
 type ObjectTemplateStatus struct {
-	Status string `json:"status"`
+	Status     string                 `json:"status"`
+	Preview    []ObjectPreviewSummary `json:"preview,omitempty"`
+	Namespaces []ObjectsHealth        `json:"namespaces,omitempty"`
+	Conditions []metav1.Condition     `json:"conditions,omitempty"`
+	// Revision latest template revision
+	Revision int64 `json:"revision,omitempty"`
+	// Rollout progress of the latest revision rollout
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:path=objecttemplates,scope=Cluster
 // +kubebuilder:printcolumn:name="status",type=string,JSONPath=`.status.status`
+// +kubebuilder:printcolumn:name="revision",type=integer,JSONPath=`.status.revision`
 // +kubebuilder:printcolumn:name="age",type=date,JSONPath=`.metadata.creationTimestamp`
 // +kubebuilder:subresource:status
 
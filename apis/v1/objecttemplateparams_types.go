@@ -24,20 +24,52 @@ import (
 type Parameters struct {
 	Name   string            `json:"name"`
 	Values map[string]string `json:"values,omitempty"`
+	// Revision template revision number to apply or latest (default latest)
+	Revision string `json:"revision,omitempty"`
+}
+
+// AppliedRevision template revision applied by parameters
+type AppliedRevision struct {
+	Name     string `json:"name"`
+	Revision int64  `json:"revision"`
 }
 
 // ObjectTemplateParamsSpec defines the desired state of ObjectTemplateParams
 type ObjectTemplateParamsSpec struct {
 	Templates []Parameters `json:"templates"`
+	// DryRun render and validate objects using server side dry-run without persisting them
+	DryRun bool `json:"dryRun,omitempty"`
+	// Suspend stop applying objects in this namespace
+	Suspend bool `json:"suspend,omitempty"`
+	// Priority parameters with higher priority take over objects owned by other parameters
+	Priority int32 `json:"priority,omitempty"`
+	// ServiceAccountName service account impersonated to apply objects (restricts template service account)
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
 }
 
 // ObjectTemplateParamsStatus defines the observed state of ObjectTemplateParams
 type ObjectTemplateParamsStatus struct {
-	Status string `json:"status"`
+	Status     string             `json:"status"`
+	Preview    []ObjectPreview    `json:"preview,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Revisions template revisions applied
+	Revisions []AppliedRevision `json:"revisions,omitempty"`
 }
 
+const (
+	// ConditionConflict objects are owned by other parameters
+	ConditionConflict = "Conflict"
+	// ConditionHealthy all generated objects reached the desired state
+	ConditionHealthy = "Healthy"
+	// ConditionMigrated stored values were rewritten by template migrations
+	ConditionMigrated = "Migrated"
+	// ConditionNamespaceAllowed templates allow parameters namespace
+	ConditionNamespaceAllowed = "NamespaceAllowed"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:printcolumn:name="status",type=string,JSONPath=`.status.status`
+// +kubebuilder:printcolumn:name="healthy",type=string,JSONPath=`.status.conditions[?(@.type=="Healthy")].status`
 // +kubebuilder:printcolumn:name="age",type=date,JSONPath=`.metadata.creationTimestamp`
 // +kubebuilder:subresource:status
 
@@ -33,9 +33,9 @@ func (a *ObjectTemplateParamsSpec) GetParametersByTemplateName(templateName stri
 
 // SetValuesByName set values for specific parameter template
 func (a *ObjectTemplateParamsSpec) SetValuesByName(parameterName string, values map[string]string) bool {
-	for _, parameter := range a.Templates {
-		if parameter.Name == parameterName {
-			parameter.Values = values
+	for i := range a.Templates {
+		if a.Templates[i].Name == parameterName {
+			a.Templates[i].Values = values
 			return true
 		}
 	}
@@ -0,0 +1,76 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AllowedKind kind of objects templates are allowed to create
+type AllowedKind struct {
+	// Group API group (empty for core group, * for any group)
+	Group string `json:"group,omitempty"`
+	// Version API version (any version if empty or *)
+	Version string `json:"version,omitempty"`
+	// Kind object kind (* for any kind of the group)
+	Kind string `json:"kind"`
+}
+
+// ForbiddenField field that generated objects must not have
+type ForbiddenField struct {
+	// Kinds kinds checked (all kinds if empty)
+	Kinds []string `json:"kinds,omitempty"`
+	// JSONPath expression evaluated on the generated object (e.g. {.spec.template.spec.hostNetwork})
+	JSONPath string `json:"jsonPath"`
+	// Value forbidden value returned by JSONPath (any non empty value if not set)
+	Value string `json:"value,omitempty"`
+}
+
+// ObjectTemplatePolicySpec defines the desired state of ObjectTemplatePolicy
+type ObjectTemplatePolicySpec struct {
+	// AllowedKinds kinds templates are allowed to create (all kinds if empty)
+	AllowedKinds []AllowedKind `json:"allowedKinds,omitempty"`
+	// ForbiddenFields fields generated objects must not have
+	ForbiddenFields []ForbiddenField `json:"forbiddenFields,omitempty"`
+	// AllowedNamespaces namespace names or globs objects can be created in (all namespaces if empty)
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=objecttemplatepolicies,scope=Cluster
+// +kubebuilder:printcolumn:name="age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ObjectTemplatePolicy is the Schema for the objecttemplatepolicies API
+type ObjectTemplatePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ObjectTemplatePolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ObjectTemplatePolicyList contains a list of ObjectTemplatePolicy
+type ObjectTemplatePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ObjectTemplatePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ObjectTemplatePolicy{}, &ObjectTemplatePolicyList{})
+}
@@ -0,0 +1,63 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RevisionLatest follow the latest template revision
+const RevisionLatest = "latest"
+
+// ObjectTemplateRevisionSpec defines an immutable snapshot of an ObjectTemplate spec
+type ObjectTemplateRevisionSpec struct {
+	// TemplateName name of the template
+	TemplateName string `json:"templateName"`
+	// Revision sequential revision number
+	Revision int64 `json:"revision"`
+	// Hash hash of the template spec
+	Hash string `json:"hash"`
+	// Template snapshot of the template spec
+	Template ObjectTemplateSpec `json:"template"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=objecttemplaterevisions,scope=Cluster
+// +kubebuilder:printcolumn:name="template",type=string,JSONPath=`.spec.templateName`
+// +kubebuilder:printcolumn:name="revision",type=integer,JSONPath=`.spec.revision`
+// +kubebuilder:printcolumn:name="age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ObjectTemplateRevision is the Schema for the objecttemplaterevisions API
+type ObjectTemplateRevision struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ObjectTemplateRevisionSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ObjectTemplateRevisionList contains a list of ObjectTemplateRevision
+type ObjectTemplateRevisionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ObjectTemplateRevision `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ObjectTemplateRevision{}, &ObjectTemplateRevisionList{})
+}
@@ -0,0 +1,87 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// SourceLabel name of the template source that manages a template
+	SourceLabel = "template.k8s.ericogr.com.br/source"
+	// SourceConfigMapLabel label required on config maps read by template sources (only labeled config maps are watched)
+	SourceConfigMapLabel = "template.k8s.ericogr.com.br/source-config-map"
+	// SourceConfigMapValue value of SourceConfigMapLabel
+	SourceConfigMapValue = "true"
+)
+
+// ConfigMapSourceRef config map with template files
+type ConfigMapSourceRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// TemplateSourceSpec defines the desired state of TemplateSource
+type TemplateSourceSpec struct {
+	// ConfigMap config map with template files (keys ending with .yaml, .yml or .json)
+	ConfigMap *ConfigMapSourceRef `json:"configMap,omitempty"`
+	// Directory directory in the operator filesystem with template files (e.g. a mounted volume, subdirectories are not read)
+	Directory string `json:"directory,omitempty"`
+	// SyncPeriod period to read the source again (uses operator default if not set)
+	SyncPeriod *metav1.Duration `json:"syncPeriod,omitempty"`
+	// Prune delete templates removed from the source
+	Prune bool `json:"prune,omitempty"`
+	// DeletionPolicy what to do with templates of this source when it is deleted (default Orphan)
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// TemplateSourceStatus defines the observed state of TemplateSource
+type TemplateSourceStatus struct {
+	Status string `json:"status"`
+	// Templates names of templates managed by this source
+	Templates []string `json:"templates,omitempty"`
+	// LastSyncTime time of the last successful sync
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=templatesources,scope=Cluster
+// +kubebuilder:printcolumn:name="status",type=string,JSONPath=`.status.status`
+// +kubebuilder:printcolumn:name="age",type=date,JSONPath=`.metadata.creationTimestamp`
+// +kubebuilder:subresource:status
+
+// TemplateSource is the Schema for the templatesources API
+type TemplateSource struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TemplateSourceSpec   `json:"spec,omitempty"`
+	Status TemplateSourceStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TemplateSourceList contains a list of TemplateSource
+type TemplateSourceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TemplateSource `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TemplateSource{}, &TemplateSourceList{})
+}
@@ -0,0 +1,301 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	controllers "github.com/ericogr/k8s-object-template/controllers/template"
+)
+
+// sourceFile YAML document of a file with its node tree
+type sourceFile struct {
+	name string
+	// data selected document as JSON
+	data  []byte
+	lines []string
+	root  *yaml.Node
+}
+
+// readSource read a YAML file and select its only document of kind, keeping node positions of the file
+func readSource(name string, kind string) (*sourceFile, error) {
+	data, err := ioutil.ReadFile(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	documents := []*yaml.Node{}
+	for {
+		document := &yaml.Node{}
+		err := decoder.Decode(document)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+
+		source := &sourceFile{root: document}
+		if node := source.find("kind"); node != nil && node.Value == kind {
+			documents = append(documents, document)
+		}
+	}
+
+	if len(documents) != 1 {
+		return nil, fmt.Errorf("%v: expected one %v document, found %v", name, kind, len(documents))
+	}
+
+	content := map[string]interface{}{}
+	if err := documents[0].Decode(&content); err != nil {
+		return nil, fmt.Errorf("%v: %w", name, err)
+	}
+
+	document, err := json.Marshal(content)
+
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", name, err)
+	}
+
+	return &sourceFile{name: name, data: document, lines: strings.Split(string(data), "\n"), root: documents[0]}, nil
+}
+
+// find node by path of mapping keys (string) and sequence indexes (int)
+func (f *sourceFile) find(path ...interface{}) *yaml.Node {
+	node := f.root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, element := range path {
+		var next *yaml.Node
+
+		switch key := element.(type) {
+		case string:
+			if node.Kind != yaml.MappingNode {
+				return nil
+			}
+
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case int:
+			if node.Kind != yaml.SequenceNode || key >= len(node.Content) {
+				return nil
+			}
+			next = node.Content[key]
+		}
+
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+
+	return node
+}
+
+// position file line and column of a line and column of a scalar value (columns are 1-based, 0 if unknown)
+func (f *sourceFile) position(node *yaml.Node, line int, column int) (int, int) {
+	if node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		// block content starts in the line after the indicator
+		fileLine := node.Line + line
+
+		if column == 0 || fileLine > len(f.lines) {
+			return fileLine, 0
+		}
+
+		return fileLine, column + f.blockIndentation(node)
+	}
+
+	if line > 1 || column == 0 {
+		return node.Line + line - 1, 0
+	}
+
+	if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0 {
+		column++
+	}
+
+	return node.Line, node.Column + column - 1
+}
+
+// blockIndentation indentation of a block scalar, set by its first non empty line (template lines can be more indented)
+func (f *sourceFile) blockIndentation(node *yaml.Node) int {
+	for _, content := range f.lines[node.Line:] {
+		if len(strings.TrimSpace(content)) > 0 {
+			return len(content) - len(strings.TrimLeft(content, " "))
+		}
+	}
+
+	return 0
+}
+
+// templateSource template text that failed to render
+type templateSource struct {
+	file *sourceFile
+	node *yaml.Node
+	// names names used by renderers in error positions
+	names map[string]bool
+	// zeroBased go templates report 0-based columns
+	zeroBased bool
+}
+
+// errorLocator translate template positions of errors to file positions
+type errorLocator struct {
+	template *sourceFile
+	params   *sourceFile
+	ot       otv1.ObjectTemplate
+	otp      otv1.ObjectTemplateParams
+}
+
+// locate error message with file positions of the failed object (errors of parameters are not specific to the object)
+func (l *errorLocator) locate(obj otv1.Object, err error) string {
+	reference := fmt.Sprintf("[%v(%v)] ", obj.Kind, obj.Name)
+	var parameterError *controllers.ParameterError
+
+	// parameters are rendered for every object
+	if errors.As(err, &parameterError) {
+		reference = ""
+	}
+	sources := l.sources(obj, err)
+	message := err.Error()
+	location := l.template.name
+
+	for _, source := range sources {
+		if source.node == nil {
+			continue
+		}
+
+		if location == l.template.name {
+			location = fmt.Sprintf("%v:%v", source.file.name, source.node.Line)
+		}
+
+		position := regexp.MustCompile(fmt.Sprintf(`(%v):(\d+)(?::(\d+))?`, namesPattern(source.names)))
+		if !position.MatchString(message) {
+			continue
+		}
+
+		location = ""
+		message = position.ReplaceAllStringFunc(message, func(match string) string {
+			parts := position.FindStringSubmatch(match)
+			line, _ := strconv.Atoi(parts[2])
+			column, _ := strconv.Atoi(parts[3])
+			if len(parts[3]) > 0 && source.zeroBased {
+				column++
+			}
+
+			fileLine, fileColumn := source.file.position(source.node, line, column)
+			replaced := fmt.Sprintf("%v:%v", source.file.name, fileLine)
+			if fileColumn > 0 {
+				replaced = fmt.Sprintf("%v:%v", replaced, fileColumn)
+			}
+
+			if len(location) == 0 {
+				location = replaced
+			}
+
+			return replaced
+		})
+		break
+	}
+
+	return fmt.Sprintf("%v: %v%v", location, reference, message)
+}
+
+// sources template texts that may have caused the error
+func (l *errorLocator) sources(obj otv1.Object, err error) []templateSource {
+	goTemplate := map[string]bool{"template": true}
+	var parameterError *controllers.ParameterError
+
+	if errors.As(err, &parameterError) {
+		parameters, _ := l.otp.Spec.GetParametersByTemplateName(l.ot.Name)
+		sources := []templateSource{}
+
+		if len(parameters.Values[parameterError.Name]) > 0 {
+			for i, template := range l.otp.Spec.Templates {
+				if template.Name == l.ot.Name {
+					node := l.params.find("spec", "templates", i, "values", parameterError.Name)
+					sources = append(sources, templateSource{file: l.params, node: node, names: goTemplate, zeroBased: true})
+				}
+			}
+		}
+
+		for i, parameter := range l.ot.Spec.Parameters {
+			if parameter.Name == parameterError.Name {
+				node := l.template.find("spec", "parameters", i, "default")
+				sources = append(sources, templateSource{file: l.template, node: node, names: goTemplate, zeroBased: true})
+			}
+		}
+
+		return sources
+	}
+
+	index := -1
+	for i, templateObj := range l.ot.Spec.Objects {
+		if templateObj.Kind == obj.Kind && templateObj.Name == obj.Name && templateObj.APIVersion == obj.APIVersion {
+			index = i
+			break
+		}
+	}
+
+	// chart objects are not in the template file
+	if index < 0 {
+		return nil
+	}
+
+	sources := []templateSource{}
+	for i := range obj.Patches {
+		node := l.template.find("spec", "objects", index, "patches", i, "patch")
+		sources = append(sources, templateSource{file: l.template, node: node, names: map[string]bool{fmt.Sprintf("patch-%v", i): true}, zeroBased: true})
+	}
+
+	node := l.template.find("spec", "objects", index, "templateBody")
+	sources = append(sources,
+		templateSource{file: l.template, node: node, names: goTemplate, zeroBased: true},
+		// jsonnet and cue report positions using the object name
+		templateSource{file: l.template, node: node, names: map[string]bool{obj.Name: true}},
+	)
+
+	return sources
+}
+
+// namesPattern regular expression matching any of names
+func namesPattern(names map[string]bool) string {
+	quoted := []string{}
+	for name := range names {
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+
+	return strings.Join(quoted, "|")
+}
@@ -0,0 +1,227 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	paramsDocument = `apiVersion: template.k8s.ericogr.com.br/v1
+kind: ObjectTemplateParams
+metadata:
+  name: params
+  namespace: team-a
+spec:
+  templates:
+  - name: settings
+    values:
+      mode: %v
+`
+	templateDocument = `apiVersion: template.k8s.ericogr.com.br/v1
+kind: ObjectTemplate
+metadata:
+  name: settings
+spec:
+  parameters:
+  - name: mode
+    default: %v
+  objects:
+  - kind: ConfigMap
+    apiVersion: v1
+    name: settings
+`
+)
+
+// renderFiles render files written to a temporary directory, returning errors without the directory
+func renderFiles(t *testing.T, template string, params string) (string, error) {
+	directory, err := ioutil.TempDir("", "ot-render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	templateFile := filepath.Join(directory, "objecttemplate.yaml")
+	paramsFile := filepath.Join(directory, "params.yaml")
+	if err := ioutil.WriteFile(templateFile, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(paramsFile, []byte(params), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	_, err = render(out, errOut, ctrllog.NullLogger{}, templateFile, paramsFile, "", "")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ReplaceAll(errOut.String(), directory+string(filepath.Separator), ""), nil
+}
+
+func TestLocateErrors(t *testing.T) {
+	withParams := func(value string) string {
+		return strings.Replace(paramsDocument, "%v", value, 1)
+	}
+	withTemplate := func(defaultValue string, object string) string {
+		return strings.Replace(templateDocument, "%v", defaultValue, 1) + object
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		params   string
+		expected string
+	}{
+		{
+			name: "template body block",
+			template: withTemplate("base", `    templateBody: |-
+      data:
+        mode: {{ .mode }}
+        size: {{ index .missing "size" }}
+`),
+			params:   withParams("production"),
+			expected: `objecttemplate.yaml:16:18: [ConfigMap(settings)] template: objecttemplate.yaml:16:18: executing "template" at <index .missing "size">: error calling index: index of untyped nil` + "\n",
+		},
+		{
+			name:     "template body quoted",
+			template: withTemplate("base", "    templateBody: '{{ index .missing \"size\" }}'\n"),
+			params:   withParams("production"),
+			expected: `objecttemplate.yaml:13:23: [ConfigMap(settings)] template: objecttemplate.yaml:13:23: executing "template" at <index .missing "size">: error calling index: index of untyped nil` + "\n",
+		},
+		{
+			name: "template body quoted without column",
+			template: withTemplate("base", `    templateBody: "data: {mode: '{{ nope }}'}"
+`),
+			params:   withParams("production"),
+			expected: `objecttemplate.yaml:13: [ConfigMap(settings)] template: objecttemplate.yaml:13: function "nope" not defined` + "\n",
+		},
+		{
+			name:     "parameter default",
+			template: withTemplate(`"{{ .__namespace"`, "    templateBody: 'data: {}'\n"),
+			params:   withParams(`""`),
+			expected: "objecttemplate.yaml:8: parameter mode: template: objecttemplate.yaml:8: unclosed action\n",
+		},
+		{
+			name:     "parameter value",
+			template: withTemplate("base", "    templateBody: 'data: {}'\n"),
+			params:   withParams(`"{{ nope }}"`),
+			expected: `params.yaml:10: parameter mode: template: params.yaml:10: function "nope" not defined` + "\n",
+		},
+		{
+			name: "patch",
+			template: withTemplate("base", `    base:
+      data:
+        mode: base
+    patches:
+    - patch: |-
+        data:
+          mode: {{ nope }}
+`),
+			params:   withParams("production"),
+			expected: `objecttemplate.yaml:19: [ConfigMap(settings)] template: objecttemplate.yaml:19: function "nope" not defined` + "\n",
+		},
+		{
+			name: "multi-document template file",
+			template: "# namespace of the team\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: team-a\n---\n" + withTemplate("base", `    templateBody: |-
+      data:
+        mode: {{ nope }}
+`) + "---\n",
+			params:   withParams("production"),
+			expected: `objecttemplate.yaml:21: [ConfigMap(settings)] template: objecttemplate.yaml:21: function "nope" not defined` + "\n",
+		},
+		{
+			name:     "multi-document parameters file",
+			template: withTemplate("base", "    templateBody: 'data: {}'\n"),
+			params:   "---\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: team-a\n---\n" + withParams(`"{{ nope }}"`),
+			expected: `params.yaml:16: parameter mode: template: params.yaml:16: function "nope" not defined` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message, err := renderFiles(t, test.template, test.params)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if message != test.expected {
+				t.Errorf("expected %q, found %q", test.expected, message)
+			}
+		})
+	}
+}
+
+func TestReadSourceDocuments(t *testing.T) {
+	template := strings.Replace(templateDocument, "%v", "base", 1)
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "single document", content: template},
+		{name: "document after other kinds", content: "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: team-a\n---\n" + template},
+		{name: "empty documents", content: "---\n# templates\n---\n" + template + "---\n"},
+		{name: "without document of kind", content: "apiVersion: v1\nkind: Namespace\n", err: "expected one ObjectTemplate document, found 0"},
+		{name: "many documents of kind", content: template + "---\n" + template, err: "expected one ObjectTemplate document, found 2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := ioutil.TempFile("", "objecttemplate-*.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(file.Name())
+
+			if _, err := file.WriteString(test.content); err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+
+			source, err := readSource(file.Name(), "ObjectTemplate")
+
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, found %v", test.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if node := source.find("metadata", "name"); node == nil || node.Value != "settings" {
+				t.Errorf("expected template settings, found %v", node)
+			}
+
+			if !strings.Contains(string(source.data), `"name":"settings"`) {
+				t.Errorf("expected document data of template settings, found %s", source.data)
+			}
+		})
+	}
+}
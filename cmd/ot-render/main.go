@@ -0,0 +1,250 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// ot-render renders the objects of an ObjectTemplate using ObjectTemplateParams without a cluster
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/yaml"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	controllers "github.com/ericogr/k8s-object-template/controllers/template"
+)
+
+const (
+	exitRenderError = 1
+	exitUsageError  = 2
+)
+
+var (
+	scheme = runtime.NewScheme()
+)
+
+func init() {
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = otv1.AddToScheme(scheme)
+}
+
+func main() {
+	// jsonnet, cue and helm charts are evaluated by a child process of this binary, killed after the template timeout
+	if len(os.Args) > 1 && os.Args[1] == controllers.EvaluatorArg {
+		if err := controllers.RunEvaluator(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var templateFile string
+	var paramsFile string
+	var namespaceName string
+	var objectsFile string
+	var verbose bool
+	var templateOptions controllers.TemplateOptions
+	flag.StringVar(&templateFile, "template", "", "ObjectTemplate YAML file.")
+	flag.StringVar(&paramsFile, "params", "", "ObjectTemplateParams YAML file.")
+	flag.StringVar(&namespaceName, "namespace", "", "Namespace of the parameters (default parameters namespace).")
+	flag.StringVar(&objectsFile, "objects", "",
+		"YAML file with objects available to lookup functions, policies and chart sources "+
+			"(a Namespace with the parameters namespace name sets namespace labels and annotations).")
+	flag.BoolVar(&verbose, "v", false, "Show operator logs.")
+	flag.BoolVar(&templateOptions.UnsafeFunctions, "template-unsafe-functions", false,
+		"Allow template functions that read the environment or are not deterministic.")
+	flag.DurationVar(&templateOptions.Timeout, "template-timeout", 5*time.Second,
+		"Maximum execution time of a template. Zero disables the timeout.")
+	flag.IntVar(&templateOptions.MaxOutputSize, "template-max-output-size", 1024*1024,
+		"Maximum size in bytes of a rendered template. Zero disables the limit.")
+	flag.StringVar(&templateOptions.ClusterName, "cluster-name", "",
+		"Cluster name available to templates as __clusterName.")
+	flag.BoolVar(&templateOptions.UnrestrictedLookups, "template-unrestricted-lookups", false,
+		"Allow templates without a service account to look up Secrets and objects of other namespaces.")
+	flag.Parse()
+
+	if len(templateFile) == 0 || len(paramsFile) == 0 {
+		fmt.Fprintln(os.Stderr, "ot-render: -template and -params are required")
+		flag.Usage()
+		os.Exit(exitUsageError)
+	}
+
+	evaluator, err := controllers.EvaluatorCommand()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ot-render: %v\n", err)
+		os.Exit(exitUsageError)
+	}
+	templateOptions.Evaluator = evaluator
+	controllers.SetTemplateOptions(templateOptions)
+	var log logr.Logger = ctrllog.NullLogger{}
+	if verbose {
+		log = zap.New(zap.UseDevMode(true), zap.WriteTo(os.Stderr))
+	}
+
+	failed, err := render(os.Stdout, os.Stderr, log, templateFile, paramsFile, objectsFile, namespaceName)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ot-render: %v\n", err)
+		os.Exit(exitUsageError)
+	}
+
+	if failed {
+		os.Exit(exitRenderError)
+	}
+}
+
+// render print rendered objects to out and errors to errOut, returning if any object failed
+func render(out io.Writer, errOut io.Writer, log logr.Logger, templateFile, paramsFile, objectsFile, namespaceName string) (bool, error) {
+	templateYAML, err := readSource(templateFile, "ObjectTemplate")
+
+	if err != nil {
+		return false, err
+	}
+
+	paramsYAML, err := readSource(paramsFile, "ObjectTemplateParams")
+
+	if err != nil {
+		return false, err
+	}
+
+	ot := otv1.ObjectTemplate{}
+	if err := yaml.UnmarshalStrict(templateYAML.data, &ot); err != nil {
+		return false, fmt.Errorf("%v: %w", templateFile, err)
+	}
+
+	otp := otv1.ObjectTemplateParams{}
+	if err := yaml.UnmarshalStrict(paramsYAML.data, &otp); err != nil {
+		return false, fmt.Errorf("%v: %w", paramsFile, err)
+	}
+
+	if len(namespaceName) > 0 {
+		otp.Namespace = namespaceName
+	}
+
+	if len(otp.Namespace) == 0 {
+		return false, errors.New("parameters namespace is not set, use -namespace")
+	}
+
+	objects, err := readObjects(objectsFile, otp.Namespace)
+
+	if err != nil {
+		return false, err
+	}
+
+	common := controllers.Common{Client: fake.NewFakeClientWithScheme(scheme, objects...), Log: log, Mapper: controllers.NewOfflineRESTMapper(scheme)}
+	rendered, err := common.RenderObjectsByTemplate(ot, otp)
+
+	if err != nil {
+		return false, fmt.Errorf("%v: %w", templateFile, err)
+	}
+
+	locator := &errorLocator{template: templateYAML, params: paramsYAML, ot: ot, otp: otp}
+	failed := false
+	reported := map[string]bool{}
+	for _, obj := range rendered {
+		if obj.Err != nil {
+			failed = true
+
+			if message := locator.locate(obj.Source, obj.Err); !reported[message] {
+				reported[message] = true
+				fmt.Fprintln(errOut, message)
+			}
+			continue
+		}
+
+		manifest, err := yaml.Marshal(obj.Object.Object)
+
+		if err != nil {
+			return failed, err
+		}
+
+		fmt.Fprintf(out, "---\n%s", manifest)
+	}
+
+	return failed, nil
+}
+
+// readObjects objects of a YAML file and the namespace used by parameters
+func readObjects(objectsFile string, namespaceName string) ([]runtime.Object, error) {
+	objects := []runtime.Object{}
+	namespaceFound := false
+
+	if len(objectsFile) > 0 {
+		data, err := ioutil.ReadFile(objectsFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+		for {
+			obj := unstructured.Unstructured{}
+			err := decoder.Decode(&obj.Object)
+
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", objectsFile, err)
+			}
+
+			if len(obj.Object) == 0 {
+				continue
+			}
+
+			if obj.GetKind() == "Namespace" && obj.GetName() == namespaceName {
+				namespaceFound = true
+			}
+
+			// kinds known by scheme (e.g. policies) are read as typed objects by the operator
+			typed, err := scheme.New(obj.GroupVersionKind())
+
+			if err != nil {
+				objects = append(objects, &obj)
+				continue
+			}
+
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, typed); err != nil {
+				return nil, fmt.Errorf("%v: %v(%v): %w", objectsFile, obj.GetKind(), obj.GetName(), err)
+			}
+
+			objects = append(objects, typed)
+		}
+	}
+
+	if !namespaceFound {
+		objects = append(objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespaceName}})
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,104 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestRenderClusterScopedObjects(t *testing.T) {
+	template := strings.Replace(templateDocument, "%v", "base", 1) + "    templateBody: 'data: {}'\n" + `  - kind: ClusterRole
+    apiVersion: rbac.authorization.k8s.io/v1
+    name: team-a-reader
+    templateBody: 'rules: []'
+`
+	params := strings.Replace(paramsDocument, "%v", "base", 1)
+	policy := `apiVersion: template.k8s.ericogr.com.br/v1
+kind: ObjectTemplatePolicy
+metadata:
+  name: namespaces
+spec:
+  allowedNamespaces:
+  - team-*
+`
+
+	tests := []struct {
+		name     string
+		objects  string
+		expected []string
+		failed   bool
+	}{
+		{name: "without policies", expected: []string{"namespace: team-a", "kind: ClusterRole"}},
+		{name: "with policy namespaces", objects: policy, failed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory, err := ioutil.TempDir("", "ot-render")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(directory)
+
+			files := map[string]string{"objecttemplate.yaml": template, "params.yaml": params, "objects.yaml": test.objects}
+			for name, content := range files {
+				if err := ioutil.WriteFile(filepath.Join(directory, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			objectsFile := ""
+			if len(test.objects) > 0 {
+				objectsFile = filepath.Join(directory, "objects.yaml")
+			}
+
+			out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+			failed, err := render(out, errOut, ctrllog.NullLogger{}, filepath.Join(directory, "objecttemplate.yaml"), filepath.Join(directory, "params.yaml"), objectsFile, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if failed != test.failed {
+				t.Fatalf("expected failed %v, found %v: %v", test.failed, failed, errOut.String())
+			}
+
+			documents := strings.Split(out.String(), "---\n")
+			for _, document := range documents {
+				if strings.Contains(document, "kind: ClusterRole") && strings.Contains(document, "\n  namespace:") {
+					t.Errorf("cluster scoped object rendered with namespace:\n%v", document)
+				}
+			}
+
+			for _, expected := range test.expected {
+				if !strings.Contains(out.String(), expected) {
+					t.Errorf("expected %q in output:\n%v", expected, out.String())
+				}
+			}
+
+			if test.failed && !strings.Contains(errOut.String(), "namespace  is not allowed") {
+				t.Errorf("expected namespace policy error, found %q", errOut.String())
+			}
+		})
+	}
+}
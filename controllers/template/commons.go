@@ -2,16 +2,19 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -25,30 +28,152 @@ var (
 // Common common controllers things
 type Common struct {
 	client.Client
-	Log logr.Logger
+	Log          logr.Logger
+	Mapper       meta.RESTMapper
+	Impersonator *Impersonator
+	Lookups      *LookupTracker
+	// ConfigMapReader reader of template source config maps (uses Client if not set)
+	ConfigMapReader client.Reader
+
+	recorder  *lookupRecorder
+	rendering *renderContext
 }
 
-// UpdateObjectsByTemplate update object
-func (c *Common) UpdateObjectsByTemplate(ot otv1.ObjectTemplate, owners []metav1.OwnerReference, namespaceName string, paramsValues map[string]string) (err error) {
-	for _, obj := range ot.Spec.Objects {
-		normParams, err := c.normalizeParametersValues(obj, namespaceName, ot.Spec.Parameters, paramsValues)
+// UpdateObjectsByTemplate update objects from template using parameters values
+func (c *Common) UpdateObjectsByTemplate(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) (err error) {
+	parameters, err := otp.Spec.GetParametersByTemplateName(ot.Name)
 
-		if err != nil {
-			return err
+	if err != nil {
+		return err
+	}
+
+	objects, err := sortObjectsByDependencies(ot.Spec.Objects)
+
+	if err != nil {
+		return err
+	}
+
+	rc, err := c.renderingFor(ot, otp)
+
+	if err != nil {
+		return err
+	}
+	defer rc.trackLookups(ot, otp)
+
+	required := requiredObjects(objects)
+	applied := map[string]bool{}
+	notReady := map[string]bool{}
+	waiting := []string{}
+	deleting := []string{}
+	objectsError := &ObjectsError{}
+	unapplied := map[string]bool{}
+	for i, obj := range objects {
+		// objects depending on failed objects would wait for them forever
+		if dependencyUnapplied(obj, unapplied) {
+			objectsError.Skipped = append(objectsError.Skipped, obj.Name)
+			unapplied[obj.Name] = true
+			continue
 		}
 
-		err = c.UpdateSingleObjectByTemplate(obj, owners, namespaceName, normParams)
+		if !dependenciesReady(obj, applied, notReady) {
+			waiting = append(waiting, obj.Name)
+			continue
+		}
+
+		ready, err := rc.applyObject(ot, otp, obj, parameters.Values, required[obj.Name])
+
+		if errors.Is(err, errObjectDeleting) {
+			deleting = append(deleting, obj.Name)
+			continue
+		}
 
 		if err != nil {
-			return err
+			objectsError.Errors = append(objectsError.Errors, err)
+			objectsError.Failed = append(objectsError.Failed, obj.Name)
+			unapplied[obj.Name] = true
+
+			if ot.Spec.FailurePolicy == otv1.FailurePolicyAbort {
+				for _, skipped := range objects[i+1:] {
+					objectsError.Skipped = append(objectsError.Skipped, skipped.Name)
+				}
+				break
+			}
+			continue
 		}
+
+		applied[obj.Name] = true
+		notReady[obj.Name] = notReady[obj.Name] || !ready
 	}
 
-	return nil
+	if len(waiting) > 0 || len(deleting) > 0 {
+		objectsError.NotReady = &NotReadyError{Objects: waiting, Deleting: deleting}
+	}
+
+	return objectsError.ErrorOrNil()
+}
+
+// applyObject render and update a single object, checking readiness if required
+func (c *Common) applyObject(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams, obj otv1.Object, paramsValues map[string]string, checkReadiness bool) (bool, error) {
+	normParams, err := c.normalizeParametersValues(obj, otp.Namespace, ot.Spec.Parameters, ot.Spec.Migrations, paramsValues)
+
+	if err != nil {
+		return false, fmt.Errorf("Error processing parameters of [%v(%v)]: %w", obj.Kind, obj.Name, err)
+	}
+
+	if err := c.UpdateSingleObjectByTemplate(ot, otp, obj, normParams); err != nil {
+		return false, err
+	}
+
+	if !checkReadiness {
+		return true, nil
+	}
+
+	return c.isObjectReady(obj, otp.Namespace)
 }
 
-func (c *Common) normalizeParametersValues(obj otv1.Object, namespaceName string, templateParamsValues []otv1.Parameter, paramsValues map[string]string) (params map[string]string, err error) {
+// dependencyUnapplied object depends on an object that failed or was skipped
+func dependencyUnapplied(obj otv1.Object, unapplied map[string]bool) bool {
+	for _, dependency := range obj.DependsOn {
+		if unapplied[dependency] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func dependenciesReady(obj otv1.Object, applied map[string]bool, notReady map[string]bool) bool {
+	for _, dependency := range obj.DependsOn {
+		if !applied[dependency] || notReady[dependency] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParameterError parameter value or default failed to render
+type ParameterError struct {
+	Name string
+	Err  error
+}
+
+func (e *ParameterError) Error() string {
+	return fmt.Sprintf("parameter %v: %v", e.Name, e.Err)
+}
+
+// Unwrap render error
+func (e *ParameterError) Unwrap() error {
+	return e.Err
+}
+
+func (c *Common) normalizeParametersValues(obj otv1.Object, namespaceName string, templateParamsValues []otv1.Parameter, migrations []otv1.ParameterMigration, paramsValues map[string]string) (params map[string]string, err error) {
 	templateValues := c.addRuntimeVariablesToMap(map[string]string{}, obj, namespaceName)
+	paramsValues, _, err = migrateParametersValues(migrations, paramsValues, false)
+
+	if err != nil {
+		return nil, err
+	}
 
 	params = map[string]string{}
 	for _, tp := range templateParamsValues {
@@ -62,7 +187,7 @@ func (c *Common) normalizeParametersValues(obj otv1.Object, namespaceName string
 		templateExecuted, err := executeTemplate(pvalue, templateValues)
 
 		if err != nil {
-			return params, err
+			return params, &ParameterError{Name: tp.Name, Err: err}
 		}
 
 		params[tp.Name] = templateExecuted
@@ -72,34 +197,86 @@ func (c *Common) normalizeParametersValues(obj otv1.Object, namespaceName string
 }
 
 // UpdateSingleObjectByTemplate update object
-func (c *Common) UpdateSingleObjectByTemplate(obj otv1.Object, owners []metav1.OwnerReference, namespaceName string, values map[string]string) error {
+func (c *Common) UpdateSingleObjectByTemplate(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams, obj otv1.Object, values map[string]string) error {
 	ctx := context.Background()
 	log := c.Log.WithValues("objecttemplate", otGV)
+	namespaceName := otp.Namespace
 	reference := fmt.Sprintf("[%v(%v)] at %v namespace", obj.Kind, obj.Name, namespaceName)
 	log.Info(fmt.Sprintf("Ready to process %v", reference))
 
-	newObj, gvk, err := c.ToObject(obj, owners, values, namespaceName)
+	namespaced, err := c.isNamespaced(schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind))
+
+	if err != nil {
+		return fmt.Errorf("Error serializing %v: %v", reference, err.Error())
+	}
+
+	newObj, gvk, err := c.ToObject(obj, ownerReferencesFor(otp, namespaced), values, namespaceName)
 
 	if err != nil {
 		return fmt.Errorf("Error serializing %v: %v", reference, err.Error())
 	}
+	if !namespaced {
+		newObj.SetNamespace("")
+	}
+	setManagedMarkers(&newObj, ot, otp)
 	log.Info(fmt.Sprintf("Object encoded succefully %v", reference))
 
+	if err := c.CheckPolicies(newObj); err != nil {
+		return fmt.Errorf("Error validating %v: %w", reference, err)
+	}
+
+	objectsClient, err := c.objectsClient(ot, otp)
+
+	if err != nil {
+		return fmt.Errorf("Error updating object %v: %w", reference, err)
+	}
+
 	findObj := unstructured.Unstructured{}
 	findObj.SetName(obj.Name)
-	findObj.SetNamespace(namespaceName)
+	findObj.SetNamespace(newObj.GetNamespace())
 	findObj.SetGroupVersionKind(*gvk)
 
-	res, err := controllerutil.CreateOrUpdate(ctx, c.Client, &findObj, func() error {
-		findObj.Object["data"] = newObj.Object["data"]
-		findObj.Object["spec"] = newObj.Object["spec"]
-		findObj.SetLabels(newObj.GetLabels())
-		findObj.SetAnnotations(newObj.GetAnnotations())
+	suspended := false
+	res, err := controllerutil.CreateOrUpdate(ctx, objectsClient, &findObj, func() error {
+		if suspended = isObjectSuspended(findObj); suspended {
+			return nil
+		}
+
+		// object is created again when deletion completes
+		if findObj.GetDeletionTimestamp() != nil {
+			return errObjectDeleting
+		}
+
+		if err := checkAdoption(ot, findObj); err != nil {
+			return err
+		}
+
+		if err := c.checkConflict(otp, findObj); err != nil {
+			return err
+		}
+
+		if obj.UpdateStrategy == otv1.UpdateStrategyReplace {
+			replaceObject(&findObj, newObj)
+		} else {
+			mutateObject(&findObj, newObj)
+		}
+
+		if !namespaced {
+			// references set by previous versions are invalid for cluster scoped objects
+			findObj.SetOwnerReferences(removeOwnerReference(findObj.GetOwnerReferences(), otp.UID))
+		}
 		return nil
 	})
 
+	if err != nil && obj.UpdateStrategy == otv1.UpdateStrategyRecreate && isImmutableFieldError(err) {
+		log.Info(fmt.Sprintf("Immutable field changed, recreating %v", reference))
+		res, err = recreateObject(ctx, objectsClient, findObj)
+	}
+
 	if err == nil {
-		if res == controllerutil.OperationResultCreated {
+		if suspended {
+			log.Info(fmt.Sprintf("Suspended by annotation %v", reference))
+		} else if res == controllerutil.OperationResultCreated {
 			log.Info(fmt.Sprintf("Created succefully %v", reference))
 		} else if res == controllerutil.OperationResultUpdated {
 			log.Info(fmt.Sprintf("Update succefully %v", reference))
@@ -109,15 +286,63 @@ func (c *Common) UpdateSingleObjectByTemplate(obj otv1.Object, owners []metav1.O
 			log.Info(fmt.Sprintf("Unknown status %v for %v", res, reference))
 		}
 	} else {
-		return fmt.Errorf("Error updating object %v: %v", reference, err.Error())
+		return fmt.Errorf("Error updating object %v: %w", reference, err)
 	}
 
 	return nil
 }
 
-// FindObjectTemplateParamsByTemplateName find all ot params by template name
+// mutateObject copy templated fields from newObj to current object
+func mutateObject(current *unstructured.Unstructured, newObj unstructured.Unstructured) {
+	current.Object["data"] = newObj.Object["data"]
+	current.Object["spec"] = newObj.Object["spec"]
+	current.SetLabels(newObj.GetLabels())
+	current.SetAnnotations(newObj.GetAnnotations())
+	current.SetOwnerReferences(mergeOwnerReferences(current.GetOwnerReferences(), newObj.GetOwnerReferences()))
+}
+
+// replaceObject replace current object with newObj keeping server metadata
+func replaceObject(current *unstructured.Unstructured, newObj unstructured.Unstructured) {
+	replaced := newObj.DeepCopy()
+	replaced.SetResourceVersion(current.GetResourceVersion())
+	replaced.SetOwnerReferences(mergeOwnerReferences(current.GetOwnerReferences(), newObj.GetOwnerReferences()))
+	replaced.SetFinalizers(current.GetFinalizers())
+	current.Object = replaced.Object
+}
+
+// recreateObject delete current object waiting for its dependents (newObj is created by a later reconcile, after deletion completes)
+func recreateObject(ctx context.Context, c client.Client, current unstructured.Unstructured) (controllerutil.OperationResult, error) {
+	uid := current.GetUID()
+	err := c.Delete(ctx, &current, client.Preconditions{UID: &uid}, client.PropagationPolicy(metav1.DeletePropagationForeground))
+
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return controllerutil.OperationResultNone, err
+	}
+
+	return controllerutil.OperationResultNone, errObjectDeleting
+}
+
+// errObjectDeleting object must be deleted before it is created again
+var errObjectDeleting = errors.New("object is being deleted")
+
+// isImmutableFieldError update rejected because an immutable field changed
+func isImmutableFieldError(err error) bool {
+	return k8sErrors.IsInvalid(err) && strings.Contains(err.Error(), "immutable")
+}
+
+// isObjectSuspended object excluded from management by annotation
+func isObjectSuspended(obj unstructured.Unstructured) bool {
+	return obj.GetAnnotations()[otv1.SuspendAnnotation] == "true"
+}
+
+// FindObjectTemplateParamsByTemplateName find all ot params by template name (params from namespaces not allowed by template are ignored)
 func (c *Common) FindObjectTemplateParamsByTemplateName(templateName string) ([]otv1.ObjectTemplateParams, error) {
-	otParams, err := c.FindObjectTemplateParams()
+	otParams, err := c.FindAllObjectTemplateParamsByTemplateName(templateName)
+	if err != nil {
+		return nil, err
+	}
+
+	ot, err := c.GetObjectTemplateByName(templateName)
 	if err != nil {
 		return nil, err
 	}
@@ -125,11 +350,40 @@ func (c *Common) FindObjectTemplateParamsByTemplateName(templateName string) ([]
 	var otParamsRet []otv1.ObjectTemplateParams
 
 	for _, otParam := range otParams {
-		_, err := otParam.Spec.GetParametersByTemplateName(templateName)
+		if ot != nil {
+			allowed, err := c.isNamespaceAllowedByTemplate(*ot, otParam.Namespace)
+
+			if err != nil {
+				return nil, err
+			}
 
-		if err == nil {
-			otParamsRet = append(otParamsRet, otParam)
+			if !allowed {
+				continue
+			}
 		}
+
+		otParamsRet = append(otParamsRet, otParam)
+	}
+
+	return otParamsRet, nil
+}
+
+// FindAllObjectTemplateParamsByTemplateName find all ot params by template name, including params from namespaces not
+// allowed by template (they may have objects created before the namespace was disallowed)
+func (c *Common) FindAllObjectTemplateParamsByTemplateName(templateName string) ([]otv1.ObjectTemplateParams, error) {
+	otParams, err := c.FindObjectTemplateParams()
+	if err != nil {
+		return nil, err
+	}
+
+	var otParamsRet []otv1.ObjectTemplateParams
+
+	for _, otParam := range otParams {
+		if _, err := otParam.Spec.GetParametersByTemplateName(templateName); err != nil {
+			continue
+		}
+
+		otParamsRet = append(otParamsRet, otParam)
 	}
 
 	return otParamsRet, nil
@@ -200,22 +454,25 @@ func (c *Common) GetObjectSimplified(groupversion string, kind string, namespace
 
 // ToObject process object from template
 func (c *Common) ToObject(obj otv1.Object, owners []metav1.OwnerReference, values map[string]string, namespaceName string) (unstructured.Unstructured, *schema.GroupVersionKind, error) {
+	if err := validateObjectSource(obj); err != nil {
+		return unstructured.Unstructured{}, nil, err
+	}
+
 	templateValues := c.addRuntimeVariablesToMap(values, obj, namespaceName)
-	templateYAML := getStringObject(obj.APIVersion, obj.Kind, obj.TemplateBody)
-	templateYAMLExecuted, err := executeTemplate(templateYAML, templateValues)
+	renderer, err := c.rendererFor(obj, namespaceName)
 
 	if err != nil {
 		return unstructured.Unstructured{}, nil, err
 	}
 
-	object := unstructured.Unstructured{}
-	dec := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
-	_, _, err = dec.Decode([]byte(templateYAMLExecuted), nil, &object)
+	content, err := renderer.Render(obj, templateValues)
 
 	if err != nil {
-		return object, nil, err
+		return unstructured.Unstructured{}, nil, err
 	}
 
+	object := unstructured.Unstructured{Object: content}
+
 	gvk := schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind)
 
 	object.SetNamespace(namespaceName)
@@ -235,14 +492,22 @@ func (c *Common) UpdateStatus(ctx context.Context, obj runtime.Object) {
 	}
 }
 
-func (c *Common) addRuntimeVariablesToMap(values map[string]string, obj otv1.Object, namespaceName string) map[string]string {
-	newMap := copyMap(values)
+func (c *Common) addRuntimeVariablesToMap(values map[string]string, obj otv1.Object, namespaceName string) map[string]interface{} {
+	newMap := make(map[string]interface{})
+
+	for k, v := range values {
+		newMap[k] = v
+	}
 
 	newMap[prefix+"namespace"] = namespaceName
 	newMap[prefix+"apiVersion"] = obj.APIVersion
 	newMap[prefix+"kind"] = obj.Kind
 	newMap[prefix+"name"] = obj.Name
 
+	if c.rendering != nil {
+		c.rendering.addRuntimeVariables(newMap)
+	}
+
 	return newMap
 }
 
@@ -17,11 +17,42 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
+// immutableClient client rejecting all updates with an immutable field error
+type immutableClient struct {
+	client.Client
+}
+
+func (i immutableClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return immutableFieldError()
+}
+
+func immutableFieldError() error {
+	return k8sErrors.NewInvalid(schema.GroupKind{Kind: "ConfigMap"}, "settings", field.ErrorList{
+		field.Invalid(field.NewPath("data"), "new", "field is immutable"),
+	})
+}
+
 var _ = Describe("Controller commons", func() {
 	BeforeEach(func() {
 	})
@@ -37,4 +68,224 @@ var _ = Describe("Controller commons", func() {
 			})
 		})
 	})
+
+	Describe("Update strategies", func() {
+		var common Common
+		var fakeClient client.Client
+		otp := otv1.ObjectTemplateParams{
+			ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "team-a", UID: "params-uid"},
+			Spec:       otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{{Name: "settings"}}},
+		}
+		template := func(strategy otv1.UpdateStrategy, data string) otv1.ObjectTemplate {
+			return otv1.ObjectTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "settings"},
+				Spec: otv1.ObjectTemplateSpec{Objects: []otv1.Object{
+					{APIVersion: "v1", Kind: "ConfigMap", Name: "settings", UpdateStrategy: strategy, TemplateBody: "data:\n  value: " + data},
+					{APIVersion: "v1", Kind: "ConfigMap", Name: "dependent", DependsOn: []string{"settings"}, TemplateBody: "data: {}"},
+				}},
+			}
+		}
+		apply := func(ot otv1.ObjectTemplate) error {
+			return common.UpdateSingleObjectByTemplate(ot, otp, ot.Spec.Objects[0], map[string]string{})
+		}
+		get := func() (corev1.ConfigMap, error) {
+			cm := corev1.ConfigMap{}
+			err := fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "settings"}, &cm)
+			return cm, err
+		}
+
+		BeforeEach(func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(otv1.AddToScheme(scheme)).To(Succeed())
+			fakeClient = fake.NewFakeClientWithScheme(scheme, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}})
+			common = Common{Client: fakeClient, Log: ctrl.Log.WithName("test")}
+		})
+
+		It("Should detect immutable field errors", func() {
+			Expect(isImmutableFieldError(immutableFieldError())).To(BeTrue())
+			Expect(isImmutableFieldError(k8sErrors.NewInvalid(schema.GroupKind{Kind: "ConfigMap"}, "settings", field.ErrorList{
+				field.Required(field.NewPath("data"), "value is required"),
+			}))).To(BeFalse())
+			Expect(isImmutableFieldError(k8sErrors.NewBadRequest("field is immutable"))).To(BeFalse())
+			Expect(isImmutableFieldError(errors.New("field is immutable"))).To(BeFalse())
+		})
+
+		It("Should keep fields not rendered by template with Patch", func() {
+			Expect(apply(template(otv1.UpdateStrategyPatch, "old"))).To(Succeed())
+			cm, _ := get()
+			cm.BinaryData = map[string][]byte{"extra": []byte("kept")}
+			Expect(fakeClient.Update(context.Background(), &cm)).To(Succeed())
+
+			Expect(apply(template(otv1.UpdateStrategyPatch, "new"))).To(Succeed())
+			cm, _ = get()
+			Expect(cm.Data).To(Equal(map[string]string{"value": "new"}))
+			Expect(cm.BinaryData).To(HaveKey("extra"))
+		})
+
+		It("Should remove fields not rendered by template with Replace", func() {
+			Expect(apply(template(otv1.UpdateStrategyReplace, "old"))).To(Succeed())
+			cm, _ := get()
+			cm.BinaryData = map[string][]byte{"extra": []byte("removed")}
+			cm.Finalizers = []string{"example.com/keep"}
+			Expect(fakeClient.Update(context.Background(), &cm)).To(Succeed())
+
+			Expect(apply(template(otv1.UpdateStrategyReplace, "new"))).To(Succeed())
+			cm, _ = get()
+			Expect(cm.Data).To(Equal(map[string]string{"value": "new"}))
+			Expect(cm.BinaryData).To(BeEmpty())
+			Expect(cm.Finalizers).To(Equal([]string{"example.com/keep"}))
+			Expect(cm.OwnerReferences).To(HaveLen(1))
+		})
+
+		It("Should fail on immutable field changes without Recreate", func() {
+			Expect(apply(template(otv1.UpdateStrategyPatch, "old"))).To(Succeed())
+			common.Client = immutableClient{Client: fakeClient}
+
+			err := apply(template(otv1.UpdateStrategyPatch, "new"))
+			Expect(isImmutableFieldError(err)).To(BeTrue())
+			_, err = get()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should delete and create again on immutable field changes with Recreate", func() {
+			Expect(apply(template(otv1.UpdateStrategyRecreate, "old"))).To(Succeed())
+			common.Client = immutableClient{Client: fakeClient}
+
+			err := apply(template(otv1.UpdateStrategyRecreate, "new"))
+			Expect(errors.Is(err, errObjectDeleting)).To(BeTrue())
+			_, err = get()
+			Expect(k8sErrors.IsNotFound(err)).To(BeTrue())
+
+			Expect(apply(template(otv1.UpdateStrategyRecreate, "new"))).To(Succeed())
+			cm, err := get()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cm.Data).To(Equal(map[string]string{"value": "new"}))
+		})
+
+		It("Should wait for deletion of objects and their dependents", func() {
+			now := metav1.NewTime(time.Now())
+			Expect(fakeClient.Create(context.Background(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a", DeletionTimestamp: &now, Finalizers: []string{"example.com/keep"}},
+				Data:       map[string]string{"value": "old"},
+			})).To(Succeed())
+
+			err := common.UpdateObjectsByTemplate(template(otv1.UpdateStrategyRecreate, "new"), otp)
+
+			var notReady *NotReadyError
+			Expect(errors.As(err, &notReady)).To(BeTrue())
+			Expect(notReady.Deleting).To(Equal([]string{"settings"}))
+			Expect(notReady.Objects).To(Equal([]string{"dependent"}))
+			Expect(failedObjectsError(err)).To(BeNil())
+
+			cm, _ := get()
+			Expect(cm.Data).To(Equal(map[string]string{"value": "old"}))
+		})
+	})
+
+	Describe("Suspend", func() {
+		var reconciler *ObjectTemplateParamsReconciler
+		request := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "team-a", Name: "params"}}
+		template := &otv1.ObjectTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "settings"},
+			Spec: otv1.ObjectTemplateSpec{Objects: []otv1.Object{
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "settings", TemplateBody: "data:\n  value: new"},
+			}},
+		}
+		params := &otv1.ObjectTemplateParams{
+			ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "team-a", Finalizers: []string{otv1.Finalizer}},
+			Spec:       otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{{Name: "settings"}}},
+		}
+		setup := func(ot *otv1.ObjectTemplate, otp *otv1.ObjectTemplateParams, objects ...runtime.Object) {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(otv1.AddToScheme(scheme)).To(Succeed())
+			objects = append(objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}, ot, otp)
+			reconciler = &ObjectTemplateParamsReconciler{Client: fake.NewFakeClientWithScheme(scheme, objects...), Log: ctrl.Log.WithName("test"), Scheme: scheme}
+		}
+		reconcile := func() otv1.ObjectTemplateParams {
+			_, err := reconciler.Reconcile(request)
+			Expect(err).NotTo(HaveOccurred())
+
+			stored := otv1.ObjectTemplateParams{}
+			Expect(reconciler.Get(context.Background(), request.NamespacedName, &stored)).To(Succeed())
+			return stored
+		}
+		getConfigMap := func() (corev1.ConfigMap, error) {
+			cm := corev1.ConfigMap{}
+			err := reconciler.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "settings"}, &cm)
+			return cm, err
+		}
+
+		It("Should detect objects suspended by annotation", func() {
+			cm := unstructuredConfigMap(map[string]string{otv1.SuspendAnnotation: "true"})
+			Expect(isObjectSuspended(cm)).To(BeTrue())
+
+			cm = unstructuredConfigMap(map[string]string{otv1.SuspendAnnotation: "false"})
+			Expect(isObjectSuspended(cm)).To(BeFalse())
+			Expect(isObjectSuspended(unstructuredConfigMap(nil))).To(BeFalse())
+		})
+
+		It("Should not mutate suspended objects", func() {
+			setup(template.DeepCopy(), params.DeepCopy(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a", Annotations: map[string]string{otv1.SuspendAnnotation: "true"}},
+				Data:       map[string]string{"value": "old"},
+			})
+
+			stored := reconcile()
+			Expect(stored.Status.Status).To(Equal("OK"))
+
+			cm, err := getConfigMap()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cm.Data).To(Equal(map[string]string{"value": "old"}))
+			Expect(cm.OwnerReferences).To(BeEmpty())
+		})
+
+		It("Should not apply suspended parameters or templates", func() {
+			suspendedParams := params.DeepCopy()
+			suspendedParams.Spec.Suspend = true
+			setup(template.DeepCopy(), suspendedParams)
+
+			Expect(reconcile().Status.Status).To(Equal("Suspended"))
+			_, err := getConfigMap()
+			Expect(k8sErrors.IsNotFound(err)).To(BeTrue())
+
+			suspendedTemplate := template.DeepCopy()
+			suspendedTemplate.Spec.Suspend = true
+			setup(suspendedTemplate, params.DeepCopy())
+
+			reconcile()
+			_, err = getConfigMap()
+			Expect(k8sErrors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("Should preview suspended parameters and templates in dry-run", func() {
+			dryRunParams := params.DeepCopy()
+			dryRunParams.Spec.Suspend = true
+			dryRunParams.Spec.DryRun = true
+			setup(template.DeepCopy(), dryRunParams)
+
+			stored := reconcile()
+			Expect(stored.Status.Status).To(Equal("DryRun"))
+			Expect(stored.Status.Preview).To(HaveLen(1))
+			Expect(stored.Status.Preview[0].Action).To(Equal(previewActionCreate))
+
+			dryRunTemplate := template.DeepCopy()
+			dryRunTemplate.Spec.Suspend = true
+			dryRunTemplate.Spec.DryRun = true
+			setup(dryRunTemplate, params.DeepCopy())
+
+			stored = reconcile()
+			Expect(stored.Status.Preview).To(HaveLen(1))
+			Expect(stored.Status.Preview[0].Name).To(Equal("settings"))
+		})
+	})
 })
+
+func unstructuredConfigMap(annotations map[string]string) unstructured.Unstructured {
+	cm := unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	cm.SetAnnotations(annotations)
+	return cm
+}
@@ -0,0 +1,457 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// chartEngine evaluator engine rendering helm charts
+	chartEngine = "helm"
+	// defaultChartKey data key of chart archives in config maps and secrets
+	defaultChartKey = "chart.tgz"
+	// ociRefNameAnnotation tag of a manifest in an OCI image layout index
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+var (
+	// chartLayerMediaTypes media types of helm chart layers
+	chartLayerMediaTypes = map[string]bool{
+		"application/vnd.cncf.helm.chart.content.v1.tar+gzip": true,
+		"application/tar+gzip":                                true,
+	}
+	ociDigestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// ociDescriptor content descriptor of OCI indexes and manifests
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociIndex index.json of an OCI image layout
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// ociManifest OCI image manifest
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// expandChart template with objects rendered from its chart using parameters values
+func (c *Common) expandChart(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) (otv1.ObjectTemplate, error) {
+	source := ot.Spec.Chart
+
+	if source == nil {
+		return ot, nil
+	}
+
+	archive, err := c.loadChartArchive(*source)
+
+	if err != nil {
+		return ot, fmt.Errorf("Error loading chart of template %v: %w", ot.Name, err)
+	}
+
+	values, err := c.chartValues(ot, otp)
+
+	if err != nil {
+		return ot, fmt.Errorf("Error processing chart values of template %v: %w", ot.Name, err)
+	}
+
+	objects, err := renderChart(archive, values, chartReleaseName(*source, otp), otp.Namespace)
+
+	if err != nil {
+		return ot, fmt.Errorf("Error rendering chart of template %v: %w", ot.Name, err)
+	}
+
+	strategy := source.UpdateStrategy
+	if len(strategy) == 0 {
+		strategy = otv1.UpdateStrategyReplace
+	}
+
+	expanded := *ot.DeepCopy()
+	for _, obj := range objects {
+		obj.UpdateStrategy = strategy
+		expanded.Spec.Objects = append(expanded.Spec.Objects, obj)
+	}
+
+	return expanded, nil
+}
+
+// validateChartSource chart of template must have a single source
+func validateChartSource(ot otv1.ObjectTemplate) error {
+	source := ot.Spec.Chart
+
+	if source == nil {
+		return nil
+	}
+
+	sources := 0
+	for _, set := range []bool{source.ConfigMap != nil, source.Secret != nil, source.OCILayout != nil} {
+		if set {
+			sources++
+		}
+	}
+
+	if sources != 1 {
+		return fmt.Errorf("chart must have one of configMap, secret or ociLayout")
+	}
+
+	return nil
+}
+
+// chartReleaseName release name of chart or parameters name
+func chartReleaseName(source otv1.ChartSource, otp otv1.ObjectTemplateParams) string {
+	if len(source.ReleaseName) > 0 {
+		return source.ReleaseName
+	}
+
+	return otp.Name
+}
+
+// chartValues parameters values with rendered chart values merged over them
+func (c *Common) chartValues(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) (map[string]interface{}, error) {
+	parameters, err := otp.Spec.GetParametersByTemplateName(ot.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := c.renderingFor(ot, otp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	chartObj := otv1.Object{Kind: "Chart", Name: chartReleaseName(*ot.Spec.Chart, otp)}
+	params, err := rc.normalizeParametersValues(chartObj, otp.Namespace, ot.Spec.Parameters, ot.Spec.Migrations, parameters.Values)
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	for k, v := range params {
+		values[k] = v
+	}
+
+	if len(ot.Spec.Chart.Values) == 0 {
+		return values, nil
+	}
+
+	rendered, err := renderTemplate("values", ot.Spec.Chart.Values, rc.addRuntimeVariablesToMap(params, chartObj, otp.Namespace), templateOptions, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	chartValues, err := chartutil.ReadValues([]byte(rendered))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return chartutil.CoalesceTables(chartValues, values), nil
+}
+
+// loadChartArchive load chart archive from config map, secret or OCI image layout
+func (c *Common) loadChartArchive(source otv1.ChartSource) ([]byte, error) {
+	var archive []byte
+	var err error
+
+	switch {
+	case source.ConfigMap != nil:
+		archive, err = c.configMapChartArchive(*source.ConfigMap)
+	case source.Secret != nil:
+		archive, err = c.secretChartArchive(*source.Secret)
+	case source.OCILayout != nil:
+		archive, err = ociLayoutChartArchive(*source.OCILayout)
+	default:
+		err = fmt.Errorf("chart must have one of configMap, secret or ociLayout")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+func (c *Common) configMapChartArchive(ref otv1.ChartArchiveRef) ([]byte, error) {
+	configMap := corev1.ConfigMap{}
+
+	if err := c.Client.Get(context.Background(), types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, &configMap); err != nil {
+		return nil, err
+	}
+
+	archive, found := configMap.BinaryData[chartKey(ref)]
+
+	if !found {
+		return nil, fmt.Errorf("binaryData key %v not found in config map %v/%v", chartKey(ref), ref.Namespace, ref.Name)
+	}
+
+	return archive, nil
+}
+
+func (c *Common) secretChartArchive(ref otv1.ChartArchiveRef) ([]byte, error) {
+	secret := corev1.Secret{}
+
+	if err := c.Client.Get(context.Background(), types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, &secret); err != nil {
+		return nil, err
+	}
+
+	archive, found := secret.Data[chartKey(ref)]
+
+	if !found {
+		return nil, fmt.Errorf("data key %v not found in secret %v/%v", chartKey(ref), ref.Namespace, ref.Name)
+	}
+
+	return archive, nil
+}
+
+func chartKey(ref otv1.ChartArchiveRef) string {
+	if len(ref.Key) > 0 {
+		return ref.Key
+	}
+
+	return defaultChartKey
+}
+
+// ociLayoutChartArchive chart layer of the manifest selected by reference in a local OCI image layout
+func ociLayoutChartArchive(ref otv1.OCILayoutRef) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(ref.Path, "index.json"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	index := ociIndex{}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid OCI index: %w", err)
+	}
+
+	var selected *ociDescriptor
+	for i, descriptor := range index.Manifests {
+		if len(ref.Reference) == 0 && len(index.Manifests) == 1 ||
+			ref.Reference == descriptor.Digest ||
+			ref.Reference == descriptor.Annotations[ociRefNameAnnotation] {
+			selected = &index.Manifests[i]
+			break
+		}
+	}
+
+	if selected == nil {
+		return nil, fmt.Errorf("manifest %q not found in OCI layout %v", ref.Reference, ref.Path)
+	}
+
+	data, err = readOCIBlob(ref.Path, selected.Digest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := ociManifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid OCI manifest: %w", err)
+	}
+
+	for _, layer := range manifest.Layers {
+		if chartLayerMediaTypes[layer.MediaType] {
+			return readOCIBlob(ref.Path, layer.Digest)
+		}
+	}
+
+	return nil, fmt.Errorf("manifest %v has no helm chart layer", selected.Digest)
+}
+
+// readOCIBlob read blob of a layout checking its digest
+func readOCIBlob(layoutPath string, digest string) ([]byte, error) {
+	if !ociDigestRegexp.MatchString(digest) {
+		return nil, fmt.Errorf("unsupported digest %q", digest)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(layoutPath, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:")))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if fmt.Sprintf("sha256:%x", sha256.Sum256(data)) != digest {
+		return nil, fmt.Errorf("blob %v does not match its digest", digest)
+	}
+
+	return data, nil
+}
+
+// renderChart render chart templates as objects with base in install order using template options limits
+func renderChart(archive []byte, values map[string]interface{}, releaseName string, namespaceName string) ([]otv1.Object, error) {
+	valuesJSON, err := json.Marshal(values)
+
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := evaluateWithLimits(templateOptions, evaluation{
+		Engine:      chartEngine,
+		Name:        releaseName,
+		Values:      valuesJSON,
+		Chart:       archive,
+		ReleaseName: releaseName,
+		Namespace:   namespaceName,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := []releaseutil.Manifest{}
+	if err := json.Unmarshal([]byte(output), &manifests); err != nil {
+		return nil, err
+	}
+
+	objects := []otv1.Object{}
+	for _, manifest := range manifests {
+		obj, err := chartObject(manifest)
+
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", manifest.Name, err)
+		}
+
+		objects = append(objects, obj)
+	}
+
+	return objects, nil
+}
+
+// evaluateChart render chart templates as manifests in install order (hooks, NOTES.txt and crds are not rendered)
+func evaluateChart(e evaluation) (string, error) {
+	chrt, err := loader.LoadArchive(bytes.NewReader(e.Chart))
+
+	if err != nil {
+		return "", err
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(e.Values, &values); err != nil {
+		return "", err
+	}
+
+	if err := chartutil.ProcessDependencies(chrt, values); err != nil {
+		return "", fmt.Errorf("chart %v: %w", chrt.Name(), err)
+	}
+
+	options := chartutil.ReleaseOptions{Name: e.ReleaseName, Namespace: e.Namespace, Revision: 1, IsInstall: true}
+	renderValues, err := chartutil.ToRenderValues(chrt, values, options, nil)
+
+	if err != nil {
+		return "", fmt.Errorf("chart %v: %w", chrt.Name(), err)
+	}
+
+	files, err := engine.Render(chrt, renderValues)
+
+	if err != nil {
+		return "", fmt.Errorf("chart %v: %w", chrt.Name(), err)
+	}
+
+	for name := range files {
+		if strings.HasSuffix(name, "NOTES.txt") {
+			delete(files, name)
+		}
+	}
+
+	_, manifests, err := releaseutil.SortManifests(files, chartutil.DefaultVersionSet, releaseutil.InstallOrder)
+
+	if err != nil {
+		return "", fmt.Errorf("chart %v: %w", chrt.Name(), err)
+	}
+
+	output, err := json.Marshal(manifests)
+
+	return string(output), err
+}
+
+// chartObject object with a base from a rendered manifest
+func chartObject(manifest releaseutil.Manifest) (otv1.Object, error) {
+	content := map[string]interface{}{}
+
+	if err := yaml.Unmarshal([]byte(manifest.Content), &content); err != nil {
+		return otv1.Object{}, err
+	}
+
+	if manifest.Head == nil || manifest.Head.Metadata == nil || len(manifest.Head.Metadata.Name) == 0 {
+		return otv1.Object{}, fmt.Errorf("object must have apiVersion, kind and metadata.name")
+	}
+
+	metadata := otv1.Metadata{}
+	if meta, ok := content["metadata"].(map[string]interface{}); ok {
+		metadata.Labels = stringMap(meta["labels"])
+		metadata.Annotations = stringMap(meta["annotations"])
+	}
+
+	delete(content, "apiVersion")
+	delete(content, "kind")
+	delete(content, "metadata")
+	base, err := json.Marshal(content)
+
+	if err != nil {
+		return otv1.Object{}, err
+	}
+
+	return otv1.Object{
+		Kind:       manifest.Head.Kind,
+		APIVersion: manifest.Head.Version,
+		Name:       manifest.Head.Metadata.Name,
+		Metadata:   metadata,
+		Base:       &runtime.RawExtension{Raw: base},
+	}, nil
+}
+
+func stringMap(value interface{}) map[string]string {
+	values, ok := value.(map[string]interface{})
+
+	if !ok {
+		return nil
+	}
+
+	result := map[string]string{}
+	for k, v := range values {
+		result[k] = fmt.Sprintf("%v", v)
+	}
+
+	return result
+}
@@ -0,0 +1,178 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// chartArchive packaged chart with files (writes to memory don't fail)
+func chartArchive(files map[string]string) []byte {
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	tw := tar.NewWriter(gz)
+
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{Name: "web/" + name, Mode: 0644, Size: int64(len(content))})
+		tw.Write([]byte(content))
+	}
+
+	tw.Close()
+	gz.Close()
+
+	return buffer.Bytes()
+}
+
+// writeOCIBlob write blob to an OCI image layout returning its digest
+func writeOCIBlob(layoutPath string, data []byte) string {
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	Expect(ioutil.WriteFile(filepath.Join(layoutPath, "blobs", "sha256", hash), data, 0644)).To(Succeed())
+
+	return "sha256:" + hash
+}
+
+var _ = Describe("Helm charts", func() {
+	archive := chartArchive(map[string]string{
+		"Chart.yaml":             "apiVersion: v2\nname: web\nversion: 1.0.0\n",
+		"values.yaml":            "replicas: 1\nimage:\n  repository: nginx\n  tag: latest\n",
+		"templates/_helpers.tpl": `{{ define "web.name" }}{{ .Release.Name }}-web{{ end }}`,
+		"templates/NOTES.txt":    "installed {{ .Release.Name }}",
+		"templates/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ include "web.name" . }}
+  labels:
+    tier: {{ .Values.tier }}
+spec:
+  replicas: {{ .Values.replicas }}
+  template:
+    spec:
+      serviceAccountName: {{ include "web.name" . }}
+      containers:
+      - name: web
+        image: {{ .Values.image.repository }}:{{ .Values.image.tag }}
+`,
+		"templates/serviceaccount.yaml": `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: {{ include "web.name" . }}
+  namespace: {{ .Release.Namespace }}
+`,
+		"templates/test.yaml": `apiVersion: v1
+kind: Pod
+metadata:
+  name: {{ include "web.name" . }}-test
+  annotations:
+    helm.sh/hook: test
+`,
+	})
+	template := otv1.ObjectTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec: otv1.ObjectTemplateSpec{
+			Parameters: []otv1.Parameter{{Name: "tier", Default: "frontend"}, {Name: "version", Default: "1.19"}},
+			Chart: &otv1.ChartSource{
+				ConfigMap: &otv1.ChartArchiveRef{Name: "charts", Namespace: "platform"},
+				Values:    "replicas: 2\nimage:\n  tag: \"{{ .version }}\"\n",
+			},
+		},
+	}
+	params := otv1.ObjectTemplateParams{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "team-a"},
+		Spec: otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{
+			{Name: "web", Values: map[string]string{"version": "1.21"}},
+		}},
+	}
+	common := Common{Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "charts", Namespace: "platform"}, BinaryData: map[string][]byte{"chart.tgz": archive}},
+	)}
+
+	It("Should render chart objects from config map using parameters values", func() {
+		expanded, err := common.expandChart(template, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expanded.Spec.Objects).To(HaveLen(2))
+
+		sa, deployment := expanded.Spec.Objects[0], expanded.Spec.Objects[1]
+		Expect(sa.Kind).To(Equal("ServiceAccount"))
+		Expect(sa.Name).To(Equal("shop-web"))
+		Expect(deployment.Kind).To(Equal("Deployment"))
+		Expect(deployment.APIVersion).To(Equal("apps/v1"))
+		Expect(deployment.UpdateStrategy).To(Equal(otv1.UpdateStrategyReplace))
+		Expect(deployment.Metadata.Labels).To(Equal(map[string]string{"tier": "frontend"}))
+		Expect(template.Spec.Objects).To(BeEmpty())
+
+		obj, _, err := common.ToObject(deployment, nil, nil, "team-a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj.GetName()).To(Equal("shop-web"))
+		Expect(obj.Object["spec"]).To(HaveKeyWithValue("replicas", int64(2)))
+		Expect(fmt.Sprint(obj.Object["spec"])).To(ContainSubstring("image:nginx:1.21"))
+	})
+
+	It("Should render chart from a local OCI layout", func() {
+		layout, err := ioutil.TempDir("", "oci-layout")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(layout)
+		Expect(os.MkdirAll(filepath.Join(layout, "blobs", "sha256"), 0755)).To(Succeed())
+
+		chartDigest := writeOCIBlob(layout, archive)
+		manifestDigest := writeOCIBlob(layout, []byte(fmt.Sprintf(`{"schemaVersion":2,"layers":[{"mediaType":"application/vnd.cncf.helm.chart.content.v1.tar+gzip","digest":%q}]}`, chartDigest)))
+		index := fmt.Sprintf(`{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":%q,"annotations":{"org.opencontainers.image.ref.name":"1.0.0"}}]}`, manifestDigest)
+		Expect(ioutil.WriteFile(filepath.Join(layout, "index.json"), []byte(index), 0644)).To(Succeed())
+
+		ociTemplate := *template.DeepCopy()
+		ociTemplate.Spec.Chart = &otv1.ChartSource{OCILayout: &otv1.OCILayoutRef{Path: layout, Reference: "1.0.0"}, ReleaseName: "store"}
+		expanded, err := common.expandChart(ociTemplate, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(expanded.Spec.Objects).To(HaveLen(2))
+		Expect(expanded.Spec.Objects[1].Name).To(Equal("store-web"))
+
+		ociTemplate.Spec.Chart.OCILayout.Reference = "2.0.0"
+		_, err = common.expandChart(ociTemplate, params)
+		Expect(err).To(HaveOccurred())
+
+		ociTemplate.Spec.Chart.OCILayout.Reference = "1.0.0"
+		Expect(ioutil.WriteFile(filepath.Join(layout, "blobs", "sha256", chartDigest[len("sha256:"):]), []byte("tampered"), 0644)).To(Succeed())
+		_, err = common.expandChart(ociTemplate, params)
+		Expect(err).To(MatchError(ContainSubstring("does not match its digest")))
+	})
+
+	It("Should require a single chart source", func() {
+		Expect(validateChartSource(template)).To(Succeed())
+
+		invalid := *template.DeepCopy()
+		invalid.Spec.Chart.Secret = &otv1.ChartArchiveRef{Name: "charts", Namespace: "platform"}
+		Expect(validateChartSource(invalid)).NotTo(Succeed())
+
+		invalid.Spec.Chart = &otv1.ChartSource{}
+		Expect(validateChartSource(invalid)).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,162 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CleanupObjectsByTemplate apply deletion policy to objects created by template using parameters
+func (c *Common) CleanupObjectsByTemplate(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) error {
+	lu := LogUtil{Log: c.Log}
+	objectsClient, err := c.objectsClient(ot, otp)
+
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range ot.Spec.Objects {
+		policy := getDeletionPolicy(ot, obj)
+
+		if err := c.cleanupSingleObject(objectsClient, ot, otp, obj, policy); err != nil {
+			lu.Error(err, fmt.Sprintf("Failed to cleanup [%v(%v)] of %v parameters at %v namespace", obj.Kind, obj.Name, otp.Name, otp.Namespace))
+		}
+	}
+
+	return lu.AllErrors()
+}
+
+// cleanupSingleObject delete or detach an object generated by template using parameters (cluster scoped objects are found by managed markers)
+func (c *Common) cleanupSingleObject(objectsClient client.Client, ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams, obj otv1.Object, policy otv1.DeletionPolicy) error {
+	ctx := context.Background()
+	gvk := schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind)
+	namespaced, err := c.isNamespaced(gvk)
+
+	if err != nil {
+		return err
+	}
+
+	key := types.NamespacedName{Name: obj.Name}
+	if namespaced {
+		key.Namespace = otp.Namespace
+	}
+
+	current, err := c.GetObject(gvk, key)
+
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if !isGeneratedBy(current, ot, otp) || isObjectSuspended(current) {
+		return nil
+	}
+
+	// objects are kept without references to parameters, otherwise they are garbage collected with them
+	if policy == otv1.DeletionPolicyOrphan || policy == otv1.DeletionPolicyRetain {
+		current.SetOwnerReferences(removeOwnerReference(current.GetOwnerReferences(), otp.UID))
+
+		// retained objects keep managed markers to be adopted again by parameters with the same name
+		if policy == otv1.DeletionPolicyOrphan {
+			removeManagedMarkers(&current)
+		}
+
+		return objectsClient.Update(ctx, &current)
+	}
+
+	if err := objectsClient.Delete(ctx, &current); err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// getDeletionPolicy object deletion policy, template deletion policy or default
+func getDeletionPolicy(ot otv1.ObjectTemplate, obj otv1.Object) otv1.DeletionPolicy {
+	if len(obj.DeletionPolicy) > 0 {
+		return obj.DeletionPolicy
+	}
+
+	if len(ot.Spec.DeletionPolicy) > 0 {
+		return ot.Spec.DeletionPolicy
+	}
+
+	return otv1.DeletionPolicyDelete
+}
+
+func isOwnedBy(obj unstructured.Unstructured, owner types.UID) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == owner {
+			return true
+		}
+	}
+
+	return false
+}
+
+func removeOwnerReference(refs []metav1.OwnerReference, owner types.UID) []metav1.OwnerReference {
+	newRefs := []metav1.OwnerReference{}
+
+	for _, ref := range refs {
+		if ref.UID != owner {
+			newRefs = append(newRefs, ref)
+		}
+	}
+
+	return newRefs
+}
+
+// mergeOwnerReferences set owners keeping references from other owners (only one controller is allowed)
+func mergeOwnerReferences(refs []metav1.OwnerReference, owners []metav1.OwnerReference) []metav1.OwnerReference {
+	newRefs := refs
+
+	for _, owner := range owners {
+		newRefs = removeOwnerReference(newRefs, owner.UID)
+
+		if owner.Controller != nil && *owner.Controller {
+			newRefs = removeControllerReference(newRefs)
+		}
+
+		newRefs = append(newRefs, owner)
+	}
+
+	return newRefs
+}
+
+func removeControllerReference(refs []metav1.OwnerReference) []metav1.OwnerReference {
+	newRefs := []metav1.OwnerReference{}
+
+	for _, ref := range refs {
+		if ref.Controller == nil || !*ref.Controller {
+			newRefs = append(newRefs, ref)
+		}
+	}
+
+	return newRefs
+}
@@ -0,0 +1,209 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Object cleanup", func() {
+	var common Common
+	otp := otv1.ObjectTemplateParams{ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "team-a", UID: "params-uid"}}
+	template := func(policy otv1.DeletionPolicy) otv1.ObjectTemplate {
+		return otv1.ObjectTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenant"},
+			Spec: otv1.ObjectTemplateSpec{
+				DeletionPolicy: policy,
+				Objects: []otv1.Object{
+					{APIVersion: "v1", Kind: "ConfigMap", Name: "settings"},
+					{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Name: "team-a-reader"},
+				},
+			},
+		}
+	}
+	markers := map[string]string{
+		otv1.TemplateAnnotation:        "tenant",
+		otv1.ParamsAnnotation:          "params",
+		otv1.ParamsNamespaceAnnotation: "team-a",
+	}
+	managed := map[string]string{otv1.ManagedByLabel: otv1.ManagedByValue}
+	getConfigMap := func() (corev1.ConfigMap, error) {
+		cm := corev1.ConfigMap{}
+		err := common.Client.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "settings"}, &cm)
+		return cm, err
+	}
+	getClusterRole := func() (rbacv1.ClusterRole, error) {
+		role := rbacv1.ClusterRole{}
+		err := common.Client.Get(context.Background(), types.NamespacedName{Name: "team-a-reader"}, &role)
+		return role, err
+	}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+		mapper.Add(rbacv1.SchemeGroupVersion.WithKind("ClusterRole"), meta.RESTScopeRoot)
+
+		configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:            "settings",
+			Namespace:       "team-a",
+			Labels:          managed,
+			Annotations:     markers,
+			OwnerReferences: append(getOwnerReferences(otp), metav1.OwnerReference{APIVersion: "v1", Kind: "Secret", Name: "other", UID: "other-uid"}),
+		}}
+		clusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{
+			Name:        "team-a-reader",
+			Labels:      managed,
+			Annotations: markers,
+		}}
+		common = Common{Client: fake.NewFakeClientWithScheme(scheme, configMap, clusterRole), Log: ctrl.Log.WithName("test"), Mapper: mapper}
+	})
+
+	It("Should delete namespaced and cluster scoped objects", func() {
+		Expect(common.CleanupObjectsByTemplate(template(otv1.DeletionPolicyDelete), otp)).To(Succeed())
+
+		_, err := getConfigMap()
+		Expect(k8sErrors.IsNotFound(err)).To(BeTrue())
+		_, err = getClusterRole()
+		Expect(k8sErrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("Should detach objects from parameters with Orphan", func() {
+		Expect(common.CleanupObjectsByTemplate(template(otv1.DeletionPolicyOrphan), otp)).To(Succeed())
+
+		cm, err := getConfigMap()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cm.OwnerReferences).To(HaveLen(1))
+		Expect(cm.OwnerReferences[0].UID).To(Equal(types.UID("other-uid")))
+		Expect(cm.Labels).NotTo(HaveKey(otv1.ManagedByLabel))
+		Expect(cm.Annotations).To(BeEmpty())
+
+		role, err := getClusterRole()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(role.Annotations).To(BeEmpty())
+	})
+
+	It("Should detach objects from parameters keeping managed markers with Retain", func() {
+		Expect(common.CleanupObjectsByTemplate(template(otv1.DeletionPolicyRetain), otp)).To(Succeed())
+
+		cm, err := getConfigMap()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cm.OwnerReferences).To(HaveLen(1))
+		Expect(cm.OwnerReferences[0].UID).To(Equal(types.UID("other-uid")))
+		Expect(cm.Labels).To(HaveKeyWithValue(otv1.ManagedByLabel, otv1.ManagedByValue))
+		Expect(cm.Annotations).To(Equal(markers))
+
+		role, err := getClusterRole()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(role.Annotations).To(Equal(markers))
+	})
+
+	It("Should adopt retained objects again by parameters with the same name", func() {
+		Expect(common.CleanupObjectsByTemplate(template(otv1.DeletionPolicyRetain), otp)).To(Succeed())
+
+		recreated := otp
+		recreated.UID = "recreated-params-uid"
+		ot := template(otv1.DeletionPolicyRetain)
+		ot.Spec.AdoptionPolicy = otv1.AdoptionPolicyNever
+		current, err := common.GetObject(corev1.SchemeGroupVersion.WithKind("ConfigMap"), types.NamespacedName{Namespace: "team-a", Name: "settings"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(isGeneratedBy(current, ot, recreated)).To(BeTrue())
+		Expect(checkAdoption(ot, current)).To(Succeed())
+		Expect(common.checkConflict(recreated, current)).To(Succeed())
+	})
+
+	It("Should use object deletion policy over template deletion policy", func() {
+		ot := template(otv1.DeletionPolicyOrphan)
+		ot.Spec.Objects[1].DeletionPolicy = otv1.DeletionPolicyDelete
+		Expect(common.CleanupObjectsByTemplate(ot, otp)).To(Succeed())
+
+		_, err := getConfigMap()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = getClusterRole()
+		Expect(k8sErrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("Should keep objects generated by other parameters or suspended", func() {
+		other := otp
+		other.Namespace = "team-b"
+		other.UID = "other-params-uid"
+		Expect(common.CleanupObjectsByTemplate(template(otv1.DeletionPolicyDelete), other)).To(Succeed())
+
+		_, err := getConfigMap()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = getClusterRole()
+		Expect(err).NotTo(HaveOccurred())
+
+		role, _ := getClusterRole()
+		role.Annotations = map[string]string{otv1.SuspendAnnotation: "true"}
+		for k, v := range markers {
+			role.Annotations[k] = v
+		}
+		Expect(common.Client.Update(context.Background(), &role)).To(Succeed())
+		Expect(common.CleanupObjectsByTemplate(template(otv1.DeletionPolicyDelete), otp)).To(Succeed())
+
+		_, err = getClusterRole()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("Owner references", func() {
+		isController, notController := true, false
+		controller := func(uid types.UID) metav1.OwnerReference {
+			return metav1.OwnerReference{Name: string(uid), UID: uid, Controller: &isController}
+		}
+		owner := func(uid types.UID) metav1.OwnerReference {
+			return metav1.OwnerReference{Name: string(uid), UID: uid}
+		}
+
+		It("Should replace the controller keeping other owners", func() {
+			refs := mergeOwnerReferences([]metav1.OwnerReference{controller("old"), owner("other")}, []metav1.OwnerReference{controller("new")})
+			Expect(refs).To(Equal([]metav1.OwnerReference{owner("other"), controller("new")}))
+		})
+
+		It("Should not duplicate an existing owner", func() {
+			refs := mergeOwnerReferences([]metav1.OwnerReference{owner("other"), controller("new")}, []metav1.OwnerReference{controller("new")})
+			Expect(refs).To(Equal([]metav1.OwnerReference{owner("other"), controller("new")}))
+		})
+
+		It("Should remove only controller references", func() {
+			refs := removeControllerReference([]metav1.OwnerReference{controller("old"), owner("other"), {UID: "not-controller", Controller: &notController}})
+			Expect(refs).To(Equal([]metav1.OwnerReference{owner("other"), {UID: "not-controller", Controller: &notController}}))
+		})
+
+		It("Should not set references on cluster scoped objects", func() {
+			Expect(ownerReferencesFor(otp, true)).To(HaveLen(1))
+			Expect(ownerReferencesFor(otp, false)).To(BeEmpty())
+		})
+	})
+})
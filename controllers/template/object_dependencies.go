@@ -0,0 +1,159 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var (
+	// notReadyRequeuePeriod period to check dependencies again
+	notReadyRequeuePeriod = 10 * time.Second
+)
+
+// NotReadyError objects waiting for dependencies or deletion
+type NotReadyError struct {
+	Objects []string
+	// Deleting objects waiting for deletion before being created again
+	Deleting []string
+}
+
+func (e *NotReadyError) Error() string {
+	messages := []string{}
+
+	if len(e.Objects) > 0 {
+		messages = append(messages, fmt.Sprintf("waiting for dependencies of objects %v", strings.Join(e.Objects, ", ")))
+	}
+
+	if len(e.Deleting) > 0 {
+		messages = append(messages, fmt.Sprintf("waiting for deletion of objects %v", strings.Join(e.Deleting, ", ")))
+	}
+
+	return strings.Join(messages, ", ")
+}
+
+// isNotReadyError error caused by objects waiting for dependencies
+func isNotReadyError(err error) bool {
+	var notReady *NotReadyError
+	return errors.As(err, &notReady)
+}
+
+// requeueNotReady requeue period used while objects are waiting for dependencies
+func requeueNotReady(period time.Duration) time.Duration {
+	if period == 0 || period > notReadyRequeuePeriod {
+		return notReadyRequeuePeriod
+	}
+
+	return period
+}
+
+// sortObjectsByDependencies objects in topological order (template order is kept when possible)
+func sortObjectsByDependencies(objects []otv1.Object) ([]otv1.Object, error) {
+	names := map[string]bool{}
+	for _, obj := range objects {
+		names[obj.Name] = true
+	}
+
+	for _, obj := range objects {
+		for _, dependency := range obj.DependsOn {
+			if !names[dependency] {
+				return nil, fmt.Errorf("object %v depends on unknown object %v", obj.Name, dependency)
+			}
+		}
+	}
+
+	sorted := []otv1.Object{}
+	placed := make([]bool, len(objects))
+	pending := map[string]int{}
+	for _, obj := range objects {
+		pending[obj.Name]++
+	}
+
+	for len(sorted) < len(objects) {
+		progress := false
+
+		for i, obj := range objects {
+			if placed[i] || !dependenciesPlaced(obj, pending) {
+				continue
+			}
+
+			placed[i] = true
+			pending[obj.Name]--
+			sorted = append(sorted, obj)
+			progress = true
+		}
+
+		if !progress {
+			return nil, fmt.Errorf("circular dependency between objects %v", unplacedNames(objects, placed))
+		}
+	}
+
+	return sorted, nil
+}
+
+func dependenciesPlaced(obj otv1.Object, pending map[string]int) bool {
+	for _, dependency := range obj.DependsOn {
+		if pending[dependency] > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func unplacedNames(objects []otv1.Object, placed []bool) string {
+	names := []string{}
+
+	for i, obj := range objects {
+		if !placed[i] {
+			names = append(names, obj.Name)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// requiredObjects names of objects used as dependency
+func requiredObjects(objects []otv1.Object) map[string]bool {
+	required := map[string]bool{}
+
+	for _, obj := range objects {
+		for _, dependency := range obj.DependsOn {
+			required[dependency] = true
+		}
+	}
+
+	return required
+}
+
+// isObjectReady get object and evaluate its readiness check
+func (c *Common) isObjectReady(obj otv1.Object, namespaceName string) (bool, error) {
+	current, err := c.GetObject(schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind), types.NamespacedName{Namespace: namespaceName, Name: obj.Name})
+
+	if err != nil {
+		return false, err
+	}
+
+	return isReady(current, obj.ReadinessCheck)
+}
@@ -0,0 +1,68 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Object dependencies", func() {
+	names := func(objects []otv1.Object) []string {
+		result := []string{}
+		for _, obj := range objects {
+			result = append(result, obj.Name)
+		}
+		return result
+	}
+
+	Context("With dependencies between objects", func() {
+		It("Should be sorted in topological order", func() {
+			objects := []otv1.Object{
+				{Name: "deployment", DependsOn: []string{"secret", "config"}},
+				{Name: "config"},
+				{Name: "secret", DependsOn: []string{"crd"}},
+				{Name: "crd"},
+			}
+			sorted, err := sortObjectsByDependencies(objects)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(sorted)).To(Equal([]string{"config", "crd", "secret", "deployment"}))
+		})
+		It("Should fail with unknown dependency", func() {
+			_, err := sortObjectsByDependencies([]otv1.Object{{Name: "a", DependsOn: []string{"b"}}})
+			Expect(err).To(HaveOccurred())
+		})
+		It("Should fail with circular dependency", func() {
+			_, err := sortObjectsByDependencies([]otv1.Object{
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"a"}},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Without dependencies", func() {
+		It("Should keep template order", func() {
+			sorted, err := sortObjectsByDependencies([]otv1.Object{{Name: "b"}, {Name: "a"}})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(sorted)).To(Equal([]string{"b", "a"}))
+		})
+	})
+})
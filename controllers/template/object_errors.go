@@ -0,0 +1,121 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+)
+
+// ObjectsError errors of objects from a template applied independently
+type ObjectsError struct {
+	Errors []error
+	// Failed names of objects that failed to render or apply
+	Failed []string
+	// Skipped names of objects not applied because of failed objects (failure policy or dependencies)
+	Skipped  []string
+	NotReady *NotReadyError
+}
+
+func (e *ObjectsError) Error() string {
+	messages := []string{}
+
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+
+	if len(e.Skipped) > 0 {
+		messages = append(messages, fmt.Sprintf("objects %v skipped because of failed objects", strings.Join(e.Skipped, ", ")))
+	}
+
+	if e.NotReady != nil {
+		messages = append(messages, e.NotReady.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// As find the first error that matches target
+func (e *ObjectsError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return e.NotReady != nil && errors.As(e.NotReady, target)
+}
+
+// ErrorOrNil nil if there are no errors nor objects waiting for dependencies
+func (e *ObjectsError) ErrorOrNil() error {
+	if len(e.Errors) == 0 && e.NotReady == nil {
+		return nil
+	}
+
+	return e
+}
+
+// failedObjectsError errors ignoring objects waiting for dependencies (nil if there are no errors)
+func failedObjectsError(err error) error {
+	var objectsError *ObjectsError
+
+	if errors.As(err, &objectsError) {
+		if len(objectsError.Errors) == 0 {
+			return nil
+		}
+
+		return &ObjectsError{Errors: objectsError.Errors, Failed: objectsError.Failed, Skipped: objectsError.Skipped}
+	}
+
+	if isNotReadyError(err) {
+		return nil
+	}
+
+	return err
+}
+
+// unappliedObjects names of template objects that failed or were skipped applying template (all objects if the
+// template failed before applying them)
+func unappliedObjects(ot otv1.ObjectTemplate, err error) (map[string]bool, map[string]bool) {
+	failed, skipped := map[string]bool{}, map[string]bool{}
+	var objectsError *ObjectsError
+
+	if err == nil || isNotReadyError(err) {
+		return failed, skipped
+	}
+
+	if !errors.As(err, &objectsError) {
+		for _, obj := range ot.Spec.Objects {
+			failed[obj.Name] = true
+		}
+
+		return failed, skipped
+	}
+
+	for _, name := range objectsError.Failed {
+		failed[name] = true
+	}
+
+	for _, name := range objectsError.Skipped {
+		skipped[name] = true
+	}
+
+	return failed, skipped
+}
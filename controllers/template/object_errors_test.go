@@ -0,0 +1,82 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Objects error", func() {
+	Context("With errors from many objects", func() {
+		It("Should report all errors", func() {
+			objectsError := &ObjectsError{Errors: []error{errors.New("first"), errors.New("second")}}
+
+			Expect(objectsError.ErrorOrNil()).To(MatchError("first\nsecond"))
+		})
+
+		It("Should match wrapped errors", func() {
+			objectsError := &ObjectsError{Errors: []error{fmt.Errorf("object: %w", &ConflictError{Owner: "other"})}}
+
+			Expect(isConflictError(objectsError)).To(BeTrue())
+		})
+	})
+
+	Context("With failed objects", func() {
+		It("Should report skipped objects", func() {
+			objectsError := &ObjectsError{Errors: []error{errors.New("first")}, Failed: []string{"config"}, Skipped: []string{"web", "service"}}
+
+			Expect(objectsError.ErrorOrNil()).To(MatchError("first\nobjects web, service skipped because of failed objects"))
+			Expect(failedObjectsError(objectsError)).To(Equal(objectsError))
+		})
+
+		It("Should list failed and skipped objects", func() {
+			template := otv1.ObjectTemplate{Spec: otv1.ObjectTemplateSpec{Objects: []otv1.Object{{Name: "config"}, {Name: "web"}}}}
+			objectsError := &ObjectsError{Errors: []error{errors.New("first")}, Failed: []string{"config"}, Skipped: []string{"web"}}
+
+			failed, skipped := unappliedObjects(template, fmt.Errorf("Error updating: %w", objectsError))
+			Expect(failed).To(Equal(map[string]bool{"config": true}))
+			Expect(skipped).To(Equal(map[string]bool{"web": true}))
+
+			failed, skipped = unappliedObjects(template, &ObjectsError{NotReady: &NotReadyError{Objects: []string{"web"}}})
+			Expect(failed).To(BeEmpty())
+			Expect(skipped).To(BeEmpty())
+
+			failed, _ = unappliedObjects(template, errors.New("template failed"))
+			Expect(failed).To(Equal(map[string]bool{"config": true, "web": true}))
+		})
+	})
+
+	Context("With objects waiting for dependencies only", func() {
+		It("Should not be a failure", func() {
+			objectsError := &ObjectsError{NotReady: &NotReadyError{Objects: []string{"deployment"}}}
+
+			Expect(isNotReadyError(objectsError.ErrorOrNil())).To(BeTrue())
+			Expect(failedObjectsError(objectsError)).To(BeNil())
+		})
+	})
+
+	Context("Without errors", func() {
+		It("Should be nil", func() {
+			Expect((&ObjectsError{}).ErrorOrNil()).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,195 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"cuelang.org/go/cue"
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	"github.com/google/go-jsonnet"
+)
+
+// EvaluatorArg first argument of a binary started as evaluator process (see RunEvaluator)
+const EvaluatorArg = "__evaluate"
+
+// evaluation source evaluated by an engine without access to the cluster
+type evaluation struct {
+	Engine string          `json:"engine"`
+	Name   string          `json:"name"`
+	Source string          `json:"source"`
+	Values json.RawMessage `json:"values"`
+	// Chart helm chart archive (helm engine)
+	Chart []byte `json:"chart,omitempty"`
+	// ReleaseName helm release name (helm engine)
+	ReleaseName string `json:"releaseName,omitempty"`
+	// Namespace helm release namespace (helm engine)
+	Namespace string `json:"namespace,omitempty"`
+	// MaxOutputSize maximum size of output (zero disables the limit)
+	MaxOutputSize int `json:"maxOutputSize,omitempty"`
+}
+
+// evaluationResult output or error of an evaluation
+type evaluationResult struct {
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// evaluators evaluation by engine (jsonnet stack overflows are fatal, cue and helm have no cancellation, so they run in a child process)
+var evaluators = map[string]func(evaluation) (string, error){
+	string(otv1.EngineJsonnet): evaluateJsonnet,
+	string(otv1.EngineCUE):     evaluateCUE,
+	chartEngine:                evaluateChart,
+}
+
+// EvaluatorCommand command running this binary as evaluator process
+func EvaluatorCommand() ([]string, error) {
+	executable, err := os.Executable()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{executable, EvaluatorArg}, nil
+}
+
+// RunEvaluator evaluate a request read from in and write the result to out (evaluator process main)
+func RunEvaluator(in io.Reader, out io.Writer) error {
+	e := evaluation{}
+
+	if err := json.NewDecoder(in).Decode(&e); err != nil {
+		return err
+	}
+
+	result := evaluationResult{}
+	if output, err := evaluate(e); err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Output = output
+	}
+
+	return json.NewEncoder(out).Encode(result)
+}
+
+// evaluate run an evaluation checking its output size
+func evaluate(e evaluation) (string, error) {
+	evaluator, found := evaluators[e.Engine]
+
+	if !found {
+		return "", fmt.Errorf("unknown engine %v", e.Engine)
+	}
+
+	output, err := evaluator(e)
+
+	if err != nil {
+		return "", err
+	}
+
+	if e.MaxOutputSize > 0 && len(output) > e.MaxOutputSize {
+		return "", fmt.Errorf("%w (limit %v bytes)", errTemplateOutputSize, e.MaxOutputSize)
+	}
+
+	return output, nil
+}
+
+// evaluateWithLimits run an evaluation in the evaluator process killed after options timeout (in the caller without an evaluator command)
+func evaluateWithLimits(options TemplateOptions, e evaluation) (string, error) {
+	e.MaxOutputSize = options.MaxOutputSize
+
+	if len(options.Evaluator) == 0 {
+		return evaluate(e)
+	}
+
+	request, err := json.Marshal(e)
+
+	if err != nil {
+		return "", err
+	}
+
+	ctx := context.Background()
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	// output is limited by the evaluator, these limits only protect the operator from a broken process
+	stdout := &limitedWriter{}
+	if options.MaxOutputSize > 0 {
+		stdout.limit = 2*options.MaxOutputSize + 1024
+	}
+	stderr := &limitedWriter{limit: 4096}
+	cmd := exec.CommandContext(ctx, options.Evaluator[0], options.Evaluator[1:]...)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("%w after %v", errTemplateTimeout, options.Timeout)
+		}
+
+		return "", fmt.Errorf("evaluator process failed: %v %v", err, strings.TrimSpace(stderr.sb.String()))
+	}
+
+	result := evaluationResult{}
+	if err := json.Unmarshal([]byte(stdout.sb.String()), &result); err != nil {
+		return "", fmt.Errorf("invalid evaluator output: %w", err)
+	}
+
+	if len(result.Error) > 0 {
+		return "", errors.New(result.Error)
+	}
+
+	return result.Output, nil
+}
+
+// evaluateJsonnet jsonnet program with values as external variable (imports are not allowed)
+func evaluateJsonnet(e evaluation) (string, error) {
+	vm := jsonnet.MakeVM()
+	vm.Importer(&jsonnet.MemoryImporter{Data: map[string]jsonnet.Contents{}})
+	vm.ExtCode("values", string(e.Values))
+
+	return vm.EvaluateSnippet(e.Name, e.Source)
+}
+
+// evaluateCUE cue source with values appended as a hidden field
+func evaluateCUE(e evaluation) (string, error) {
+	var runtime cue.Runtime
+	instance, err := runtime.Compile(e.Name, fmt.Sprintf("%v\n_values: %s\n", e.Source, e.Values))
+
+	if err != nil {
+		return "", err
+	}
+
+	value := instance.Value()
+	if err := value.Validate(cue.Concrete(true)); err != nil {
+		return "", err
+	}
+
+	data, err := value.MarshalJSON()
+
+	return string(data), err
+}
@@ -0,0 +1,105 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestEvaluatorProcess evaluator process started by specs using the test binary (does nothing in a normal test run)
+func TestEvaluatorProcess(t *testing.T) {
+	if flag.Arg(0) != EvaluatorArg {
+		return
+	}
+
+	if err := RunEvaluator(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+var _ = Describe("Evaluator process", func() {
+	options := TemplateOptions{
+		Timeout:       10 * time.Second,
+		MaxOutputSize: 64,
+		Evaluator:     []string{os.Args[0], "-test.run=^TestEvaluatorProcess$", "--", EvaluatorArg},
+	}
+	jsonnetEvaluation := func(source string) evaluation {
+		return evaluation{Engine: string(otv1.EngineJsonnet), Name: "test", Source: source, Values: []byte(`{"name":"foo"}`)}
+	}
+
+	It("Should evaluate jsonnet and cue", func() {
+		output, err := evaluateWithLimits(options, jsonnetEvaluation(`{ name: std.extVar("values").name }`))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(MatchJSON(`{"name":"foo"}`))
+
+		output, err = evaluateWithLimits(options, evaluation{Engine: string(otv1.EngineCUE), Name: "test", Source: `name: _values.name`, Values: []byte(`{"name":"foo"}`)})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(MatchJSON(`{"name":"foo"}`))
+	})
+
+	It("Should render helm charts", func() {
+		charts := options
+		charts.MaxOutputSize = 0
+		archive := chartArchive(map[string]string{
+			"Chart.yaml":          "apiVersion: v2\nname: web\nversion: 1.0.0\n",
+			"templates/cm.yaml":   "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\ndata:\n  name: {{ .Values.name }}\n",
+			"templates/NOTES.txt": "installed",
+		})
+		output, err := evaluateWithLimits(charts, evaluation{Engine: chartEngine, Name: "web", Values: []byte(`{"name":"foo"}`), Chart: archive, ReleaseName: "web", Namespace: "team-a"})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(ContainSubstring(`name: foo`))
+		Expect(output).NotTo(ContainSubstring("installed"))
+	})
+
+	It("Should report evaluation errors", func() {
+		_, err := evaluateWithLimits(options, jsonnetEvaluation(`{ name: error "invalid name" }`))
+
+		Expect(err).To(MatchError(ContainSubstring("invalid name")))
+	})
+
+	It("Should limit output size", func() {
+		_, err := evaluateWithLimits(options, jsonnetEvaluation(`{ name: std.join("", std.makeArray(100, function(i) "x")) }`))
+
+		Expect(err).To(MatchError(ContainSubstring(errTemplateOutputSize.Error())))
+	})
+
+	It("Should kill runaway evaluations", func() {
+		limited := options
+		limited.Timeout = 500 * time.Millisecond
+		started := time.Now()
+
+		// exponential recursion using little memory and stack
+		_, err := evaluateWithLimits(limited, jsonnetEvaluation(`local f(n) = if n == 0 then 0 else f(n - 1) + f(n - 1); f(40)`))
+
+		Expect(errors.Is(err, errTemplateTimeout)).To(BeTrue())
+		Expect(time.Since(started)).To(BeNumerically("<", 5*time.Second))
+	})
+})
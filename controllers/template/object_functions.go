@@ -0,0 +1,261 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/Masterminds/sprig"
+)
+
+// TemplateOptions options used to execute templates
+type TemplateOptions struct {
+	// UnsafeFunctions enable functions that read the operator environment or are not deterministic
+	UnsafeFunctions bool
+	// Timeout maximum execution time of a template (zero disables the timeout)
+	Timeout time.Duration
+	// MaxOutputSize maximum size in bytes of a rendered template (zero disables the limit)
+	MaxOutputSize int
+	// ClusterName value of __clusterName runtime variable
+	ClusterName string
+	// UnrestrictedLookups allow templates without a service account to look up Secrets and objects of other namespaces
+	UnrestrictedLookups bool
+	// Evaluator command of the process evaluating jsonnet, cue and helm charts, killed after the timeout (evaluated by the caller without timeout if empty)
+	Evaluator []string
+}
+
+const (
+	// maxRangeSize maximum number of elements returned by until and untilStep
+	maxRangeSize = 10000
+	// timeoutCheckFunc function called at every range iteration to stop runaway templates
+	timeoutCheckFunc = "__checkTimeout"
+)
+
+var (
+	// templateOptions options used by all templates (see SetTemplateOptions)
+	templateOptions = TemplateOptions{Timeout: 5 * time.Second, MaxOutputSize: 1024 * 1024}
+
+	// unsafeFunctions functions removed from templates unless explicitly allowed
+	unsafeFunctions = []string{
+		// environment and network
+		"env", "expandenv", "getHostByName",
+		// non-deterministic
+		"now", "ago", "randAlphaNum", "randAlpha", "randAscii", "randNumeric", "shuffle", "uuidv4",
+		"genPrivateKey", "genCA", "genSelfSignedCert", "genSignedCert", "encryptAES",
+	}
+
+	errTemplateTimeout    = errors.New("template execution timed out")
+	errTemplateOutputSize = errors.New("template output is too large")
+	errTemplateRangeSize  = errors.New("template range is too large")
+)
+
+// SetTemplateOptions set options used by all templates (must be called before starting controllers)
+func SetTemplateOptions(options TemplateOptions) {
+	templateOptions = options
+}
+
+// executionLimits deadline of a single template execution
+type executionLimits struct {
+	timeout  time.Duration
+	deadline time.Time
+}
+
+func newExecutionLimits(options TemplateOptions) *executionLimits {
+	limits := &executionLimits{timeout: options.Timeout}
+
+	if options.Timeout > 0 {
+		limits.deadline = time.Now().Add(options.Timeout)
+	}
+
+	return limits
+}
+
+// check fail if the deadline is exceeded
+func (l *executionLimits) check() error {
+	if !l.deadline.IsZero() && time.Now().After(l.deadline) {
+		return fmt.Errorf("%w after %v", errTemplateTimeout, l.timeout)
+	}
+
+	return nil
+}
+
+// templateFuncMap sprig functions available to templates plus extra functions
+func templateFuncMap(options TemplateOptions, limits *executionLimits, funcs template.FuncMap) template.FuncMap {
+	fmap := sprig.TxtFuncMap()
+
+	for name, function := range funcs {
+		fmap[name] = function
+	}
+
+	fmap[timeoutCheckFunc] = func() (string, error) {
+		return "", limits.check()
+	}
+
+	if options.UnsafeFunctions {
+		return fmap
+	}
+
+	for _, name := range unsafeFunctions {
+		delete(fmap, name)
+	}
+
+	fmap["repeat"] = func(count int, str string) (string, error) {
+		if options.MaxOutputSize > 0 && count*len(str) > options.MaxOutputSize {
+			return "", errTemplateOutputSize
+		}
+
+		return strings.Repeat(str, count), nil
+	}
+
+	untilStep := fmap["untilStep"].(func(int, int, int) []int)
+	limitedUntilStep := func(start int, stop int, step int) ([]int, error) {
+		if err := limits.check(); err != nil {
+			return nil, err
+		}
+
+		if size := rangeSize(start, stop, step); size > maxRangeSize {
+			return nil, fmt.Errorf("%w (%v elements, limit %v)", errTemplateRangeSize, size, maxRangeSize)
+		}
+
+		return untilStep(start, stop, step), nil
+	}
+	fmap["untilStep"] = limitedUntilStep
+	fmap["until"] = func(count int) ([]int, error) {
+		if count < 0 {
+			return limitedUntilStep(0, count, -1)
+		}
+
+		return limitedUntilStep(0, count, 1)
+	}
+
+	return fmap
+}
+
+// rangeSize number of elements of untilStep (computed without overflow)
+func rangeSize(start int, stop int, step int) uint64 {
+	var distance, increment uint64
+
+	switch {
+	case step > 0 && stop > start:
+		distance, increment = uint64(stop)-uint64(start), uint64(step)
+	case step < 0 && stop < start:
+		distance, increment = uint64(start)-uint64(stop), -uint64(step)
+	default:
+		return 0
+	}
+
+	size := distance / increment
+	if distance%increment != 0 {
+		size++
+	}
+
+	return size
+}
+
+// renderTemplate parse and execute a template using options limits
+func renderTemplate(name string, text string, values interface{}, options TemplateOptions, funcs template.FuncMap, templateOption ...string) (string, error) {
+	limits := newExecutionLimits(options)
+	compiledTemplate, err := template.New(name).Funcs(templateFuncMap(options, limits, funcs)).Option(templateOption...).Parse(text)
+
+	if err != nil {
+		return "", err
+	}
+
+	checked := map[*parse.Tree]bool{}
+	for _, defined := range compiledTemplate.Templates() {
+		if defined.Tree == nil || defined.Tree.Root == nil || checked[defined.Tree] {
+			continue
+		}
+		checked[defined.Tree] = true
+
+		addTimeoutChecks(defined.Tree, defined.Tree.Root)
+		// recursive template calls without ranges or writes are stopped too
+		root := defined.Tree.Root
+		root.Nodes = append([]parse.Node{timeoutCheckNode(defined.Tree, root.Pos, 1)}, root.Nodes...)
+	}
+
+	// executed by the caller, the deadline is checked at writes, range iterations, range functions and template calls
+	output := &limitedWriter{limit: options.MaxOutputSize, limits: limits}
+	if err := compiledTemplate.Execute(output, values); err != nil {
+		return "", err
+	}
+
+	return output.sb.String(), nil
+}
+
+// addTimeoutChecks call timeout check function at the beginning of every range iteration (loops without writes are stopped too)
+func addTimeoutChecks(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+
+		for _, child := range n.Nodes {
+			addTimeoutChecks(tree, child)
+		}
+	case *parse.IfNode:
+		addTimeoutChecks(tree, n.List)
+		addTimeoutChecks(tree, n.ElseList)
+	case *parse.WithNode:
+		addTimeoutChecks(tree, n.List)
+		addTimeoutChecks(tree, n.ElseList)
+	case *parse.RangeNode:
+		addTimeoutChecks(tree, n.List)
+		addTimeoutChecks(tree, n.ElseList)
+
+		if n.List != nil {
+			n.List.Nodes = append([]parse.Node{timeoutCheckNode(tree, n.Pos, n.Line)}, n.List.Nodes...)
+		}
+	}
+}
+
+// timeoutCheckNode action calling timeout check function (errors are reported at range position)
+func timeoutCheckNode(tree *parse.Tree, pos parse.Pos, line int) parse.Node {
+	identifier := parse.NewIdentifier(timeoutCheckFunc).SetTree(tree).SetPos(pos)
+	command := &parse.CommandNode{NodeType: parse.NodeCommand, Pos: pos, Args: []parse.Node{identifier}}
+	pipe := &parse.PipeNode{NodeType: parse.NodePipe, Pos: pos, Line: line, Cmds: []*parse.CommandNode{command}}
+
+	return &parse.ActionNode{NodeType: parse.NodeAction, Pos: pos, Line: line, Pipe: pipe}
+}
+
+// limitedWriter writer that fails when the limit or the deadline is exceeded
+type limitedWriter struct {
+	sb     strings.Builder
+	limit  int
+	limits *executionLimits
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	// empty writes of timeout checks are ignored, their error has the template position
+	if w.limits != nil && len(p) > 0 {
+		if err := w.limits.check(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.limit > 0 && w.sb.Len()+len(p) > w.limit {
+		return 0, fmt.Errorf("%w (limit %v bytes)", errTemplateOutputSize, w.limit)
+	}
+
+	return w.sb.Write(p)
+}
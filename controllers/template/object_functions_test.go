@@ -0,0 +1,129 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Template functions", func() {
+	options := TemplateOptions{Timeout: time.Second, MaxOutputSize: 64}
+
+	Context("With default functions", func() {
+		It("Should not read environment variables", func() {
+			_, err := renderTemplate("test", `{{ env "HOME" }}`, nil, options, nil)
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`function "env" not defined`))
+		})
+
+		It("Should not use non-deterministic functions", func() {
+			for _, text := range []string{`{{ now }}`, `{{ randAlphaNum 5 }}`, `{{ uuidv4 }}`} {
+				_, err := renderTemplate("test", text, nil, options, nil)
+
+				Expect(err).To(HaveOccurred())
+			}
+		})
+
+		It("Should keep deterministic functions", func() {
+			output, err := renderTemplate("test", `{{ .name | upper | quote }}`, map[string]string{"name": "foo"}, options, nil)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal(`"FOO"`))
+		})
+	})
+
+	Context("With unsafe functions", func() {
+		It("Should allow all sprig functions", func() {
+			unsafe := options
+			unsafe.UnsafeFunctions = true
+
+			_, err := renderTemplate("test", `{{ env "HOME" }}{{ randAlpha 3 }}`, nil, unsafe, nil)
+
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("With limits", func() {
+		It("Should limit output size", func() {
+			_, err := renderTemplate("test", `{{ range until 100 }}0123456789{{ end }}`, nil, options, nil)
+
+			Expect(errors.Is(err, errTemplateOutputSize)).To(BeTrue())
+		})
+
+		It("Should limit repeat size", func() {
+			_, err := renderTemplate("test", `{{ repeat 1000000000 "x" }}`, nil, options, nil)
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(errTemplateOutputSize.Error()))
+		})
+
+		It("Should limit range functions size", func() {
+			for _, text := range []string{`{{ until 100000 }}`, `{{ until -100000 }}`, `{{ untilStep 0 100000 1 }}`, `{{ untilStep -9223372036854775808 9223372036854775807 1 }}`} {
+				_, err := renderTemplate("test", text, nil, options, nil)
+
+				Expect(errors.Is(err, errTemplateRangeSize)).To(BeTrue(), text)
+			}
+
+			output, err := renderTemplate("test", `{{ untilStep 10 0 -5 }}{{ until 3 }}`, nil, options, nil)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal("[10 5][0 1 2]"))
+		})
+
+		It("Should stop runaway templates without writes", func() {
+			limited := TemplateOptions{Timeout: 50 * time.Millisecond}
+			started := time.Now()
+
+			// a billion iterations over the same small list
+			_, err := renderTemplate("test", `{{ $l := until 1000 }}{{ range $l }}{{ range $l }}{{ range $l }}{{ end }}{{ end }}{{ end }}`, nil, limited, nil)
+
+			Expect(errors.Is(err, errTemplateTimeout)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("test:1:"))
+			Expect(time.Since(started)).To(BeNumerically("<", time.Second))
+		})
+
+		It("Should stop recursive template calls", func() {
+			limited := TemplateOptions{Timeout: 50 * time.Millisecond}
+			started := time.Now()
+
+			// 2^40 template calls without ranges or writes
+			_, err := renderTemplate("test", `{{define "a"}}{{if lt . 40}}{{template "a" (add . 1)}}{{template "a" (add . 1)}}{{end}}{{end}}{{template "a" 0}}`, nil, limited, nil)
+
+			Expect(errors.Is(err, errTemplateTimeout)).To(BeTrue())
+			Expect(time.Since(started)).To(BeNumerically("<", time.Second))
+		})
+
+		It("Should not change output of defined templates", func() {
+			output, err := renderTemplate("test", `{{define "a"}}[{{.}}]{{end}}{{template "a" 1}}{{block "b" 2}}({{.}}){{end}}`, nil, options, nil)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal("[1](2)"))
+		})
+
+		It("Should not change range output", func() {
+			output, err := renderTemplate("test", `{{ range $i, $v := list "a" "b" }}{{ $i }}={{ $v }} {{ else }}empty{{ end }}{{ range list }}x{{ else }}empty{{ end }}`, nil, options, nil)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output).To(Equal("0=a 1=b empty"))
+		})
+	})
+})
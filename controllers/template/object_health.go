@@ -0,0 +1,313 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// HealthStatus health of a generated object (kstatus like)
+type HealthStatus string
+
+const (
+	// HealthCurrent object reached the desired state
+	HealthCurrent HealthStatus = "Current"
+	// HealthInProgress object is progressing to the desired state
+	HealthInProgress HealthStatus = "InProgress"
+	// HealthFailed object failed to reach the desired state
+	HealthFailed HealthStatus = "Failed"
+)
+
+// computeHealth built-in health checks for well known kinds and generic conditions
+func computeHealth(obj unstructured.Unstructured) (HealthStatus, string) {
+	generation := obj.GetGeneration()
+	observedGeneration, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+
+	if found && observedGeneration < generation {
+		return HealthInProgress, fmt.Sprintf("observed generation %v is older than %v", observedGeneration, generation)
+	}
+
+	switch obj.GetKind() {
+	case "Deployment":
+		if condition := findCondition(obj, "Progressing"); condition != nil && condition["reason"] == "ProgressDeadlineExceeded" {
+			return HealthFailed, fmt.Sprint(condition["message"])
+		}
+		return replicasHealth(obj, "updatedReplicas", "availableReplicas")
+	case "StatefulSet":
+		currentRevision, _, _ := unstructured.NestedString(obj.Object, "status", "currentRevision")
+		updateRevision, _, _ := unstructured.NestedString(obj.Object, "status", "updateRevision")
+
+		if currentRevision != updateRevision {
+			return HealthInProgress, "rolling update in progress"
+		}
+		return replicasHealth(obj, "readyReplicas")
+	case "ReplicaSet":
+		return replicasHealth(obj, "readyReplicas", "availableReplicas")
+	case "DaemonSet":
+		desired, _, _ := unstructured.NestedInt64(obj.Object, "status", "desiredNumberScheduled")
+		updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedNumberScheduled")
+		available, _, _ := unstructured.NestedInt64(obj.Object, "status", "numberAvailable")
+
+		if updated < desired || available < desired {
+			return HealthInProgress, fmt.Sprintf("%v of %v pods available", available, desired)
+		}
+		return HealthCurrent, ""
+	case "Job":
+		if isConditionTrue(obj, "Failed") {
+			return HealthFailed, fmt.Sprint(findCondition(obj, "Failed")["message"])
+		}
+		if isConditionTrue(obj, "Complete") {
+			return HealthCurrent, ""
+		}
+		return HealthInProgress, "job not completed"
+	case "Pod":
+		phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+
+		switch {
+		case phase == "Failed":
+			return HealthFailed, "pod failed"
+		case phase == "Succeeded" || (phase == "Running" && isConditionTrue(obj, "Ready")):
+			return HealthCurrent, ""
+		}
+		return HealthInProgress, fmt.Sprintf("pod phase %v", phase)
+	case "PersistentVolumeClaim":
+		phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+
+		if phase != "Bound" {
+			return HealthInProgress, fmt.Sprintf("claim phase %v", phase)
+		}
+		return HealthCurrent, ""
+	case "Service":
+		serviceType, _, _ := unstructured.NestedString(obj.Object, "spec", "type")
+		ingress, _, _ := unstructured.NestedSlice(obj.Object, "status", "loadBalancer", "ingress")
+
+		if serviceType == "LoadBalancer" && len(ingress) == 0 {
+			return HealthInProgress, "waiting for load balancer"
+		}
+		return HealthCurrent, ""
+	case "CustomResourceDefinition":
+		if !isConditionTrue(obj, "Established") {
+			return HealthInProgress, "not established"
+		}
+		return HealthCurrent, ""
+	}
+
+	if isConditionTrue(obj, "Stalled") {
+		return HealthFailed, fmt.Sprint(findCondition(obj, "Stalled")["message"])
+	}
+
+	if isConditionTrue(obj, "Reconciling") {
+		return HealthInProgress, fmt.Sprint(findCondition(obj, "Reconciling")["message"])
+	}
+
+	if condition := findCondition(obj, "Ready"); condition != nil && condition["status"] != "True" {
+		return HealthInProgress, fmt.Sprint(condition["message"])
+	}
+
+	return HealthCurrent, ""
+}
+
+// HealthByTemplate health of objects generated by template using parameters. Objects that failed to render or apply
+// (applyErr of UpdateObjectsByTemplate) are failed and objects skipped because of them are not counted
+func (c *Common) HealthByTemplate(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams, applyErr error) (otv1.ObjectsHealth, []string, error) {
+	health := otv1.ObjectsHealth{Namespace: otp.Namespace}
+	messages := []string{}
+	failed, skipped := unappliedObjects(ot, applyErr)
+
+	for _, obj := range ot.Spec.Objects {
+		reference := fmt.Sprintf("%v: [%v(%v)]", ot.Name, obj.Kind, obj.Name)
+
+		if skipped[obj.Name] {
+			continue
+		}
+
+		if failed[obj.Name] {
+			health.Failed++
+			messages = append(messages, fmt.Sprintf("%v %v: not applied", reference, HealthFailed))
+			continue
+		}
+
+		current, err := c.GetObject(schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind), types.NamespacedName{Namespace: otp.Namespace, Name: obj.Name})
+
+		if k8sErrors.IsNotFound(err) {
+			health.InProgress++
+			messages = append(messages, fmt.Sprintf("%v %v: not found", reference, HealthInProgress))
+			continue
+		} else if err != nil {
+			return health, messages, err
+		}
+
+		status, message := computeHealth(current)
+
+		switch status {
+		case HealthCurrent:
+			health.Current++
+			continue
+		case HealthInProgress:
+			health.InProgress++
+		case HealthFailed:
+			health.Failed++
+		}
+
+		messages = append(messages, fmt.Sprintf("%v %v: %v", reference, status, message))
+	}
+
+	return health, messages, nil
+}
+
+// addObjectsHealth sum health counts by namespace
+func addObjectsHealth(namespaces []otv1.ObjectsHealth, health otv1.ObjectsHealth) []otv1.ObjectsHealth {
+	for i := range namespaces {
+		if namespaces[i].Namespace == health.Namespace {
+			namespaces[i].Current += health.Current
+			namespaces[i].InProgress += health.InProgress
+			namespaces[i].Failed += health.Failed
+			return namespaces
+		}
+	}
+
+	return append(namespaces, health)
+}
+
+// sumObjectsHealth sum health counts ignoring namespace
+func sumObjectsHealth(total *otv1.ObjectsHealth, health otv1.ObjectsHealth) *otv1.ObjectsHealth {
+	if total == nil {
+		return &health
+	}
+
+	total.Current += health.Current
+	total.InProgress += health.InProgress
+	total.Failed += health.Failed
+
+	return total
+}
+
+// setHealthyCondition set healthy condition using objects health counts
+func setHealthyCondition(conditions *[]metav1.Condition, generation int64, health otv1.ObjectsHealth, messages []string) {
+	condition := metav1.Condition{
+		Type:               otv1.ConditionHealthy,
+		Status:             metav1.ConditionTrue,
+		Reason:             string(HealthCurrent),
+		ObservedGeneration: generation,
+		Message:            strings.Join(messages, "\n"),
+	}
+
+	if health.Failed > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = string(HealthFailed)
+	} else if health.InProgress > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = string(HealthInProgress)
+	}
+
+	setStatusCondition(conditions, condition)
+}
+
+// isReady evaluate object readiness check
+func isReady(obj unstructured.Unstructured, check *otv1.ReadinessCheck) (bool, error) {
+	if check == nil {
+		return true, nil
+	}
+
+	if len(check.ConditionType) > 0 && !isConditionTrue(obj, check.ConditionType) {
+		return false, nil
+	}
+
+	if len(check.JSONPath) > 0 {
+		value, err := evaluateJSONPath(obj, check.JSONPath)
+
+		if err != nil {
+			return false, err
+		}
+
+		if (len(check.Value) == 0 && len(value) == 0) || (len(check.Value) > 0 && value != check.Value) {
+			return false, nil
+		}
+	}
+
+	if check.Health {
+		if health, _ := computeHealth(obj); health != HealthCurrent {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func evaluateJSONPath(obj unstructured.Unstructured, expression string) (string, error) {
+	if !strings.HasPrefix(expression, "{") {
+		expression = "{" + expression + "}"
+	}
+
+	jp := jsonpath.New("readiness").AllowMissingKeys(true)
+
+	if err := jp.Parse(expression); err != nil {
+		return "", err
+	}
+
+	sb := strings.Builder{}
+	if err := jp.Execute(&sb, obj.Object); err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
+
+func replicasHealth(obj unstructured.Unstructured, fields ...string) (HealthStatus, string) {
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+
+	if !found {
+		replicas = 1
+	}
+
+	for _, field := range fields {
+		value, _, _ := unstructured.NestedInt64(obj.Object, "status", field)
+
+		if value < replicas {
+			return HealthInProgress, fmt.Sprintf("%v %v of %v", field, value, replicas)
+		}
+	}
+
+	return HealthCurrent, ""
+}
+
+func findCondition(obj unstructured.Unstructured, conditionType string) map[string]interface{} {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+
+	for _, c := range conditions {
+		if condition, ok := c.(map[string]interface{}); ok && condition["type"] == conditionType {
+			return condition
+		}
+	}
+
+	return nil
+}
+
+func isConditionTrue(obj unstructured.Unstructured, conditionType string) bool {
+	condition := findCondition(obj, conditionType)
+
+	return condition != nil && condition["status"] == "True"
+}
@@ -0,0 +1,300 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Object health", func() {
+	deployment := func(replicas int64, available int64) unstructured.Unstructured {
+		return unstructured.Unstructured{Object: map[string]interface{}{
+			"kind": "Deployment",
+			"spec": map[string]interface{}{"replicas": replicas},
+			"status": map[string]interface{}{
+				"updatedReplicas":   available,
+				"availableReplicas": available,
+				"conditions": []interface{}{
+					map[string]interface{}{"type": "Available", "status": "True"},
+				},
+			},
+		}}
+	}
+
+	Context("With a deployment", func() {
+		It("Should be current when all replicas are available", func() {
+			health, _ := computeHealth(deployment(2, 2))
+			Expect(health).To(Equal(HealthCurrent))
+		})
+		It("Should be in progress when replicas are missing", func() {
+			health, _ := computeHealth(deployment(2, 1))
+			Expect(health).To(Equal(HealthInProgress))
+		})
+	})
+
+	Context("With readiness checks", func() {
+		It("Should evaluate conditions and json path", func() {
+			obj := deployment(1, 1)
+
+			Expect(isReady(obj, nil)).To(BeTrue())
+			Expect(isReady(obj, &otv1.ReadinessCheck{ConditionType: "Available"})).To(BeTrue())
+			Expect(isReady(obj, &otv1.ReadinessCheck{ConditionType: "Progressing"})).To(BeFalse())
+			Expect(isReady(obj, &otv1.ReadinessCheck{JSONPath: ".status.availableReplicas", Value: "1"})).To(BeTrue())
+			Expect(isReady(obj, &otv1.ReadinessCheck{JSONPath: ".status.readyReplicas"})).To(BeFalse())
+			Expect(isReady(obj, &otv1.ReadinessCheck{Health: true})).To(BeTrue())
+		})
+	})
+
+	Context("With well known kinds", func() {
+		object := func(kind string, spec map[string]interface{}, status map[string]interface{}) unstructured.Unstructured {
+			return unstructured.Unstructured{Object: map[string]interface{}{"kind": kind, "spec": spec, "status": status}}
+		}
+		conditions := func(conditions ...map[string]interface{}) []interface{} {
+			result := []interface{}{}
+			for _, condition := range conditions {
+				result = append(result, condition)
+			}
+			return result
+		}
+		condition := func(conditionType string, status string) map[string]interface{} {
+			return map[string]interface{}{"type": conditionType, "status": status, "reason": "Test", "message": conditionType + " " + status}
+		}
+		cases := []struct {
+			name   string
+			obj    unstructured.Unstructured
+			health HealthStatus
+		}{
+			{"deployment past progress deadline", object("Deployment", nil, map[string]interface{}{
+				"conditions": conditions(map[string]interface{}{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded"}),
+			}), HealthFailed},
+			{"statefulset rolling update", object("StatefulSet", nil, map[string]interface{}{"currentRevision": "a", "updateRevision": "b", "readyReplicas": int64(1)}), HealthInProgress},
+			{"statefulset ready", object("StatefulSet", nil, map[string]interface{}{"currentRevision": "a", "updateRevision": "a", "readyReplicas": int64(1)}), HealthCurrent},
+			{"replicaset not ready", object("ReplicaSet", map[string]interface{}{"replicas": int64(3)}, map[string]interface{}{"readyReplicas": int64(3), "availableReplicas": int64(2)}), HealthInProgress},
+			{"daemonset updating", object("DaemonSet", nil, map[string]interface{}{"desiredNumberScheduled": int64(3), "updatedNumberScheduled": int64(2), "numberAvailable": int64(3)}), HealthInProgress},
+			{"daemonset available", object("DaemonSet", nil, map[string]interface{}{"desiredNumberScheduled": int64(3), "updatedNumberScheduled": int64(3), "numberAvailable": int64(3)}), HealthCurrent},
+			{"job failed", object("Job", nil, map[string]interface{}{"conditions": conditions(condition("Failed", "True"))}), HealthFailed},
+			{"job complete", object("Job", nil, map[string]interface{}{"conditions": conditions(condition("Complete", "True"))}), HealthCurrent},
+			{"job running", object("Job", nil, map[string]interface{}{}), HealthInProgress},
+			{"pod failed", object("Pod", nil, map[string]interface{}{"phase": "Failed"}), HealthFailed},
+			{"pod succeeded", object("Pod", nil, map[string]interface{}{"phase": "Succeeded"}), HealthCurrent},
+			{"pod running and ready", object("Pod", nil, map[string]interface{}{"phase": "Running", "conditions": conditions(condition("Ready", "True"))}), HealthCurrent},
+			{"pod running not ready", object("Pod", nil, map[string]interface{}{"phase": "Running", "conditions": conditions(condition("Ready", "False"))}), HealthInProgress},
+			{"claim pending", object("PersistentVolumeClaim", nil, map[string]interface{}{"phase": "Pending"}), HealthInProgress},
+			{"claim bound", object("PersistentVolumeClaim", nil, map[string]interface{}{"phase": "Bound"}), HealthCurrent},
+			{"load balancer pending", object("Service", map[string]interface{}{"type": "LoadBalancer"}, map[string]interface{}{}), HealthInProgress},
+			{"cluster ip service", object("Service", map[string]interface{}{"type": "ClusterIP"}, map[string]interface{}{}), HealthCurrent},
+			{"crd not established", object("CustomResourceDefinition", nil, map[string]interface{}{"conditions": conditions(condition("Established", "False"))}), HealthInProgress},
+			{"crd established", object("CustomResourceDefinition", nil, map[string]interface{}{"conditions": conditions(condition("Established", "True"))}), HealthCurrent},
+			{"stalled custom resource", object("Database", nil, map[string]interface{}{"conditions": conditions(condition("Stalled", "True"))}), HealthFailed},
+			{"reconciling custom resource", object("Database", nil, map[string]interface{}{"conditions": conditions(condition("Reconciling", "True"))}), HealthInProgress},
+			{"not ready custom resource", object("Database", nil, map[string]interface{}{"conditions": conditions(condition("Ready", "False"))}), HealthInProgress},
+			{"custom resource without conditions", object("Database", nil, map[string]interface{}{}), HealthCurrent},
+			{"config map", object("ConfigMap", nil, nil), HealthCurrent},
+		}
+
+		for _, c := range cases {
+			c := c
+
+			It("Should compute health of "+c.name, func() {
+				health, _ := computeHealth(c.obj)
+				Expect(health).To(Equal(c.health))
+			})
+		}
+
+		It("Should be in progress while generation is not observed", func() {
+			obj := deployment(1, 1)
+			obj.SetGeneration(3)
+			Expect(unstructured.SetNestedField(obj.Object, int64(2), "status", "observedGeneration")).To(Succeed())
+
+			health, message := computeHealth(obj)
+			Expect(health).To(Equal(HealthInProgress))
+			Expect(message).To(ContainSubstring("observed generation 2"))
+		})
+	})
+
+	Context("With health of many objects", func() {
+		It("Should add health counts by namespace", func() {
+			namespaces := []otv1.ObjectsHealth{}
+			namespaces = addObjectsHealth(namespaces, otv1.ObjectsHealth{Namespace: "team-a", Current: 1, InProgress: 1})
+			namespaces = addObjectsHealth(namespaces, otv1.ObjectsHealth{Namespace: "team-b", Failed: 1})
+			namespaces = addObjectsHealth(namespaces, otv1.ObjectsHealth{Namespace: "team-a", Current: 2, Failed: 1})
+
+			Expect(namespaces).To(Equal([]otv1.ObjectsHealth{
+				{Namespace: "team-a", Current: 3, InProgress: 1, Failed: 1},
+				{Namespace: "team-b", Failed: 1},
+			}))
+		})
+
+		It("Should sum health counts of all namespaces", func() {
+			var total *otv1.ObjectsHealth
+			total = sumObjectsHealth(total, otv1.ObjectsHealth{Namespace: "team-a", Current: 1, InProgress: 2})
+			total = sumObjectsHealth(total, otv1.ObjectsHealth{Namespace: "team-b", Current: 1, Failed: 1})
+
+			Expect(*total).To(Equal(otv1.ObjectsHealth{Namespace: "team-a", Current: 2, InProgress: 2, Failed: 1}))
+		})
+	})
+
+	Context("With healthy condition", func() {
+		It("Should change status and reason with health counts", func() {
+			conditions := []metav1.Condition{}
+
+			setHealthyCondition(&conditions, 1, otv1.ObjectsHealth{Current: 2}, nil)
+			healthy := meta.FindStatusCondition(conditions, otv1.ConditionHealthy)
+			Expect(healthy.Status).To(Equal(metav1.ConditionTrue))
+			Expect(healthy.Reason).To(Equal(string(HealthCurrent)))
+
+			healthy.LastTransitionTime = metav1.NewTime(time.Now().Add(-time.Hour))
+			transition := healthy.LastTransitionTime
+			setHealthyCondition(&conditions, 2, otv1.ObjectsHealth{Current: 1, InProgress: 1}, []string{"waiting"})
+			healthy = meta.FindStatusCondition(conditions, otv1.ConditionHealthy)
+			Expect(healthy.Status).To(Equal(metav1.ConditionFalse))
+			Expect(healthy.Reason).To(Equal(string(HealthInProgress)))
+			Expect(healthy.Message).To(Equal("waiting"))
+			Expect(healthy.ObservedGeneration).To(Equal(int64(2)))
+			Expect(healthy.LastTransitionTime).NotTo(Equal(transition))
+
+			transition = healthy.LastTransitionTime
+			setHealthyCondition(&conditions, 2, otv1.ObjectsHealth{InProgress: 1, Failed: 1}, []string{"failed", "waiting"})
+			healthy = meta.FindStatusCondition(conditions, otv1.ConditionHealthy)
+			Expect(healthy.Status).To(Equal(metav1.ConditionFalse))
+			Expect(healthy.Reason).To(Equal(string(HealthFailed)))
+			Expect(healthy.Message).To(Equal("failed\nwaiting"))
+			Expect(healthy.LastTransitionTime).To(Equal(transition))
+			Expect(conditions).To(HaveLen(1))
+		})
+	})
+
+	Context("With objects not ready", func() {
+		It("Should requeue parameters until objects are healthy", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(otv1.AddToScheme(scheme)).To(Succeed())
+
+			template := &otv1.ObjectTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "web"},
+				Spec: otv1.ObjectTemplateSpec{Objects: []otv1.Object{{
+					APIVersion:   "apps/v1",
+					Kind:         "Deployment",
+					Name:         "web",
+					TemplateBody: "spec:\n  replicas: 1\n",
+				}}},
+			}
+			params := &otv1.ObjectTemplateParams{
+				ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "team-a", Finalizers: []string{otv1.Finalizer}},
+				Spec:       otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{{Name: "web"}}},
+			}
+			reconciler := &ObjectTemplateParamsReconciler{
+				Client: fake.NewFakeClientWithScheme(scheme, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}, template, params),
+				Log:    ctrl.Log.WithName("test"),
+				Scheme: scheme,
+			}
+			request := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "team-a", Name: "params"}}
+
+			result, err := reconciler.Reconcile(request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(requeueNotReady(0)))
+
+			stored := otv1.ObjectTemplateParams{}
+			Expect(reconciler.Get(context.Background(), request.NamespacedName, &stored)).To(Succeed())
+			healthy := meta.FindStatusCondition(stored.Status.Conditions, otv1.ConditionHealthy)
+			Expect(healthy).NotTo(BeNil())
+			Expect(healthy.Reason).To(Equal(string(HealthInProgress)))
+
+			deployment := unstructured.Unstructured{}
+			deployment.SetAPIVersion("apps/v1")
+			deployment.SetKind("Deployment")
+			Expect(reconciler.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "web"}, &deployment)).To(Succeed())
+			Expect(unstructured.SetNestedMap(deployment.Object, map[string]interface{}{"updatedReplicas": int64(1), "availableReplicas": int64(1)}, "status")).To(Succeed())
+			Expect(reconciler.Update(context.Background(), &deployment)).To(Succeed())
+
+			result, err = reconciler.Reconcile(request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeZero())
+		})
+	})
+
+	Context("With objects that failed to apply", func() {
+		template := otv1.ObjectTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "web"},
+			Spec: otv1.ObjectTemplateSpec{Objects: []otv1.Object{
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "config", TemplateBody: "data:\n  value: {{ nope }}\n"},
+				{APIVersion: "apps/v1", Kind: "Deployment", Name: "web", DependsOn: []string{"config"}, TemplateBody: "spec:\n  replicas: 1\n"},
+			}},
+		}
+
+		It("Should count failed objects and ignore skipped objects", func() {
+			common := Common{Client: fake.NewFakeClientWithScheme(clientgoscheme.Scheme)}
+			applyErr := &ObjectsError{Errors: []error{errors.New("render failed")}, Failed: []string{"config"}, Skipped: []string{"web"}}
+
+			health, messages, err := common.HealthByTemplate(template, otv1.ObjectTemplateParams{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a"}}, applyErr)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(health).To(Equal(otv1.ObjectsHealth{Namespace: "team-a", Failed: 1}))
+			Expect(messages).To(Equal([]string{"web: [ConfigMap(config)] Failed: not applied"}))
+		})
+
+		It("Should count all objects as failed when the template failed", func() {
+			common := Common{Client: fake.NewFakeClientWithScheme(clientgoscheme.Scheme)}
+
+			health, _, err := common.HealthByTemplate(template, otv1.ObjectTemplateParams{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a"}}, errors.New("circular dependency"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(health).To(Equal(otv1.ObjectsHealth{Namespace: "team-a", Failed: 2}))
+		})
+
+		It("Should not requeue parameters", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(otv1.AddToScheme(scheme)).To(Succeed())
+
+			params := &otv1.ObjectTemplateParams{
+				ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "team-a", Finalizers: []string{otv1.Finalizer}},
+				Spec:       otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{{Name: "web"}}},
+			}
+			reconciler := &ObjectTemplateParamsReconciler{
+				Client: fake.NewFakeClientWithScheme(scheme, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}, template.DeepCopy(), params),
+				Log:    ctrl.Log.WithName("test"),
+				Scheme: scheme,
+			}
+			request := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "team-a", Name: "params"}}
+
+			result, err := reconciler.Reconcile(request)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeZero())
+
+			stored := otv1.ObjectTemplateParams{}
+			Expect(reconciler.Get(context.Background(), request.NamespacedName, &stored)).To(Succeed())
+			healthy := meta.FindStatusCondition(stored.Status.Conditions, otv1.ConditionHealthy)
+			Expect(healthy).NotTo(BeNil())
+			Expect(healthy.Reason).To(Equal(string(HealthFailed)))
+			Expect(stored.Status.Status).To(ContainSubstring("objects web skipped because of failed objects"))
+		})
+	})
+})
@@ -0,0 +1,205 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups="",resources=groups,verbs=impersonate
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=impersonate
+
+const defaultMaxImpersonatedClients = 100
+
+var errServiceAccountRequired = errors.New("a service account is required to apply objects, set serviceAccountName in template or parameters")
+
+// Impersonator build clients impersonating service accounts
+type Impersonator struct {
+	Config *rest.Config
+	Scheme *runtime.Scheme
+	Mapper meta.RESTMapper
+	// Required objects are only applied impersonating a service account (operator permissions are never used)
+	Required bool
+	// MaxClients maximum number of cached clients, the least recently used is evicted (uses default if zero)
+	MaxClients int
+
+	mutex   sync.Mutex
+	clients map[string]*list.Element
+	recent  *list.List
+}
+
+// impersonatedClient cached client of a service account
+type impersonatedClient struct {
+	userName string
+	client   client.Client
+}
+
+// ClientFor client impersonating a service account (clients are reused by user name)
+func (i *Impersonator) ClientFor(namespaceName string, serviceAccountName string) (client.Client, error) {
+	userName := serviceAccountUserName(namespaceName, serviceAccountName)
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if element, found := i.clients[userName]; found {
+		i.recent.MoveToFront(element)
+		return element.Value.(*impersonatedClient).client, nil
+	}
+
+	config := rest.CopyConfig(i.Config)
+	config.Impersonate = serviceAccountImpersonation(namespaceName, serviceAccountName)
+
+	c, err := client.New(config, client.Options{Scheme: i.Scheme, Mapper: i.Mapper})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if i.clients == nil {
+		i.clients = map[string]*list.Element{}
+		i.recent = list.New()
+	}
+	i.clients[userName] = i.recent.PushFront(&impersonatedClient{userName: userName, client: c})
+
+	maxClients := i.MaxClients
+	if maxClients <= 0 {
+		maxClients = defaultMaxImpersonatedClients
+	}
+	for i.recent.Len() > maxClients {
+		oldest := i.recent.Back()
+		i.recent.Remove(oldest)
+		delete(i.clients, oldest.Value.(*impersonatedClient).userName)
+	}
+
+	return c, nil
+}
+
+// objectsClient client used to write objects generated by template using parameters
+func (c *Common) objectsClient(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) (client.Client, error) {
+	templateAccount, paramsAccount := ot.Spec.ServiceAccountName, otp.Spec.ServiceAccountName
+
+	if len(templateAccount) == 0 && len(paramsAccount) == 0 {
+		if c.Impersonator != nil && c.Impersonator.Required {
+			return nil, errServiceAccountRequired
+		}
+
+		return c.Client, nil
+	}
+
+	if c.Impersonator == nil {
+		return nil, fmt.Errorf("unable to impersonate service account %v: impersonation is not configured", strings.Trim(templateAccount+" "+paramsAccount, " "))
+	}
+
+	if len(templateAccount) == 0 {
+		return c.Impersonator.ClientFor(otp.Namespace, paramsAccount)
+	}
+
+	templateClient, err := c.Impersonator.ClientFor(otp.Namespace, templateAccount)
+
+	if err != nil || len(paramsAccount) == 0 || paramsAccount == templateAccount {
+		return templateClient, err
+	}
+
+	paramsClient, err := c.Impersonator.ClientFor(otp.Namespace, paramsAccount)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// parameters service account restricts the template service account
+	return &restrictedClient{Client: templateClient, restriction: paramsClient, serviceAccountName: paramsAccount}, nil
+}
+
+// restrictedClient client writing objects only if restriction client is also allowed to (checked using a server side dry-run)
+type restrictedClient struct {
+	client.Client
+	restriction        client.Client
+	serviceAccountName string
+}
+
+// Create create object if allowed by restriction
+func (r *restrictedClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if err := r.restriction.Create(ctx, obj.DeepCopyObject(), append(opts, client.DryRunAll)...); err != nil {
+		return r.restrictionError(err)
+	}
+
+	return r.Client.Create(ctx, obj, opts...)
+}
+
+// Update update object if allowed by restriction
+func (r *restrictedClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if err := r.restriction.Update(ctx, obj.DeepCopyObject(), append(opts, client.DryRunAll)...); err != nil {
+		return r.restrictionError(err)
+	}
+
+	return r.Client.Update(ctx, obj, opts...)
+}
+
+// Patch patch object if allowed by restriction
+func (r *restrictedClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if err := r.restriction.Patch(ctx, obj.DeepCopyObject(), patch, append(opts, client.DryRunAll)...); err != nil {
+		return r.restrictionError(err)
+	}
+
+	return r.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// Delete delete object if allowed by restriction
+func (r *restrictedClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	if err := r.restriction.Delete(ctx, obj.DeepCopyObject(), append(opts, client.DryRunAll)...); err != nil {
+		return r.restrictionError(err)
+	}
+
+	return r.Client.Delete(ctx, obj, opts...)
+}
+
+// DeleteAllOf delete objects if allowed by restriction
+func (r *restrictedClient) DeleteAllOf(ctx context.Context, obj runtime.Object, opts ...client.DeleteAllOfOption) error {
+	if err := r.restriction.DeleteAllOf(ctx, obj.DeepCopyObject(), append(opts, client.DryRunAll)...); err != nil {
+		return r.restrictionError(err)
+	}
+
+	return r.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (r *restrictedClient) restrictionError(err error) error {
+	return fmt.Errorf("parameters service account %v: %w", r.serviceAccountName, err)
+}
+
+func serviceAccountUserName(namespaceName string, serviceAccountName string) string {
+	return fmt.Sprintf("system:serviceaccount:%v:%v", namespaceName, serviceAccountName)
+}
+
+// serviceAccountImpersonation impersonation of a service account with the groups the API server gives to its tokens,
+// so permissions granted to service account groups also apply
+func serviceAccountImpersonation(namespaceName string, serviceAccountName string) rest.ImpersonationConfig {
+	return rest.ImpersonationConfig{
+		UserName: serviceAccountUserName(namespaceName, serviceAccountName),
+		Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:" + namespaceName, "system:authenticated"},
+	}
+}
@@ -0,0 +1,168 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// forbiddenClient client refusing all writes
+type forbiddenClient struct {
+	client.Client
+}
+
+func (f forbiddenClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	return k8sErrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, "settings", nil)
+}
+
+var _ = Describe("Service account impersonation", func() {
+	template := func(serviceAccountName string) otv1.ObjectTemplate {
+		return otv1.ObjectTemplate{Spec: otv1.ObjectTemplateSpec{ServiceAccountName: serviceAccountName}}
+	}
+	params := func(serviceAccountName string) otv1.ObjectTemplateParams {
+		return otv1.ObjectTemplateParams{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a"},
+			Spec:       otv1.ObjectTemplateParamsSpec{ServiceAccountName: serviceAccountName},
+		}
+	}
+	newImpersonator := func() *Impersonator {
+		return &Impersonator{
+			Config: &rest.Config{Host: "https://127.0.0.1:6443"},
+			Scheme: scheme.Scheme,
+			Mapper: meta.NewDefaultRESTMapper([]schema.GroupVersion{}),
+		}
+	}
+
+	It("Should use the service account informed by template or parameters", func() {
+		common := Common{Client: fake.NewFakeClientWithScheme(scheme.Scheme), Impersonator: newImpersonator()}
+
+		objectsClient, err := common.objectsClient(template(""), params(""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objectsClient).To(BeIdenticalTo(common.Client))
+
+		objectsClient, err = common.objectsClient(template("deployer"), params(""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objectsClient).To(BeIdenticalTo(common.Impersonator.clients["system:serviceaccount:team-a:deployer"].Value.(*impersonatedClient).client))
+
+		objectsClient, err = common.objectsClient(template(""), params("tenant"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objectsClient).To(BeIdenticalTo(common.Impersonator.clients["system:serviceaccount:team-a:tenant"].Value.(*impersonatedClient).client))
+	})
+
+	It("Should restrict the template service account by the parameters service account", func() {
+		common := Common{Impersonator: newImpersonator()}
+		objectsClient, err := common.objectsClient(template("deployer"), params("tenant"))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objectsClient).To(BeAssignableToTypeOf(&restrictedClient{}))
+		Expect(objectsClient.(*restrictedClient).serviceAccountName).To(Equal("tenant"))
+	})
+
+	It("Should require a service account when configured", func() {
+		common := Common{Client: fake.NewFakeClientWithScheme(scheme.Scheme), Impersonator: newImpersonator()}
+		common.Impersonator.Required = true
+
+		_, err := common.objectsClient(template(""), params(""))
+		Expect(err).To(Equal(errServiceAccountRequired))
+
+		_, err = common.objectsClient(template(""), params("tenant"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Should fail if impersonation is not configured", func() {
+		common := Common{}
+		_, err := common.objectsClient(template("deployer"), params(""))
+
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should reuse clients by service account", func() {
+		impersonator := newImpersonator()
+
+		first, err := impersonator.ClientFor("team-a", "deployer")
+		Expect(err).NotTo(HaveOccurred())
+
+		second, err := impersonator.ClientFor("team-a", "deployer")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second).To(BeIdenticalTo(first))
+
+		Expect(impersonator.clients).To(HaveKey("system:serviceaccount:team-a:deployer"))
+	})
+
+	It("Should impersonate service account groups", func() {
+		Expect(serviceAccountImpersonation("team-a", "deployer")).To(Equal(rest.ImpersonationConfig{
+			UserName: "system:serviceaccount:team-a:deployer",
+			Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:team-a", "system:authenticated"},
+		}))
+	})
+
+	It("Should evict the least recently used client", func() {
+		impersonator := newImpersonator()
+		impersonator.MaxClients = 2
+
+		first, _ := impersonator.ClientFor("team-a", "first")
+		impersonator.ClientFor("team-a", "second")
+		Expect(impersonator.ClientFor("team-a", "first")).To(BeIdenticalTo(first))
+		impersonator.ClientFor("team-a", "third")
+
+		Expect(impersonator.clients).To(HaveLen(2))
+		Expect(impersonator.clients).To(HaveKey("system:serviceaccount:team-a:first"))
+		Expect(impersonator.clients).NotTo(HaveKey("system:serviceaccount:team-a:second"))
+		Expect(impersonator.recent.Len()).To(Equal(2))
+	})
+
+	Context("With restricted client", func() {
+		configMap := func() *corev1.ConfigMap {
+			return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a"}}
+		}
+
+		It("Should write objects allowed by restriction", func() {
+			templateClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+			restricted := &restrictedClient{Client: templateClient, restriction: fake.NewFakeClientWithScheme(scheme.Scheme), serviceAccountName: "tenant"}
+
+			Expect(restricted.Create(context.Background(), configMap())).To(Succeed())
+			Expect(templateClient.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "settings"}, &corev1.ConfigMap{})).To(Succeed())
+		})
+
+		It("Should not write objects refused by restriction", func() {
+			templateClient := fake.NewFakeClientWithScheme(scheme.Scheme)
+			restricted := &restrictedClient{Client: templateClient, restriction: forbiddenClient{}, serviceAccountName: "tenant"}
+
+			err := restricted.Create(context.Background(), configMap())
+			Expect(k8sErrors.IsForbidden(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("parameters service account tenant"))
+
+			err = templateClient.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "settings"}, &corev1.ConfigMap{})
+			Expect(k8sErrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+})
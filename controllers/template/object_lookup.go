@@ -0,0 +1,399 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"text/template"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	"github.com/go-logr/logr"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var namespaceGVK = schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}
+
+// lookupRef object read by a template function (all objects of namespace if name is empty)
+type lookupRef struct {
+	GVK       schema.GroupVersionKind
+	Namespace string
+	Name      string
+}
+
+// matches object read by this lookup
+func (l lookupRef) matches(gvk schema.GroupVersionKind, namespaceName string, name string) bool {
+	return l.GVK == gvk && l.Namespace == namespaceName && (len(l.Name) == 0 || l.Name == name)
+}
+
+// lookupRecorder objects read while rendering templates
+type lookupRecorder struct {
+	mutex sync.Mutex
+	refs  []lookupRef
+}
+
+func (r *lookupRecorder) record(ref lookupRef) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, recorded := range r.refs {
+		if recorded == ref {
+			return
+		}
+	}
+	r.refs = append(r.refs, ref)
+}
+
+func (r *lookupRecorder) recorded() []lookupRef {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]lookupRef{}, r.refs...)
+}
+
+// LookupTracker track objects read by templates and reconcile parameters again when they change
+type LookupTracker struct {
+	// Controller parameters controller used to watch looked up kinds
+	Controller controller.Controller
+	// Reader informer backed reader of looked up objects (the manager client reads unstructured objects from the API server)
+	Reader client.Reader
+	Log    logr.Logger
+
+	mutex   sync.Mutex
+	watched map[schema.GroupVersionKind]bool
+	lookups map[string]trackedLookups
+}
+
+type trackedLookups struct {
+	template string
+	params   types.NamespacedName
+	refs     []lookupRef
+}
+
+// Track replace objects read by a template rendered using parameters, watching new kinds
+func (t *LookupTracker) Track(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams, refs []lookupRef) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	key := fmt.Sprintf("%v/%v", ot.Name, paramsKey(otp))
+
+	if len(refs) == 0 {
+		delete(t.lookups, key)
+		return nil
+	}
+
+	if t.lookups == nil {
+		t.lookups = map[string]trackedLookups{}
+	}
+	t.lookups[key] = trackedLookups{template: ot.Name, params: types.NamespacedName{Namespace: otp.Namespace, Name: otp.Name}, refs: refs}
+
+	for _, ref := range refs {
+		if err := t.watch(ref.GVK); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ForgetParams stop tracking objects read by any template rendered using parameters
+func (t *LookupTracker) ForgetParams(params types.NamespacedName) {
+	t.forget(func(tracked trackedLookups) bool { return tracked.params == params })
+}
+
+// ForgetTemplate stop tracking objects read by a template rendered using any parameters
+func (t *LookupTracker) ForgetTemplate(templateName string) {
+	t.forget(func(tracked trackedLookups) bool { return tracked.template == templateName })
+}
+
+func (t *LookupTracker) forget(matches func(trackedLookups) bool) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for key, tracked := range t.lookups {
+		if matches(tracked) {
+			delete(t.lookups, key)
+		}
+	}
+}
+
+// watch start watching a kind (only once)
+func (t *LookupTracker) watch(gvk schema.GroupVersionKind) error {
+	if t.Controller == nil || t.watched[gvk] {
+		return nil
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+
+	err := t.Controller.Watch(&source.Kind{Type: obj}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(t.dependents),
+	})
+
+	if err != nil {
+		return fmt.Errorf("Error watching looked up kind %v: %w", gvk, err)
+	}
+
+	if t.watched == nil {
+		t.watched = map[schema.GroupVersionKind]bool{}
+	}
+	t.watched[gvk] = true
+	t.Log.Info("Watching looked up kind", "kind", gvk.String())
+
+	return nil
+}
+
+// dependents parameters that rendered templates reading the object
+func (t *LookupTracker) dependents(obj handler.MapObject) []reconcile.Request {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	gvk := obj.Object.GetObjectKind().GroupVersionKind()
+	requested := map[types.NamespacedName]bool{}
+	requests := []reconcile.Request{}
+
+	for _, tracked := range t.lookups {
+		if requested[tracked.params] {
+			continue
+		}
+
+		for _, ref := range tracked.refs {
+			if ref.matches(gvk, obj.Meta.GetNamespace(), obj.Meta.GetName()) {
+				requested[tracked.params] = true
+				requests = append(requests, reconcile.Request{NamespacedName: tracked.params})
+				break
+			}
+		}
+	}
+
+	return requests
+}
+
+// recordingLookups copy of common recording objects read by templates
+func (c *Common) recordingLookups() *Common {
+	recording := *c
+	recording.recorder = &lookupRecorder{}
+
+	return &recording
+}
+
+// trackLookups track objects read while rendering template using parameters
+func (c *Common) trackLookups(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) {
+	if c.Lookups == nil || c.recorder == nil {
+		return
+	}
+
+	if err := c.Lookups.Track(ot, otp, c.recorder.recorded()); err != nil {
+		c.Log.Error(err, "Failed to track template lookups")
+	}
+}
+
+// lookupReader reader of looked up objects (informer backed if configured)
+func (c *Common) lookupReader() client.Reader {
+	if c.Lookups != nil && c.Lookups.Reader != nil {
+		return c.Lookups.Reader
+	}
+
+	return c.Client
+}
+
+// lookupClient reader of objects looked up by the template being rendered and if lookups are restricted to the
+// current namespace (without Secrets). Templates or parameters with a service account read objects with its
+// permissions from the API server, other templates read the operator cache
+func (c *Common) lookupClient() (client.Reader, bool, error) {
+	if c.rendering == nil || !hasServiceAccount(c.rendering.template, c.rendering.params) {
+		return c.lookupReader(), !templateOptions.UnrestrictedLookups, nil
+	}
+
+	// offline rendering has no permissions to check
+	if c.Impersonator == nil {
+		return c.Client, false, nil
+	}
+
+	objectsClient, err := c.objectsClient(c.rendering.template, c.rendering.params)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if restricted, ok := objectsClient.(*restrictedClient); ok {
+		return &restrictedReader{Reader: restricted.Client, restriction: restricted.restriction, serviceAccountName: restricted.serviceAccountName}, false, nil
+	}
+
+	return objectsClient, false, nil
+}
+
+// hasServiceAccount template or parameters impersonate a service account
+func hasServiceAccount(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) bool {
+	return len(ot.Spec.ServiceAccountName) > 0 || len(otp.Spec.ServiceAccountName) > 0
+}
+
+// restrictedReader read objects only if also allowed to the parameters service account
+type restrictedReader struct {
+	client.Reader
+	restriction        client.Reader
+	serviceAccountName string
+}
+
+// Get get object if allowed by restriction
+func (r *restrictedReader) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if err := r.restriction.Get(ctx, key, obj.DeepCopyObject()); err != nil && !k8sErrors.IsNotFound(err) {
+		return fmt.Errorf("parameters service account %v: %w", r.serviceAccountName, err)
+	}
+
+	return r.Reader.Get(ctx, key, obj)
+}
+
+// List list objects if allowed by restriction
+func (r *restrictedReader) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	if err := r.restriction.List(ctx, list.DeepCopyObject(), opts...); err != nil {
+		return fmt.Errorf("parameters service account %v: %w", r.serviceAccountName, err)
+	}
+
+	return r.Reader.List(ctx, list, opts...)
+}
+
+// checkRestrictedLookup templates without a service account only read objects of the current namespace, except Secrets
+func checkRestrictedLookup(gvk schema.GroupVersionKind, currentNamespace string, namespaceName string) error {
+	if len(gvk.Group) == 0 && gvk.Kind == "Secret" {
+		return fmt.Errorf("lookup of Secrets requires a service account")
+	}
+
+	if namespaceName != currentNamespace {
+		return fmt.Errorf("lookup of %v in namespace %v requires a service account (only namespace %v is allowed)", gvk.Kind, namespaceName, currentNamespace)
+	}
+
+	return nil
+}
+
+// lookupFuncMap template functions reading objects from cluster
+func (c *Common) lookupFuncMap(namespaceName string) template.FuncMap {
+	return template.FuncMap{
+		"lookup": func(apiVersion string, kind string, lookupNamespace string, name string) (map[string]interface{}, error) {
+			return c.lookup(namespaceName, apiVersion, kind, lookupNamespace, name)
+		},
+		"namespaceLabels": func(names ...string) (map[string]string, error) {
+			namespace, err := c.lookupNamespace(namespaceName, names)
+			return namespace.GetLabels(), err
+		},
+		"namespaceAnnotations": func(names ...string) (map[string]string, error) {
+			namespace, err := c.lookupNamespace(namespaceName, names)
+			return namespace.GetAnnotations(), err
+		},
+	}
+}
+
+// lookup get an object (or list objects if name is empty) like helm, returning an empty map if not found. Objects
+// read with the operator cache are watched, objects read with a service account are read again on resync
+func (c *Common) lookup(currentNamespace string, apiVersion string, kind string, namespaceName string, name string) (map[string]interface{}, error) {
+	gvk := schema.FromAPIVersionAndKind(apiVersion, kind)
+
+	if err := c.CheckPoliciesLookup(gvk, namespaceName); err != nil {
+		return nil, err
+	}
+
+	reader, restricted, err := c.lookupClient()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if restricted {
+		if err := checkRestrictedLookup(gvk, currentNamespace, namespaceName); err != nil {
+			return nil, err
+		}
+		c.recorder.record(lookupRef{GVK: gvk, Namespace: namespaceName, Name: name})
+	}
+
+	if len(name) == 0 {
+		list := unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+
+		if err := reader.List(context.Background(), &list, client.InNamespace(namespaceName)); err != nil {
+			return nil, err
+		}
+
+		return list.UnstructuredContent(), nil
+	}
+
+	obj := unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	err = reader.Get(context.Background(), types.NamespacedName{Namespace: namespaceName, Name: name}, &obj)
+
+	if k8sErrors.IsNotFound(err) {
+		return map[string]interface{}{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return obj.Object, nil
+}
+
+// lookupNamespace get namespace by name (current namespace if not informed). Other namespaces are read like lookups
+func (c *Common) lookupNamespace(namespaceName string, names []string) (unstructured.Unstructured, error) {
+	current := namespaceName
+	if len(names) > 0 {
+		namespaceName = names[0]
+	}
+
+	if err := c.CheckPoliciesNamespace(namespaceName); err != nil {
+		return unstructured.Unstructured{}, err
+	}
+
+	reader := c.lookupReader()
+	record := true
+	if namespaceName != current {
+		otherReader, restricted, err := c.lookupClient()
+
+		if err != nil {
+			return unstructured.Unstructured{}, err
+		}
+
+		if restricted {
+			return unstructured.Unstructured{}, checkRestrictedLookup(namespaceGVK, current, namespaceName)
+		}
+		reader, record = otherReader, false
+	}
+
+	if record {
+		c.recorder.record(lookupRef{GVK: namespaceGVK, Name: namespaceName})
+	}
+
+	namespace := unstructured.Unstructured{}
+	namespace.SetGroupVersionKind(namespaceGVK)
+	err := reader.Get(context.Background(), types.NamespacedName{Name: namespaceName}, &namespace)
+
+	return namespace, err
+}
@@ -0,0 +1,246 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"container/list"
+	"context"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// forbiddenReader reader refusing all reads
+type forbiddenReader struct{}
+
+func (forbiddenReader) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	return k8sErrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, key.Name, nil)
+}
+
+func (forbiddenReader) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	return k8sErrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "", nil)
+}
+
+var _ = Describe("Template lookups", func() {
+	var common *Common
+	configMapGVK := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	template := otv1.ObjectTemplate{ObjectMeta: metav1.ObjectMeta{Name: "template"}}
+	params := otv1.ObjectTemplateParams{ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "team-a"}}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(otv1.AddToScheme(scheme)).To(Succeed())
+
+		common = (&Common{
+			Client: fake.NewFakeClientWithScheme(scheme,
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}, Annotations: map[string]string{"owner": "john"}}},
+				&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "team-a"}, Data: map[string]string{"ca.crt": "CERT"}},
+			),
+			Lookups: &LookupTracker{},
+		}).recordingLookups()
+	})
+
+	It("Should render cluster objects", func() {
+		obj, _, err := common.ToObject(otv1.Object{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+			Name:       "test",
+			TemplateBody: `data:
+  team: {{ index (namespaceLabels) "team" }}
+  owner: {{ index (namespaceAnnotations "team-a") "owner" }}
+  ca: {{ index (lookup "v1" "ConfigMap" "team-a" "ca").data "ca.crt" }}
+  missing: "{{ lookup "v1" "ConfigMap" "team-a" "missing" | empty }}"
+  count: "{{ len (lookup "v1" "ConfigMap" "team-a" "").items }}"`,
+		}, nil, map[string]string{}, "team-a")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj.Object["data"]).To(Equal(map[string]interface{}{"team": "a", "owner": "john", "ca": "CERT", "missing": "true", "count": "1"}))
+		Expect(common.recorder.recorded()).To(ConsistOf(
+			lookupRef{GVK: namespaceGVK, Name: "team-a"},
+			lookupRef{GVK: configMapGVK, Namespace: "team-a", Name: "ca"},
+			lookupRef{GVK: configMapGVK, Namespace: "team-a", Name: "missing"},
+			lookupRef{GVK: configMapGVK, Namespace: "team-a"},
+		))
+	})
+
+	It("Should deny lookups not allowed by policies", func() {
+		Expect(common.Client.Create(context.Background(), &otv1.ObjectTemplatePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "restricted"},
+			Spec: otv1.ObjectTemplatePolicySpec{
+				AllowedKinds:      []otv1.AllowedKind{{Group: "", Kind: "ConfigMap"}},
+				AllowedNamespaces: []string{"team-*"},
+			},
+		})).To(Succeed())
+
+		_, err := common.lookup("team-a", "v1", "Secret", "team-a", "token")
+		Expect(isPolicyViolationError(err)).To(BeTrue())
+
+		_, err = common.lookup("team-a", "v1", "ConfigMap", "kube-system", "ca")
+		Expect(isPolicyViolationError(err)).To(BeTrue())
+
+		_, err = common.lookup("team-a", "v1", "ConfigMap", "team-a", "ca")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("Without service account", func() {
+		It("Should only read objects of the current namespace without Secrets", func() {
+			_, err := common.lookup("team-a", "v1", "Secret", "team-a", "token")
+			Expect(err).To(MatchError("lookup of Secrets requires a service account"))
+
+			_, err = common.lookup("team-b", "v1", "ConfigMap", "team-a", "ca")
+			Expect(err).To(MatchError(ContainSubstring("lookup of ConfigMap in namespace team-a requires a service account")))
+
+			_, err = common.lookupNamespace("team-b", []string{"team-a"})
+			Expect(err).To(MatchError(ContainSubstring("lookup of Namespace in namespace team-a requires a service account")))
+			Expect(common.recorder.recorded()).To(BeEmpty())
+		})
+
+		It("Should read any object when lookups are unrestricted", func() {
+			defer SetTemplateOptions(templateOptions)
+			options := templateOptions
+			options.UnrestrictedLookups = true
+			SetTemplateOptions(options)
+
+			obj, err := common.lookup("team-b", "v1", "ConfigMap", "team-a", "ca")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(obj["data"]).To(Equal(map[string]interface{}{"ca.crt": "CERT"}))
+
+			namespace, err := common.lookupNamespace("team-b", []string{"team-a"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(namespace.GetLabels()).To(Equal(map[string]string{"team": "a"}))
+		})
+	})
+
+	Context("With service account", func() {
+		var serviceAccountClient client.Client
+
+		BeforeEach(func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			serviceAccountClient = fake.NewFakeClientWithScheme(scheme,
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shared", Labels: map[string]string{"team": "shared"}}},
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "shared"}, Data: map[string][]byte{"token": []byte("secret")}},
+			)
+
+			common.Impersonator = &Impersonator{}
+			common.Impersonator.clients = map[string]*list.Element{}
+			common.Impersonator.recent = list.New()
+			userName := serviceAccountUserName("team-a", "deployer")
+			common.Impersonator.clients[userName] = common.Impersonator.recent.PushFront(&impersonatedClient{userName: userName, client: serviceAccountClient})
+
+			deployer := *template.DeepCopy()
+			deployer.Spec.ServiceAccountName = "deployer"
+			common.rendering = &renderContext{template: deployer, params: params}
+		})
+
+		It("Should read objects with the service account without watching them", func() {
+			obj, err := common.lookup("team-a", "v1", "Secret", "shared", "token")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(obj["data"]).To(Equal(map[string]interface{}{"token": "c2VjcmV0"}))
+
+			obj, err = common.lookup("team-a", "v1", "ConfigMap", "team-a", "ca")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(obj).To(BeEmpty())
+
+			namespace, err := common.lookupNamespace("team-a", []string{"shared"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(namespace.GetLabels()).To(Equal(map[string]string{"team": "shared"}))
+			Expect(common.recorder.recorded()).To(BeEmpty())
+		})
+
+		It("Should only read objects also allowed to the parameters service account", func() {
+			reader := &restrictedReader{Reader: serviceAccountClient, restriction: forbiddenReader{}, serviceAccountName: "tenant"}
+
+			err := reader.Get(context.Background(), types.NamespacedName{Namespace: "shared", Name: "token"}, &corev1.Secret{})
+			Expect(k8sErrors.IsForbidden(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("parameters service account tenant"))
+
+			err = reader.List(context.Background(), &corev1.SecretList{}, client.InNamespace("shared"))
+			Expect(k8sErrors.IsForbidden(err)).To(BeTrue())
+		})
+	})
+
+	It("Should reconcile parameters when looked up objects change", func() {
+		_, err := common.lookup("team-a", "v1", "ConfigMap", "team-a", "ca")
+		Expect(err).NotTo(HaveOccurred())
+		common.trackLookups(template, params)
+
+		changed := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "team-a"}}
+		changed.SetGroupVersionKind(configMapGVK)
+		other := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "team-a"}}
+		other.SetGroupVersionKind(configMapGVK)
+
+		requests := common.Lookups.dependents(handler.MapObject{Meta: changed, Object: changed})
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].NamespacedName).To(Equal(types.NamespacedName{Namespace: "team-a", Name: "params"}))
+		Expect(common.Lookups.dependents(handler.MapObject{Meta: other, Object: other})).To(BeEmpty())
+	})
+
+	It("Should read looked up objects using the tracker reader", func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		common.Lookups.Reader = fake.NewFakeClientWithScheme(scheme,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "cached"}}},
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "team-a"}, Data: map[string]string{"ca.crt": "CACHED"}},
+		)
+
+		obj, err := common.lookup("team-a", "v1", "ConfigMap", "team-a", "ca")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj["data"]).To(Equal(map[string]interface{}{"ca.crt": "CACHED"}))
+
+		namespace, err := common.lookupNamespace("team-a", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(namespace.GetLabels()).To(Equal(map[string]string{"team": "cached"}))
+	})
+
+	It("Should forget lookups of deleted parameters and templates", func() {
+		changed := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "ca", Namespace: "team-a"}}
+		changed.SetGroupVersionKind(configMapGVK)
+		refs := []lookupRef{{GVK: configMapGVK, Namespace: "team-a", Name: "ca"}}
+		otherParams := otv1.ObjectTemplateParams{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "team-a"}}
+		otherTemplate := otv1.ObjectTemplate{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+
+		Expect(common.Lookups.Track(template, params, refs)).To(Succeed())
+		Expect(common.Lookups.Track(template, otherParams, refs)).To(Succeed())
+		Expect(common.Lookups.Track(otherTemplate, params, refs)).To(Succeed())
+
+		common.Lookups.ForgetParams(types.NamespacedName{Namespace: "team-a", Name: "params"})
+		Expect(common.Lookups.lookups).To(HaveLen(1))
+		Expect(common.Lookups.dependents(handler.MapObject{Meta: changed, Object: changed})).To(ConsistOf(
+			reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "team-a", Name: "other"}},
+		))
+
+		common.Lookups.ForgetTemplate("template")
+		Expect(common.Lookups.lookups).To(BeEmpty())
+
+		var tracker *LookupTracker
+		tracker.ForgetParams(types.NamespacedName{Namespace: "team-a", Name: "params"})
+	})
+})
@@ -0,0 +1,67 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+)
+
+// migrateParametersValues apply template migrations to parameters values, returning new values and the rewrites made
+func migrateParametersValues(migrations []otv1.ParameterMigration, values map[string]string, removeRenamed bool) (map[string]string, []string, error) {
+	migrated := copyMap(values)
+	rewrites := []string{}
+
+	for _, migration := range migrations {
+		if len(migrated[migration.Name]) > 0 {
+			continue
+		}
+
+		if len(migration.From) > 0 && len(migrated[migration.From]) == 0 {
+			continue
+		}
+
+		value := migrated[migration.From]
+		rewrite := fmt.Sprintf("%v -> %v", migration.From, migration.Name)
+
+		if len(migration.Expression) > 0 {
+			var err error
+			if value, err = executeMigrationExpression(migration.Expression, migrated); err != nil {
+				return values, rewrites, fmt.Errorf("Error migrating parameter %v: %w", migration.Name, err)
+			}
+			rewrite = fmt.Sprintf("%v derived from expression", migration.Name)
+		}
+
+		if len(value) == 0 {
+			continue
+		}
+
+		migrated[migration.Name] = value
+		if removeRenamed && len(migration.From) > 0 && len(migration.Expression) == 0 {
+			delete(migrated, migration.From)
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+
+	return migrated, rewrites, nil
+}
+
+// executeMigrationExpression execute expression using parameters values (missing values are empty)
+func executeMigrationExpression(expression string, values map[string]string) (string, error) {
+	return renderTemplate("migration", expression, values, templateOptions, nil, "missingkey=zero")
+}
@@ -0,0 +1,122 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Parameters migrations", func() {
+	migrations := []otv1.ParameterMigration{
+		{Name: "displayName", From: "name"},
+		{Name: "fullName", Expression: "{{ .firstName }} {{ .lastName | upper }}"},
+	}
+
+	Context("With old parameters", func() {
+		It("Should rename and derive values", func() {
+			values, rewrites, err := migrateParametersValues(migrations, map[string]string{"name": "foo", "firstName": "john", "lastName": "doe"}, false)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(values).To(HaveKeyWithValue("displayName", "foo"))
+			Expect(values).To(HaveKeyWithValue("name", "foo"))
+			Expect(values).To(HaveKeyWithValue("fullName", "john DOE"))
+			Expect(rewrites).To(HaveLen(2))
+		})
+
+		It("Should remove renamed parameters when rewriting stored values", func() {
+			values, _, err := migrateParametersValues(migrations[:1], map[string]string{"name": "foo"}, true)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(values).To(Equal(map[string]string{"displayName": "foo"}))
+		})
+	})
+
+	Context("With new parameters", func() {
+		It("Should keep informed values", func() {
+			values, rewrites, err := migrateParametersValues(migrations[:1], map[string]string{"name": "foo", "displayName": "bar"}, true)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(values).To(HaveKeyWithValue("displayName", "bar"))
+			Expect(rewrites).To(BeEmpty())
+		})
+
+		It("Should be used by normalized parameters", func() {
+			common := Common{}
+			params, err := common.normalizeParametersValues(otv1.Object{}, "test", []otv1.Parameter{{Name: "displayName", Default: "default"}}, migrations, map[string]string{"name": "foo"})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(params).To(Equal(map[string]string{"displayName": "foo"}))
+		})
+	})
+
+	Context("With rollout", func() {
+		var reconciler *ParametersMigrationReconciler
+		template := otv1.ObjectTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "template"},
+			Spec: otv1.ObjectTemplateSpec{
+				Migrations: migrations[:1],
+				Rollout:    &otv1.RolloutStrategy{BatchSize: 1},
+			},
+		}
+		params := func(name string, applied int64) *otv1.ObjectTemplateParams {
+			return &otv1.ObjectTemplateParams{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a"},
+				Spec:       otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{{Name: "template", Values: map[string]string{"name": "foo"}}}},
+				Status:     otv1.ObjectTemplateParamsStatus{Revisions: []otv1.AppliedRevision{{Name: "template", Revision: applied}}},
+			}
+		}
+		values := func(name string) map[string]string {
+			otp := otv1.ObjectTemplateParams{}
+			Expect(reconciler.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: name}, &otp)).To(Succeed())
+			return otp.Spec.Templates[0].Values
+		}
+
+		BeforeEach(func() {
+			scheme := runtime.NewScheme()
+			Expect(otv1.AddToScheme(scheme)).To(Succeed())
+
+			hash, err := revisionHash(template.Spec)
+			Expect(err).NotTo(HaveOccurred())
+			revision := &otv1.ObjectTemplateRevision{
+				ObjectMeta: metav1.ObjectMeta{Name: revisionName("template", 2), Labels: map[string]string{otv1.TemplateLabel: "template"}},
+				Spec:       otv1.ObjectTemplateRevisionSpec{TemplateName: "template", Revision: 2, Hash: hash},
+			}
+			reconciler = &ParametersMigrationReconciler{
+				Client: fake.NewFakeClientWithScheme(scheme, template.DeepCopy(), revision, params("updated", 2), params("held", 1)),
+				Log:    ctrl.Log.WithName("test"),
+			}
+		})
+
+		It("Should not migrate parameters held at a previous revision", func() {
+			result, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: "template"}})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).NotTo(BeZero())
+			Expect(values("updated")).To(Equal(map[string]string{"displayName": "foo"}))
+			Expect(values("held")).To(Equal(map[string]string{"name": "foo"}))
+		})
+	})
+})
@@ -0,0 +1,87 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// isNamespaceAllowedByTemplate namespace allowed to use template
+func (c *Common) isNamespaceAllowedByTemplate(ot otv1.ObjectTemplate, namespaceName string) (bool, error) {
+	allowed := ot.Spec.AllowedNamespaces
+
+	if allowed == nil || (len(allowed.Names) == 0 && allowed.Selector == nil) {
+		return true, nil
+	}
+
+	if len(allowed.Names) > 0 && isNamespaceAllowed(allowed.Names, namespaceName) {
+		return true, nil
+	}
+
+	if allowed.Selector == nil {
+		return false, nil
+	}
+
+	namespace := corev1.Namespace{}
+	if err := c.Client.Get(context.Background(), types.NamespacedName{Name: namespaceName}, &namespace); err != nil {
+		return false, err
+	}
+
+	return matchesNamespaceSelector(allowed.Selector, namespace.Labels)
+}
+
+// matchesNamespaceSelector namespace labels match selector
+func matchesNamespaceSelector(selector *metav1.LabelSelector, namespaceLabels map[string]string) (bool, error) {
+	namespaceSelector, err := metav1.LabelSelectorAsSelector(selector)
+
+	if err != nil {
+		return false, err
+	}
+
+	return namespaceSelector.Matches(labels.Set(namespaceLabels)), nil
+}
+
+// namespaceNotAllowedMessage message of parameters refused by template
+func namespaceNotAllowedMessage(templateName string, namespaceName string) string {
+	return fmt.Sprintf("%v: namespace %v is not allowed to use this template", templateName, namespaceName)
+}
+
+// setNamespaceAllowedCondition set namespace allowed condition using templates that refused parameters
+func setNamespaceAllowedCondition(conditions *[]metav1.Condition, generation int64, refused []string) {
+	condition := metav1.Condition{
+		Type:               otv1.ConditionNamespaceAllowed,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Allowed",
+		ObservedGeneration: generation,
+	}
+
+	if len(refused) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NamespaceNotAllowed"
+		condition.Message = strings.Join(refused, "\n")
+	}
+
+	setStatusCondition(conditions, condition)
+}
@@ -0,0 +1,74 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Template allowed namespaces", func() {
+	template := func(allowed *otv1.AllowedNamespaces) otv1.ObjectTemplate {
+		return otv1.ObjectTemplate{Spec: otv1.ObjectTemplateSpec{AllowedNamespaces: allowed}}
+	}
+	common := Common{Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "gateway", Labels: map[string]string{"platform": "true"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+	)}
+
+	It("Should allow all namespaces if not set", func() {
+		allowed, err := common.isNamespaceAllowedByTemplate(template(nil), "team-a")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(allowed).To(BeTrue())
+	})
+
+	It("Should allow namespaces by name globs", func() {
+		ot := template(&otv1.AllowedNamespaces{Names: []string{"platform-*"}})
+
+		Expect(common.isNamespaceAllowedByTemplate(ot, "platform-ingress")).To(BeTrue())
+		Expect(common.isNamespaceAllowedByTemplate(ot, "team-a")).To(BeFalse())
+	})
+
+	It("Should allow namespaces by label selector", func() {
+		ot := template(&otv1.AllowedNamespaces{
+			Names:    []string{"platform-*"},
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"platform": "true"}},
+		})
+
+		Expect(common.isNamespaceAllowedByTemplate(ot, "gateway")).To(BeTrue())
+		Expect(common.isNamespaceAllowedByTemplate(ot, "team-a")).To(BeFalse())
+	})
+
+	It("Should report refused templates in condition", func() {
+		conditions := []metav1.Condition{}
+		setNamespaceAllowedCondition(&conditions, 1, []string{namespaceNotAllowedMessage("gateway", "team-a")})
+
+		condition := meta.FindStatusCondition(conditions, otv1.ConditionNamespaceAllowed)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Message).To(ContainSubstring("team-a"))
+
+		setNamespaceAllowedCondition(&conditions, 2, nil)
+		Expect(meta.IsStatusConditionTrue(conditions, otv1.ConditionNamespaceAllowed)).To(BeTrue())
+	})
+})
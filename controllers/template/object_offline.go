@@ -0,0 +1,168 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// clusterScopedKinds kinds known to be cluster scoped when there is no cluster to discover them
+var clusterScopedKinds = map[schema.GroupKind]bool{
+	{Group: "", Kind: "Namespace"}:        true,
+	{Group: "", Kind: "Node"}:             true,
+	{Group: "", Kind: "PersistentVolume"}: true,
+	{Group: "", Kind: "ComponentStatus"}:  true,
+	{Group: "admissionregistration.k8s.io", Kind: "ValidatingWebhookConfiguration"}: true,
+	{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration"}:   true,
+	{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}:               true,
+	{Group: "apiregistration.k8s.io", Kind: "APIService"}:                           true,
+	{Group: "certificates.k8s.io", Kind: "CertificateSigningRequest"}:               true,
+	{Group: "flowcontrol.apiserver.k8s.io", Kind: "FlowSchema"}:                     true,
+	{Group: "flowcontrol.apiserver.k8s.io", Kind: "PriorityLevelConfiguration"}:     true,
+	{Group: "networking.k8s.io", Kind: "IngressClass"}:                              true,
+	{Group: "node.k8s.io", Kind: "RuntimeClass"}:                                    true,
+	{Group: "policy", Kind: "PodSecurityPolicy"}:                                    true,
+	{Group: "extensions", Kind: "PodSecurityPolicy"}:                                true,
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"}:                       true,
+	{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}:                true,
+	{Group: "scheduling.k8s.io", Kind: "PriorityClass"}:                             true,
+	{Group: "storage.k8s.io", Kind: "StorageClass"}:                                 true,
+	{Group: "storage.k8s.io", Kind: "VolumeAttachment"}:                             true,
+	{Group: "storage.k8s.io", Kind: "CSIDriver"}:                                    true,
+	{Group: "storage.k8s.io", Kind: "CSINode"}:                                      true,
+	{Group: otv1.GroupVersion.Group, Kind: templateKind}:                            true,
+	{Group: otv1.GroupVersion.Group, Kind: revisionKind}:                            true,
+	{Group: otv1.GroupVersion.Group, Kind: "ObjectTemplatePolicy"}:                  true,
+	{Group: otv1.GroupVersion.Group, Kind: "TemplateSource"}:                        true,
+}
+
+// offlineRESTMapper mapper of kinds registered in scheme that maps unknown kinds (e.g. custom resources) to a namespace
+// scope, so templates render the same way they are applied without a cluster
+type offlineRESTMapper struct {
+	meta.RESTMapper
+}
+
+// NewOfflineRESTMapper mapper used to render templates without a cluster (only known kinds are cluster scoped)
+func NewOfflineRESTMapper(scheme *runtime.Scheme) meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(scheme.PreferredVersionAllGroups())
+
+	for gvk := range scheme.AllKnownTypes() {
+		scope := meta.RESTScopeNamespace
+		if clusterScopedKinds[gvk.GroupKind()] {
+			scope = meta.RESTScopeRoot
+		}
+		mapper.Add(gvk, scope)
+	}
+
+	return offlineRESTMapper{RESTMapper: mapper}
+}
+
+// RESTMapping mapping of a kind (unknown kinds are namespaced unless they are known to be cluster scoped)
+func (m offlineRESTMapper) RESTMapping(gk schema.GroupKind, versions ...string) (*meta.RESTMapping, error) {
+	mapping, err := m.RESTMapper.RESTMapping(gk, versions...)
+
+	if err == nil || !meta.IsNoMatchError(err) {
+		return mapping, err
+	}
+
+	version := ""
+	if len(versions) > 0 {
+		version = versions[0]
+	}
+
+	scope := meta.RESTScopeNamespace
+	if clusterScopedKinds[gk] {
+		scope = meta.RESTScopeRoot
+	}
+
+	return &meta.RESTMapping{GroupVersionKind: gk.WithVersion(version), Scope: scope}, nil
+}
+
+// RenderedObject object of a template rendered without applying it
+type RenderedObject struct {
+	// Source template object (chart objects have a base with the rendered manifest)
+	Source otv1.Object
+	Object unstructured.Unstructured
+	// Err error rendering or validating the object
+	Err error
+}
+
+// RenderObjectsByTemplate render objects from template using parameters values with the same pipeline used to apply them (owner references are not set)
+func (c *Common) RenderObjectsByTemplate(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) ([]RenderedObject, error) {
+	parameters, err := otp.Spec.GetParametersByTemplateName(ot.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ot, err = c.expandChart(ot, otp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := sortObjectsByDependencies(ot.Spec.Objects)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := c.renderingFor(ot, otp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := []RenderedObject{}
+	for _, obj := range objects {
+		rendered = append(rendered, rc.renderObject(ot, otp, obj, parameters.Values))
+	}
+
+	return rendered, nil
+}
+
+func (c *Common) renderObject(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams, obj otv1.Object, paramsValues map[string]string) RenderedObject {
+	rendered := RenderedObject{Source: obj}
+	values, err := c.normalizeParametersValues(obj, otp.Namespace, ot.Spec.Parameters, ot.Spec.Migrations, paramsValues)
+
+	if err != nil {
+		rendered.Err = err
+		return rendered
+	}
+
+	namespaced, err := c.isNamespaced(schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind))
+
+	if err != nil {
+		rendered.Err = err
+		return rendered
+	}
+
+	if rendered.Object, _, rendered.Err = c.ToObject(obj, nil, values, otp.Namespace); rendered.Err != nil {
+		return rendered
+	}
+	if !namespaced {
+		rendered.Object.SetNamespace("")
+	}
+	setManagedMarkers(&rendered.Object, ot, otp)
+	rendered.Err = c.CheckPolicies(rendered.Object)
+
+	return rendered
+}
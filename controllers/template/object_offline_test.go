@@ -0,0 +1,114 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Offline rendering", func() {
+	template := otv1.ObjectTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec: otv1.ObjectTemplateSpec{
+			Parameters: []otv1.Parameter{{Name: "name", Default: "{{ .__namespace }}-web"}, {Name: "port", Default: "80"}},
+			Objects: []otv1.Object{
+				{Kind: "Service", APIVersion: "v1", Name: "web", DependsOn: []string{"web-config"}, TemplateBody: "spec:\n  ports:\n  - port: {{ .port }}\n"},
+				{Kind: "ConfigMap", APIVersion: "v1", Name: "web-config", TemplateBody: "data:\n  name: {{ .name }}\n  broken: {{ .name | nope }}\n"},
+			},
+		},
+	}
+	params := otv1.ObjectTemplateParams{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "team-a"},
+		Spec: otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{
+			{Name: "web", Values: map[string]string{"port": "8080"}},
+		}},
+	}
+	var common Common
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(otv1.AddToScheme(scheme)).To(Succeed())
+
+		common = Common{Client: fake.NewFakeClientWithScheme(scheme,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		)}
+	})
+
+	It("Should render objects in dependency order without applying them", func() {
+		rendered, err := common.RenderObjectsByTemplate(template, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rendered).To(HaveLen(2))
+
+		config, service := rendered[0], rendered[1]
+		Expect(config.Source.Name).To(Equal("web-config"))
+		Expect(config.Err).To(MatchError(ContainSubstring(`template:3: function "nope" not defined`)))
+
+		Expect(service.Err).NotTo(HaveOccurred())
+		Expect(service.Object.GetNamespace()).To(Equal("team-a"))
+		Expect(service.Object.GetLabels()).To(HaveKeyWithValue(otv1.ManagedByLabel, otv1.ManagedByValue))
+		Expect(service.Object.GetOwnerReferences()).To(BeEmpty())
+		Expect(service.Object.Object["spec"]).To(Equal(map[string]interface{}{"ports": []interface{}{map[string]interface{}{"port": int64(8080)}}}))
+	})
+
+	It("Should report the parameter that failed to render", func() {
+		invalid := *params.DeepCopy()
+		invalid.Spec.Templates[0].Values["port"] = "{{ .port"
+
+		rendered, err := common.RenderObjectsByTemplate(template, invalid)
+		Expect(err).NotTo(HaveOccurred())
+
+		var parameterError *ParameterError
+		Expect(errors.As(rendered[0].Err, &parameterError)).To(BeTrue())
+		Expect(parameterError.Name).To(Equal("port"))
+	})
+
+	It("Should render cluster scoped objects without namespace", func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		common.Mapper = NewOfflineRESTMapper(scheme)
+		roles := otv1.ObjectTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "web"},
+			Spec: otv1.ObjectTemplateSpec{Objects: []otv1.Object{
+				{Kind: "ClusterRole", APIVersion: "rbac.authorization.k8s.io/v1", Name: "web-reader", TemplateBody: "rules: []"},
+				{Kind: "Role", APIVersion: "rbac.authorization.k8s.io/v1", Name: "web-reader", TemplateBody: "rules: []"},
+				{Kind: "Widget", APIVersion: "example.com/v1", Name: "web", TemplateBody: "spec: {}"},
+				{Kind: "CustomResourceDefinition", APIVersion: "apiextensions.k8s.io/v1", Name: "widgets.example.com", TemplateBody: "spec: {}"},
+			}},
+		}
+
+		rendered, err := common.RenderObjectsByTemplate(roles, params)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rendered).To(HaveLen(4))
+
+		namespaces := map[string]string{}
+		for _, obj := range rendered {
+			Expect(obj.Err).NotTo(HaveOccurred())
+			namespaces[obj.Object.GetKind()] = obj.Object.GetNamespace()
+		}
+		Expect(namespaces).To(Equal(map[string]string{"ClusterRole": "", "Role": "team-a", "Widget": "team-a", "CustomResourceDefinition": ""}))
+	})
+})
@@ -0,0 +1,222 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var (
+	paramsKind = reflect.TypeOf(otv1.ObjectTemplateParams{}).Name()
+)
+
+// getOwnerReferences controller reference to parameters
+func getOwnerReferences(otp otv1.ObjectTemplateParams) []metav1.OwnerReference {
+	gvk := otv1.GroupVersion.WithKind(paramsKind)
+	controllerRef := metav1.NewControllerRef(otp.GetObjectMeta(), gvk)
+
+	return []metav1.OwnerReference{*controllerRef}
+}
+
+// ownerReferencesFor owner references of a generated object (cluster scoped objects can't be owned by namespaced parameters and are cleaned up by finalizers)
+func ownerReferencesFor(otp otv1.ObjectTemplateParams, namespaced bool) []metav1.OwnerReference {
+	if !namespaced {
+		return nil
+	}
+
+	return getOwnerReferences(otp)
+}
+
+// isNamespaced kind is namespace scoped (every kind is namespaced without a mapper)
+func (c *Common) isNamespaced(gvk schema.GroupVersionKind) (bool, error) {
+	if c.Mapper == nil {
+		return true, nil
+	}
+
+	mapping, err := c.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+
+	if err != nil {
+		return false, err
+	}
+
+	return mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+}
+
+// setManagedMarkers add ownership labels and annotations to object
+func setManagedMarkers(obj *unstructured.Unstructured, ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) {
+	labels := copyMap(obj.GetLabels())
+	labels[otv1.ManagedByLabel] = otv1.ManagedByValue
+	obj.SetLabels(labels)
+
+	annotations := copyMap(obj.GetAnnotations())
+	annotations[otv1.TemplateAnnotation] = ot.Name
+	annotations[otv1.ParamsAnnotation] = otp.Name
+	annotations[otv1.ParamsNamespaceAnnotation] = otp.Namespace
+	obj.SetAnnotations(annotations)
+}
+
+// removeManagedMarkers remove ownership labels and annotations from object
+func removeManagedMarkers(obj *unstructured.Unstructured) {
+	labels := obj.GetLabels()
+	delete(labels, otv1.ManagedByLabel)
+	obj.SetLabels(labels)
+
+	annotations := obj.GetAnnotations()
+	delete(annotations, otv1.TemplateAnnotation)
+	delete(annotations, otv1.ParamsAnnotation)
+	delete(annotations, otv1.ParamsNamespaceAnnotation)
+	obj.SetAnnotations(annotations)
+}
+
+// isGeneratedBy object generated by template using parameters (by owner reference or by managed markers)
+func isGeneratedBy(obj unstructured.Unstructured, ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) bool {
+	if isOwnedBy(obj, otp.UID) {
+		return true
+	}
+
+	annotations := obj.GetAnnotations()
+
+	return obj.GetLabels()[otv1.ManagedByLabel] == otv1.ManagedByValue &&
+		annotations[otv1.TemplateAnnotation] == ot.Name &&
+		annotations[otv1.ParamsAnnotation] == otp.Name &&
+		annotations[otv1.ParamsNamespaceAnnotation] == otp.Namespace
+}
+
+// paramsOwnerOf parameters controlling an object (by controller reference or, for cluster scoped objects, by managed markers)
+func paramsOwnerOf(obj unstructured.Unstructured, namespaceName string) (types.NamespacedName, types.UID, bool) {
+	if ref := metav1.GetControllerOf(&obj); ref != nil {
+		if ref.APIVersion != otGV || ref.Kind != paramsKind {
+			return types.NamespacedName{}, "", false
+		}
+
+		return types.NamespacedName{Namespace: namespaceName, Name: ref.Name}, ref.UID, true
+	}
+
+	annotations := obj.GetAnnotations()
+	if obj.GetLabels()[otv1.ManagedByLabel] != otv1.ManagedByValue || len(annotations[otv1.ParamsNamespaceAnnotation]) == 0 {
+		return types.NamespacedName{}, "", false
+	}
+
+	return types.NamespacedName{Namespace: annotations[otv1.ParamsNamespaceAnnotation], Name: annotations[otv1.ParamsAnnotation]}, "", true
+}
+
+// isManaged object created by this operator (by markers or by owner reference to parameters)
+func isManaged(obj unstructured.Unstructured) bool {
+	if obj.GetLabels()[otv1.ManagedByLabel] == otv1.ManagedByValue {
+		return true
+	}
+
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.APIVersion == otGV && ref.Kind == paramsKind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkAdoption validate if an existing object can be managed using template adoption policy
+func checkAdoption(ot otv1.ObjectTemplate, current unstructured.Unstructured) error {
+	if len(current.GetResourceVersion()) == 0 || isManaged(current) {
+		return nil
+	}
+
+	switch ot.Spec.AdoptionPolicy {
+	case otv1.AdoptionPolicyNever:
+		return fmt.Errorf("object already exists and is not managed by this operator (adoptionPolicy %v)", ot.Spec.AdoptionPolicy)
+	case otv1.AdoptionPolicyIfLabeled:
+		if current.GetAnnotations()[otv1.AdoptAnnotation] != "true" {
+			return fmt.Errorf("object already exists and is not managed by this operator, annotate it with %v=true to allow adoption (adoptionPolicy %v)", otv1.AdoptAnnotation, ot.Spec.AdoptionPolicy)
+		}
+	}
+
+	return nil
+}
+
+// ConflictError object is owned by other parameters with same or higher priority
+type ConflictError struct {
+	Owner string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("object is owned by parameters %v", e.Owner)
+}
+
+// isConflictError error caused by an object owned by other parameters
+func isConflictError(err error) bool {
+	var conflict *ConflictError
+	return errors.As(err, &conflict)
+}
+
+// checkConflict validate if an object controlled by other parameters can be taken over
+func (c *Common) checkConflict(otp otv1.ObjectTemplateParams, current unstructured.Unstructured) error {
+	ownerKey, ownerUID, found := paramsOwnerOf(current, otp.Namespace)
+
+	if !found || ownerUID == otp.UID || ownerKey == (types.NamespacedName{Namespace: otp.Namespace, Name: otp.Name}) {
+		return nil
+	}
+
+	owner := otv1.ObjectTemplateParams{}
+	err := c.Client.Get(context.Background(), ownerKey, &owner)
+
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// stale reference or owner being deleted
+	if (len(ownerUID) > 0 && owner.UID != ownerUID) || !owner.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	if otp.Spec.Priority > owner.Spec.Priority {
+		return nil
+	}
+
+	return &ConflictError{Owner: owner.Name}
+}
+
+// setConflictCondition set conflict condition using conflict messages
+func setConflictCondition(conditions *[]metav1.Condition, generation int64, conflicts []string) {
+	condition := metav1.Condition{
+		Type:               otv1.ConditionConflict,
+		Status:             metav1.ConditionFalse,
+		Reason:             "NoConflict",
+		ObservedGeneration: generation,
+	}
+
+	if len(conflicts) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "OwnedByOtherParams"
+		condition.Message = strings.Join(conflicts, "\n")
+	}
+
+	setStatusCondition(conditions, condition)
+}
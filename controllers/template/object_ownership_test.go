@@ -0,0 +1,199 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Object ownership", func() {
+	Describe("Adoption policy", func() {
+		existing := func(annotations map[string]string) unstructured.Unstructured {
+			obj := unstructured.Unstructured{}
+			obj.SetResourceVersion("1")
+			obj.SetAnnotations(annotations)
+			return obj
+		}
+		template := func(policy otv1.AdoptionPolicy) otv1.ObjectTemplate {
+			return otv1.ObjectTemplate{Spec: otv1.ObjectTemplateSpec{AdoptionPolicy: policy}}
+		}
+
+		Context("With an unmanaged object", func() {
+			It("Should be adopted by default", func() {
+				Expect(checkAdoption(template(""), existing(nil))).To(Succeed())
+			})
+			It("Should fail with Never", func() {
+				Expect(checkAdoption(template(otv1.AdoptionPolicyNever), existing(nil))).NotTo(Succeed())
+			})
+			It("Should be adopted with IfLabeled only if annotated", func() {
+				Expect(checkAdoption(template(otv1.AdoptionPolicyIfLabeled), existing(nil))).NotTo(Succeed())
+				Expect(checkAdoption(template(otv1.AdoptionPolicyIfLabeled), existing(map[string]string{otv1.AdoptAnnotation: "true"}))).To(Succeed())
+			})
+		})
+
+		Context("With a conflict", func() {
+			It("Should be detected when wrapped", func() {
+				err := fmt.Errorf("Error updating object: %w", &ConflictError{Owner: "other"})
+				Expect(isConflictError(err)).To(BeTrue())
+				Expect(isConflictError(fmt.Errorf("other error"))).To(BeFalse())
+			})
+			It("Should be reported as a condition", func() {
+				conditions := []metav1.Condition{}
+				setConflictCondition(&conditions, 1, []string{"conflict"})
+				Expect(meta.IsStatusConditionTrue(conditions, otv1.ConditionConflict)).To(BeTrue())
+				setConflictCondition(&conditions, 2, []string{})
+				Expect(meta.IsStatusConditionFalse(conditions, otv1.ConditionConflict)).To(BeTrue())
+			})
+		})
+
+		Context("With a managed object", func() {
+			It("Should be updated with Never", func() {
+				obj := existing(nil)
+				setManagedMarkers(&obj, template(otv1.AdoptionPolicyNever), otv1.ObjectTemplateParams{})
+				Expect(checkAdoption(template(otv1.AdoptionPolicyNever), obj)).To(Succeed())
+			})
+		})
+	})
+
+	Describe("Priority takeover", func() {
+		var scheme *runtime.Scheme
+		otp := otv1.ObjectTemplateParams{
+			ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "team-a", UID: "params-uid"},
+			Spec:       otv1.ObjectTemplateParamsSpec{Priority: 5, Templates: []otv1.Parameters{{Name: "settings"}}},
+		}
+		ot := otv1.ObjectTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "settings"},
+			Spec: otv1.ObjectTemplateSpec{Objects: []otv1.Object{
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "settings", TemplateBody: "data:\n  value: new"},
+			}},
+		}
+		owner := func(priority int32) *otv1.ObjectTemplateParams {
+			return &otv1.ObjectTemplateParams{
+				ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "team-a", UID: "owner-uid"},
+				Spec:       otv1.ObjectTemplateParamsSpec{Priority: priority},
+			}
+		}
+		owned := func(ownerUID types.UID) unstructured.Unstructured {
+			obj := unstructured.Unstructured{}
+			obj.SetAPIVersion("v1")
+			obj.SetKind("ConfigMap")
+			obj.SetName("settings")
+			obj.SetNamespace("team-a")
+			obj.SetResourceVersion("1")
+			obj.SetOwnerReferences(getOwnerReferences(otv1.ObjectTemplateParams{ObjectMeta: metav1.ObjectMeta{Name: "owner", UID: ownerUID}}))
+			setManagedMarkers(&obj, ot, otv1.ObjectTemplateParams{ObjectMeta: metav1.ObjectMeta{Name: "owner", Namespace: "team-a"}})
+			return obj
+		}
+		common := func(objs ...runtime.Object) Common {
+			return Common{Client: fake.NewFakeClientWithScheme(scheme, objs...), Log: ctrl.Log.WithName("test")}
+		}
+
+		BeforeEach(func() {
+			scheme = runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(otv1.AddToScheme(scheme)).To(Succeed())
+		})
+
+		Context("With an object owned by other parameters", func() {
+			It("Should conflict with equal priority", func() {
+				c := common(owner(5))
+				err := c.checkConflict(otp, owned("owner-uid"))
+				Expect(isConflictError(err)).To(BeTrue())
+				Expect(err).To(MatchError("object is owned by parameters owner"))
+			})
+
+			It("Should conflict with higher owner priority", func() {
+				c := common(owner(10))
+				Expect(isConflictError(c.checkConflict(otp, owned("owner-uid")))).To(BeTrue())
+			})
+
+			It("Should be taken over with lower owner priority", func() {
+				c := common(owner(1))
+				Expect(c.checkConflict(otp, owned("owner-uid"))).To(Succeed())
+			})
+
+			It("Should be taken over from a stale owner", func() {
+				c := common(owner(10))
+				Expect(c.checkConflict(otp, owned("previous-owner-uid"))).To(Succeed())
+			})
+
+			It("Should be taken over from an owner being deleted", func() {
+				deleting := owner(10)
+				now := metav1.Now()
+				deleting.DeletionTimestamp = &now
+				deleting.Finalizers = []string{otv1.Finalizer}
+				c := common(deleting)
+				Expect(c.checkConflict(otp, owned("owner-uid"))).To(Succeed())
+			})
+
+			It("Should be taken over from an owner not found", func() {
+				c := common()
+				Expect(c.checkConflict(otp, owned("owner-uid"))).To(Succeed())
+			})
+		})
+
+		Context("With objects applied by template", func() {
+			existing := func(priority int32) Common {
+				obj := owned("owner-uid")
+				obj.SetResourceVersion("")
+				obj.Object["data"] = map[string]interface{}{"value": "old"}
+				return common(owner(priority), otp.DeepCopy(), &obj)
+			}
+			get := func(c Common) unstructured.Unstructured {
+				obj := unstructured.Unstructured{}
+				obj.SetAPIVersion("v1")
+				obj.SetKind("ConfigMap")
+				Expect(c.Client.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "settings"}, &obj)).To(Succeed())
+				return obj
+			}
+
+			It("Should replace the controller reference of lower priority owners", func() {
+				c := existing(1)
+				Expect(c.UpdateSingleObjectByTemplate(ot, otp, ot.Spec.Objects[0], map[string]string{})).To(Succeed())
+
+				obj := get(c)
+				Expect(metav1.GetControllerOf(&obj).UID).To(Equal(otp.UID))
+				Expect(obj.GetOwnerReferences()).To(HaveLen(1))
+				Expect(obj.GetAnnotations()[otv1.ParamsAnnotation]).To(Equal("params"))
+				Expect(obj.Object["data"]).To(Equal(map[string]interface{}{"value": "new"}))
+			})
+
+			It("Should keep objects of owners with equal priority", func() {
+				c := existing(5)
+				err := c.UpdateSingleObjectByTemplate(ot, otp, ot.Spec.Objects[0], map[string]string{})
+				Expect(isConflictError(err)).To(BeTrue())
+
+				obj := get(c)
+				Expect(metav1.GetControllerOf(&obj).UID).To(Equal(types.UID("owner-uid")))
+				Expect(obj.Object["data"]).To(Equal(map[string]interface{}{"value": "old"}))
+			})
+		})
+	})
+})
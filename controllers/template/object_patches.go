@@ -0,0 +1,145 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	jsonpatch "github.com/evanphx/json-patch"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+// patchRenderer static base object with templated patches
+type patchRenderer struct {
+	funcs template.FuncMap
+}
+
+func (r *patchRenderer) Render(obj otv1.Object, values map[string]interface{}) (map[string]interface{}, error) {
+	document, err := yaml.YAMLToJSON(obj.Base.Raw)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding base: %w", err)
+	}
+
+	for i, patch := range obj.Patches {
+		rendered, err := renderTemplate(fmt.Sprintf("patch-%v", i), patch.Patch, values, templateOptions, r.funcs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if document, err = applyPatch(obj, patch.Type, document, []byte(rendered)); err != nil {
+			return nil, fmt.Errorf("Error applying patch %v: %w", i, err)
+		}
+	}
+
+	if templateOptions.MaxOutputSize > 0 && len(document) > templateOptions.MaxOutputSize {
+		return nil, fmt.Errorf("%w (limit %v bytes)", errTemplateOutputSize, templateOptions.MaxOutputSize)
+	}
+
+	return decodeJSONObject(string(document))
+}
+
+// applyPatch apply a rendered patch (YAML or JSON) to a JSON document
+func applyPatch(obj otv1.Object, patchType otv1.PatchType, document []byte, rendered []byte) ([]byte, error) {
+	patch, err := yaml.YAMLToJSON(rendered)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if patchType == otv1.PatchTypeJSON6902 {
+		operations, err := jsonpatch.DecodePatch(patch)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return operations.Apply(document)
+	}
+
+	dataStruct, err := scheme.Scheme.New(schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind))
+
+	if err != nil {
+		// kinds unknown to the operator have no patch strategy
+		return jsonpatch.MergePatch(document, patch)
+	}
+
+	return strategicpatch.StrategicMergePatch(document, patch, dataStruct)
+}
+
+// validateObjectSource object has a templateBody or a base with patches (templates stored without the webhook are
+// checked again before rendering)
+func validateObjectSource(obj otv1.Object) error {
+	reference := fmt.Sprintf("[%v(%v)]", obj.Kind, obj.Name)
+
+	if obj.Base == nil {
+		if len(obj.Patches) > 0 {
+			return fmt.Errorf("%v patches require a base", reference)
+		}
+		return nil
+	}
+
+	if len(obj.TemplateBody) > 0 {
+		return fmt.Errorf("%v templateBody and base can't be used together", reference)
+	}
+
+	return nil
+}
+
+// validateObjectBases validate object bases using the schema of kinds known to the operator
+func validateObjectBases(ot otv1.ObjectTemplate) error {
+	for _, obj := range ot.Spec.Objects {
+		reference := fmt.Sprintf("[%v(%v)]", obj.Kind, obj.Name)
+
+		if err := validateObjectSource(obj); err != nil {
+			return err
+		}
+
+		if obj.Base == nil {
+			continue
+		}
+
+		typed, err := scheme.Scheme.New(schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind))
+
+		if err != nil {
+			continue
+		}
+
+		document, err := yaml.YAMLToJSON(obj.Base.Raw)
+
+		if err != nil {
+			return fmt.Errorf("%v invalid base: %w", reference, err)
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(document))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(typed); err != nil {
+			return fmt.Errorf("%v invalid base: %w", reference, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Object patches", func() {
+	common := Common{}
+	deployment := func(patches ...otv1.ObjectPatch) otv1.Object {
+		return otv1.Object{
+			Kind:       "Deployment",
+			APIVersion: "apps/v1",
+			Name:       "web",
+			Base: &runtime.RawExtension{Raw: []byte(`{"spec":{"replicas":1,"template":{"spec":{"containers":[
+				{"name":"web","image":"nginx:1.19","env":[{"name":"MODE","value":"base"}]},
+				{"name":"sidecar","image":"envoy"}]}}}}`)},
+			Patches: patches,
+		}
+	}
+
+	It("Should apply strategic merge patches using patch keys", func() {
+		obj, _, err := common.ToObject(deployment(otv1.ObjectPatch{Patch: `
+spec:
+  replicas: {{ .replicas }}
+  template:
+    spec:
+      containers:
+      - name: web
+        image: nginx:{{ .version }}`}), nil, map[string]string{"replicas": "3", "version": "1.21"}, "ns")
+
+		Expect(err).NotTo(HaveOccurred())
+		replicas, _, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+		Expect(replicas).To(Equal(int64(3)))
+
+		containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+		Expect(containers).To(HaveLen(2))
+		Expect(containers[0]).To(HaveKeyWithValue("image", "nginx:1.21"))
+		Expect(containers[0]).To(HaveKey("env"))
+		Expect(obj.GetKind()).To(Equal("Deployment"))
+	})
+
+	It("Should apply json6902 patches", func() {
+		obj, _, err := common.ToObject(deployment(otv1.ObjectPatch{Type: otv1.PatchTypeJSON6902, Patch: `
+- op: replace
+  path: /spec/template/spec/containers/0/env/0/value
+  value: {{ .mode }}
+- op: remove
+  path: /spec/template/spec/containers/1`}), nil, map[string]string{"mode": "production"}, "ns")
+
+		Expect(err).NotTo(HaveOccurred())
+
+		containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+		Expect(containers).To(HaveLen(1))
+		Expect(containers[0]).To(HaveKeyWithValue("env", []interface{}{map[string]interface{}{"name": "MODE", "value": "production"}}))
+	})
+
+	It("Should merge patches of unknown kinds", func() {
+		obj, _, err := common.ToObject(otv1.Object{
+			Kind:       "Widget",
+			APIVersion: "example.com/v1",
+			Name:       "widget",
+			Base:       &runtime.RawExtension{Raw: []byte(`{"spec":{"size":1,"color":"red"}}`)},
+			Patches:    []otv1.ObjectPatch{{Patch: `{"spec":{"size":{{ .size }}}}`}},
+		}, nil, map[string]string{"size": "2"}, "ns")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj.Object["spec"]).To(Equal(map[string]interface{}{"size": int64(2), "color": "red"}))
+	})
+
+	It("Should validate bases of known kinds", func() {
+		template := func(obj otv1.Object) otv1.ObjectTemplate {
+			return otv1.ObjectTemplate{Spec: otv1.ObjectTemplateSpec{Objects: []otv1.Object{obj}}}
+		}
+
+		Expect(validateObjectBases(template(deployment()))).To(Succeed())
+
+		invalid := deployment()
+		invalid.Base = &runtime.RawExtension{Raw: []byte(`{"spec":{"replica":1}}`)}
+		Expect(validateObjectBases(template(invalid))).NotTo(Succeed())
+
+		invalid.Base = &runtime.RawExtension{Raw: []byte(`{"spec":{"replicas":"one"}}`)}
+		Expect(validateObjectBases(template(invalid))).NotTo(Succeed())
+
+		Expect(validateObjectBases(template(otv1.Object{Kind: "ConfigMap", APIVersion: "v1", Patches: []otv1.ObjectPatch{{Patch: "{}"}}}))).NotTo(Succeed())
+
+		both := deployment()
+		both.TemplateBody = "spec: {}"
+		Expect(validateObjectBases(template(both))).To(MatchError(ContainSubstring("templateBody and base can't be used together")))
+	})
+
+	It("Should refuse to render objects with templateBody and base", func() {
+		both := deployment()
+		both.TemplateBody = "spec: {}"
+
+		_, _, err := common.ToObject(both, nil, map[string]string{}, "ns")
+		Expect(err).To(MatchError("[Deployment(web)] templateBody and base can't be used together"))
+
+		ot := otv1.ObjectTemplate{ObjectMeta: metav1.ObjectMeta{Name: "web"}, Spec: otv1.ObjectTemplateSpec{Objects: []otv1.Object{both}}}
+		otp := otv1.ObjectTemplateParams{Spec: otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{{Name: "web"}}}}
+		scheme := runtime.NewScheme()
+		Expect(otv1.AddToScheme(scheme)).To(Succeed())
+		withClient := Common{Client: fake.NewFakeClientWithScheme(scheme)}
+		_, _, err = withClient.TemplateByParams(ot, otp)
+		Expect(err).To(MatchError(ContainSubstring("templateBody and base can't be used together")))
+	})
+})
@@ -0,0 +1,271 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// +kubebuilder:rbac:groups=template.k8s.ericogr.com.br,resources=objecttemplatepolicies,verbs=get;list;watch
+
+// PolicyViolationError object not allowed by a template policy
+type PolicyViolationError struct {
+	Policy  string
+	Message string
+}
+
+func (e *PolicyViolationError) Error() string {
+	return fmt.Sprintf("denied by policy %v: %v", e.Policy, e.Message)
+}
+
+// isPolicyViolationError error caused by a template policy
+func isPolicyViolationError(err error) bool {
+	var violation *PolicyViolationError
+	return errors.As(err, &violation)
+}
+
+// FindObjectTemplatePolicies find all template policies
+func (c *Common) FindObjectTemplatePolicies() ([]otv1.ObjectTemplatePolicy, error) {
+	policyList := &otv1.ObjectTemplatePolicyList{}
+	err := c.Client.List(context.Background(), policyList)
+
+	return policyList.Items, err
+}
+
+// CheckPolicies validate a generated object against all template policies
+func (c *Common) CheckPolicies(obj unstructured.Unstructured) error {
+	policies, err := c.FindObjectTemplatePolicies()
+
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range policies {
+		if err := checkPolicy(policy, obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckPoliciesKind validate if a kind is allowed by all template policies
+func (c *Common) CheckPoliciesKind(gvk schema.GroupVersionKind) error {
+	policies, err := c.FindObjectTemplatePolicies()
+
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range policies {
+		if !isKindAllowed(policy.Spec.AllowedKinds, gvk) {
+			return &PolicyViolationError{Policy: policy.Name, Message: fmt.Sprintf("kind %v is not allowed", gvk)}
+		}
+	}
+
+	return nil
+}
+
+// CheckPoliciesLookup validate if an object can be read by templates (kind and namespace must be allowed by all template policies)
+func (c *Common) CheckPoliciesLookup(gvk schema.GroupVersionKind, namespaceName string) error {
+	if err := c.CheckPoliciesKind(gvk); err != nil {
+		return err
+	}
+
+	return c.CheckPoliciesNamespace(namespaceName)
+}
+
+// CheckPoliciesNamespace validate if a namespace is allowed by all template policies
+func (c *Common) CheckPoliciesNamespace(namespaceName string) error {
+	policies, err := c.FindObjectTemplatePolicies()
+
+	if err != nil {
+		return err
+	}
+
+	for _, policy := range policies {
+		if !isNamespaceAllowed(policy.Spec.AllowedNamespaces, namespaceName) {
+			return &PolicyViolationError{Policy: policy.Name, Message: fmt.Sprintf("namespace %v is not allowed", namespaceName)}
+		}
+	}
+
+	return nil
+}
+
+// validateTemplatePolicies validate kinds of template objects against all template policies
+func (c *Common) validateTemplatePolicies(ot otv1.ObjectTemplate) error {
+	for _, obj := range ot.Spec.Objects {
+		if err := c.CheckPoliciesKind(schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind)); err != nil {
+			return fmt.Errorf("[%v(%v)] %w", obj.Kind, obj.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// validateParamsPolicies render objects of all templates used by parameters and validate them against all template policies
+// (objects that can't be rendered are reported by the reconciler)
+func (c *Common) validateParamsPolicies(otp otv1.ObjectTemplateParams) error {
+	for _, parameter := range otp.Spec.Templates {
+		ot, err := c.GetObjectTemplateByName(parameter.Name)
+
+		if err != nil {
+			return err
+		}
+
+		if ot == nil {
+			continue
+		}
+
+		applied, _, err := c.TemplateByParams(*ot, otp)
+
+		if err != nil {
+			continue
+		}
+
+		rc, err := c.renderingFor(applied, otp)
+
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range applied.Spec.Objects {
+			values, err := rc.normalizeParametersValues(obj, otp.Namespace, applied.Spec.Parameters, applied.Spec.Migrations, parameter.Values)
+
+			if err != nil {
+				continue
+			}
+
+			namespaced, err := c.isNamespaced(schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind))
+
+			if err != nil {
+				continue
+			}
+
+			newObj, _, err := rc.ToObject(obj, nil, values, otp.Namespace)
+
+			if err != nil {
+				continue
+			}
+			if !namespaced {
+				newObj.SetNamespace("")
+			}
+
+			if err := c.CheckPolicies(newObj); err != nil {
+				return fmt.Errorf("%v: [%v(%v)] %w", ot.Name, obj.Kind, obj.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkPolicy validate a generated object against a template policy
+func checkPolicy(policy otv1.ObjectTemplatePolicy, obj unstructured.Unstructured) error {
+	gvk := obj.GroupVersionKind()
+
+	if !isKindAllowed(policy.Spec.AllowedKinds, gvk) {
+		return &PolicyViolationError{Policy: policy.Name, Message: fmt.Sprintf("kind %v is not allowed", gvk)}
+	}
+
+	if !isNamespaceAllowed(policy.Spec.AllowedNamespaces, obj.GetNamespace()) {
+		return &PolicyViolationError{Policy: policy.Name, Message: fmt.Sprintf("namespace %v is not allowed", obj.GetNamespace())}
+	}
+
+	for _, field := range policy.Spec.ForbiddenFields {
+		forbidden, err := hasForbiddenField(field, obj)
+
+		if err != nil {
+			return fmt.Errorf("Error evaluating forbidden field %v of policy %v: %w", field.JSONPath, policy.Name, err)
+		}
+
+		if forbidden {
+			return &PolicyViolationError{Policy: policy.Name, Message: fmt.Sprintf("field %v is forbidden in %v", field.JSONPath, gvk.Kind)}
+		}
+	}
+
+	return nil
+}
+
+// isKindAllowed kind matches any allowed kind (all kinds are allowed if empty)
+func isKindAllowed(allowedKinds []otv1.AllowedKind, gvk schema.GroupVersionKind) bool {
+	if len(allowedKinds) == 0 {
+		return true
+	}
+
+	for _, allowed := range allowedKinds {
+		if (allowed.Group == "*" || allowed.Group == gvk.Group) &&
+			(len(allowed.Version) == 0 || allowed.Version == "*" || allowed.Version == gvk.Version) &&
+			(allowed.Kind == "*" || allowed.Kind == gvk.Kind) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isNamespaceAllowed namespace matches any allowed name or glob (all namespaces are allowed if empty)
+func isNamespaceAllowed(allowedNamespaces []string, namespaceName string) bool {
+	if len(allowedNamespaces) == 0 {
+		return true
+	}
+
+	for _, pattern := range allowedNamespaces {
+		if matched, err := path.Match(pattern, namespaceName); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasForbiddenField object has the field (or one of the values returned by JSONPath is the forbidden value)
+func hasForbiddenField(field otv1.ForbiddenField, obj unstructured.Unstructured) (bool, error) {
+	if len(field.Kinds) > 0 && !containsString(field.Kinds, obj.GetKind()) {
+		return false, nil
+	}
+
+	value, err := evaluateJSONPath(obj, field.JSONPath)
+
+	if err != nil {
+		return false, err
+	}
+
+	if len(field.Value) == 0 {
+		return len(strings.TrimSpace(value)) > 0, nil
+	}
+
+	return containsString(strings.Fields(value), field.Value), nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
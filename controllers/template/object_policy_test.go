@@ -0,0 +1,155 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Template policies", func() {
+	policy := otv1.ObjectTemplatePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "restricted"},
+		Spec: otv1.ObjectTemplatePolicySpec{
+			AllowedKinds: []otv1.AllowedKind{
+				{Group: "", Kind: "ConfigMap"},
+				{Group: "apps", Version: "v1", Kind: "*"},
+				{Group: "", Kind: "Pod"},
+			},
+			ForbiddenFields: []otv1.ForbiddenField{
+				{Kinds: []string{"Pod"}, JSONPath: ".spec.hostNetwork"},
+				{JSONPath: ".spec.containers[*].securityContext.privileged", Value: "true"},
+			},
+			AllowedNamespaces: []string{"team-*", "default"},
+		},
+	}
+
+	pod := func(namespace string, spec map[string]interface{}) unstructured.Unstructured {
+		obj := unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("Pod")
+		obj.SetName("pod")
+		obj.SetNamespace(namespace)
+
+		return obj
+	}
+
+	Context("With allowed kinds", func() {
+		It("Should match group, version and kind", func() {
+			Expect(isKindAllowed(policy.Spec.AllowedKinds, schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"})).To(BeTrue())
+			Expect(isKindAllowed(policy.Spec.AllowedKinds, schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"})).To(BeTrue())
+			Expect(isKindAllowed(policy.Spec.AllowedKinds, schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Deployment"})).To(BeFalse())
+			Expect(isKindAllowed(policy.Spec.AllowedKinds, schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"})).To(BeFalse())
+		})
+
+		It("Should allow all kinds if empty", func() {
+			Expect(isKindAllowed(nil, schema.GroupVersionKind{Version: "v1", Kind: "Secret"})).To(BeTrue())
+		})
+	})
+
+	Context("With allowed namespaces", func() {
+		It("Should match names and globs", func() {
+			Expect(isNamespaceAllowed(policy.Spec.AllowedNamespaces, "team-a")).To(BeTrue())
+			Expect(isNamespaceAllowed(policy.Spec.AllowedNamespaces, "default")).To(BeTrue())
+			Expect(isNamespaceAllowed(policy.Spec.AllowedNamespaces, "kube-system")).To(BeFalse())
+			Expect(isNamespaceAllowed(nil, "kube-system")).To(BeTrue())
+		})
+	})
+
+	Context("With forbidden fields", func() {
+		It("Should deny host network", func() {
+			err := checkPolicy(policy, pod("team-a", map[string]interface{}{"hostNetwork": true}))
+
+			Expect(err).To(HaveOccurred())
+			Expect(isPolicyViolationError(err)).To(BeTrue())
+		})
+
+		It("Should deny privileged containers", func() {
+			err := checkPolicy(policy, pod("team-a", map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"name": "a"},
+					map[string]interface{}{"name": "b", "securityContext": map[string]interface{}{"privileged": true}},
+				},
+			}))
+
+			Expect(isPolicyViolationError(err)).To(BeTrue())
+		})
+
+		It("Should allow objects without forbidden fields", func() {
+			err := checkPolicy(policy, pod("team-a", map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"name": "a", "securityContext": map[string]interface{}{"privileged": false}},
+				},
+			}))
+
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny objects outside allowed namespaces", func() {
+			Expect(isPolicyViolationError(checkPolicy(policy, pod("kube-system", map[string]interface{}{})))).To(BeTrue())
+		})
+	})
+
+	Context("With parameters", func() {
+		It("Should check cluster scoped objects without namespace", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(otv1.AddToScheme(scheme)).To(Succeed())
+			mapper := meta.NewDefaultRESTMapper(nil)
+			mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+			mapper.Add(rbacv1.SchemeGroupVersion.WithKind("ClusterRole"), meta.RESTScopeRoot)
+
+			namespaces := &otv1.ObjectTemplatePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "namespaces"},
+				Spec:       otv1.ObjectTemplatePolicySpec{AllowedNamespaces: []string{"team-*"}},
+			}
+			template := func(apiVersion string, kind string) *otv1.ObjectTemplate {
+				return &otv1.ObjectTemplate{
+					ObjectMeta: metav1.ObjectMeta{Name: "tenant"},
+					Spec:       otv1.ObjectTemplateSpec{Objects: []otv1.Object{{APIVersion: apiVersion, Kind: kind, Name: "team-a-reader"}}},
+				}
+			}
+			otp := otv1.ObjectTemplateParams{
+				ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "team-a"},
+				Spec:       otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{{Name: "tenant"}}},
+			}
+			common := func(ot *otv1.ObjectTemplate) Common {
+				teamA := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+				return Common{Client: fake.NewFakeClientWithScheme(scheme, namespaces, teamA, ot), Log: ctrl.Log.WithName("test"), Mapper: mapper}
+			}
+
+			c := common(template("v1", "ConfigMap"))
+			Expect(c.validateParamsPolicies(otp)).To(Succeed())
+
+			c = common(template("rbac.authorization.k8s.io/v1", "ClusterRole"))
+			err := c.validateParamsPolicies(otp)
+			Expect(isPolicyViolationError(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("namespace  is not allowed")))
+		})
+	})
+})
@@ -0,0 +1,301 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	jsonpatch "github.com/evanphx/json-patch"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	previewActionCreate   = "Create"
+	previewActionUpdate   = "Update"
+	previewActionNone     = "None"
+	previewActionRecreate = "Recreate"
+	redactedValue         = "<redacted>"
+	redactedChangedValue  = "<redacted, changed>"
+)
+
+var secretGK = schema.GroupKind{Kind: "Secret"}
+
+// PreviewObjectsByTemplate render objects and validate them using server side dry-run
+func (c *Common) PreviewObjectsByTemplate(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) ([]otv1.ObjectPreview, error) {
+	previews := []otv1.ObjectPreview{}
+	parameters, err := otp.Spec.GetParametersByTemplateName(ot.Name)
+
+	if err != nil {
+		return previews, err
+	}
+
+	objects, err := sortObjectsByDependencies(ot.Spec.Objects)
+
+	if err != nil {
+		return previews, err
+	}
+
+	rc, err := c.renderingFor(ot, otp)
+
+	if err != nil {
+		return previews, err
+	}
+	defer rc.trackLookups(ot, otp)
+
+	objectsError := &ObjectsError{}
+	for _, obj := range objects {
+		preview, err := rc.previewObject(ot, otp, obj, parameters.Values)
+
+		if err != nil {
+			objectsError.Errors = append(objectsError.Errors, err)
+
+			if ot.Spec.FailurePolicy == otv1.FailurePolicyAbort {
+				break
+			}
+			continue
+		}
+
+		previews = append(previews, preview)
+	}
+
+	return previews, objectsError.ErrorOrNil()
+}
+
+func (c *Common) previewObject(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams, obj otv1.Object, paramsValues map[string]string) (otv1.ObjectPreview, error) {
+	normParams, err := c.normalizeParametersValues(obj, otp.Namespace, ot.Spec.Parameters, ot.Spec.Migrations, paramsValues)
+
+	if err != nil {
+		return otv1.ObjectPreview{}, fmt.Errorf("Error processing parameters of [%v(%v)]: %w", obj.Kind, obj.Name, err)
+	}
+
+	return c.PreviewSingleObjectByTemplate(ot, otp, obj, normParams)
+}
+
+// PreviewSingleObjectByTemplate render object and validate it using server side dry-run
+func (c *Common) PreviewSingleObjectByTemplate(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams, obj otv1.Object, values map[string]string) (otv1.ObjectPreview, error) {
+	ctx := context.Background()
+	namespaceName := otp.Namespace
+	reference := fmt.Sprintf("[%v(%v)] at %v namespace", obj.Kind, obj.Name, namespaceName)
+	preview := otv1.ObjectPreview{
+		Namespace:  namespaceName,
+		APIVersion: obj.APIVersion,
+		Kind:       obj.Kind,
+		Name:       obj.Name,
+	}
+
+	namespaced, err := c.isNamespaced(schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind))
+
+	if err != nil {
+		return preview, fmt.Errorf("Error serializing %v: %v", reference, err.Error())
+	}
+
+	newObj, gvk, err := c.ToObject(obj, ownerReferencesFor(otp, namespaced), values, namespaceName)
+
+	if err != nil {
+		return preview, fmt.Errorf("Error serializing %v: %v", reference, err.Error())
+	}
+	if !namespaced {
+		newObj.SetNamespace("")
+		preview.Namespace = ""
+	}
+	setManagedMarkers(&newObj, ot, otp)
+
+	if err := c.CheckPolicies(newObj); err != nil {
+		return preview, fmt.Errorf("Error validating object %v: %w", reference, err)
+	}
+
+	objectsClient, err := c.objectsClient(ot, otp)
+
+	if err != nil {
+		return preview, fmt.Errorf("Error validating object %v: %w", reference, err)
+	}
+
+	current := unstructured.Unstructured{}
+	current.SetGroupVersionKind(*gvk)
+	err = objectsClient.Get(ctx, client.ObjectKey{Namespace: newObj.GetNamespace(), Name: obj.Name}, &current)
+
+	if k8sErrors.IsNotFound(err) {
+		if err := objectsClient.Create(ctx, &newObj, client.DryRunAll); err != nil {
+			return preview, fmt.Errorf("Error validating object %v: %v", reference, err.Error())
+		}
+
+		preview.Action = previewActionCreate
+		preview.Manifest, err = toManifest(newObj)
+
+		return preview, err
+	} else if err != nil {
+		return preview, fmt.Errorf("Error getting object %v: %v", reference, err.Error())
+	}
+
+	if isObjectSuspended(current) {
+		preview.Action = previewActionNone
+		preview.Manifest, err = toManifest(current)
+
+		return preview, err
+	}
+
+	if err := checkAdoption(ot, current); err != nil {
+		return preview, fmt.Errorf("Error validating object %v: %v", reference, err.Error())
+	}
+
+	if err := c.checkConflict(otp, current); err != nil {
+		return preview, fmt.Errorf("Error validating object %v: %w", reference, err)
+	}
+
+	live := current.DeepCopy()
+	if obj.UpdateStrategy == otv1.UpdateStrategyReplace {
+		replaceObject(&current, newObj)
+	} else {
+		mutateObject(&current, newObj)
+	}
+
+	if !namespaced {
+		// references set by previous versions are invalid for cluster scoped objects
+		current.SetOwnerReferences(removeOwnerReference(current.GetOwnerReferences(), otp.UID))
+	}
+
+	if err := objectsClient.Update(ctx, &current, client.DryRunAll); err != nil {
+		if obj.UpdateStrategy == otv1.UpdateStrategyRecreate && isImmutableFieldError(err) {
+			preview.Action = previewActionRecreate
+			preview.Manifest, err = toManifest(newObj)
+
+			return preview, err
+		}
+
+		return preview, fmt.Errorf("Error validating object %v: %v", reference, err.Error())
+	}
+
+	if preview.Manifest, err = toManifest(current); err != nil {
+		return preview, err
+	}
+
+	if preview.Diff, err = diffObjects(*live, current); err != nil {
+		return preview, err
+	}
+
+	preview.Action = previewActionUpdate
+	if len(preview.Diff) == 0 {
+		preview.Action = previewActionNone
+	}
+
+	return preview, nil
+}
+
+// summarizePreviews count preview actions of parameters (template status can't hold manifests of all namespaces)
+func summarizePreviews(otp otv1.ObjectTemplateParams, previews []otv1.ObjectPreview) otv1.ObjectPreviewSummary {
+	summary := otv1.ObjectPreviewSummary{Namespace: otp.Namespace, Params: otp.Name}
+
+	for _, preview := range previews {
+		switch preview.Action {
+		case previewActionCreate:
+			summary.Create++
+		case previewActionUpdate:
+			summary.Update++
+		case previewActionRecreate:
+			summary.Recreate++
+		default:
+			summary.None++
+		}
+	}
+
+	return summary
+}
+
+// toManifest object to yaml without server managed metadata and secret values
+func toManifest(obj unstructured.Unstructured) (string, error) {
+	cleaned := cleanObject(obj)
+	redactSecret(cleaned, nil)
+	manifest, err := yaml.Marshal(cleaned.Object)
+
+	return string(manifest), err
+}
+
+// diffObjects json merge patch between two objects (empty if they are equal, secret values are redacted)
+func diffObjects(original unstructured.Unstructured, modified unstructured.Unstructured) (string, error) {
+	cleanedOriginal, cleanedModified := cleanObject(original), cleanObject(modified)
+	redactSecret(cleanedModified, cleanedOriginal)
+	redactSecret(cleanedOriginal, nil)
+
+	originalJSON, err := json.Marshal(cleanedOriginal.Object)
+
+	if err != nil {
+		return "", err
+	}
+
+	modifiedJSON, err := json.Marshal(cleanedModified.Object)
+
+	if err != nil {
+		return "", err
+	}
+
+	patch, err := jsonpatch.CreateMergePatch(originalJSON, modifiedJSON)
+
+	if err != nil || string(patch) == "{}" {
+		return "", err
+	}
+
+	return string(patch), nil
+}
+
+func cleanObject(obj unstructured.Unstructured) *unstructured.Unstructured {
+	cleaned := obj.DeepCopy()
+	cleaned.SetManagedFields(nil)
+	cleaned.SetResourceVersion("")
+	cleaned.SetGeneration(0)
+	cleaned.SetUID("")
+	cleaned.SetSelfLink("")
+	cleaned.SetCreationTimestamp(metav1.Time{})
+	unstructured.RemoveNestedField(cleaned.Object, "status")
+
+	return cleaned
+}
+
+// redactSecret replace secret values with a marker (values changed from previous object get another marker, so diffs still show them)
+func redactSecret(obj *unstructured.Unstructured, previous *unstructured.Unstructured) {
+	if obj.GroupVersionKind().GroupKind() != secretGK {
+		return
+	}
+
+	for _, field := range []string{"data", "stringData"} {
+		values, found, _ := unstructured.NestedMap(obj.Object, field)
+
+		if !found {
+			continue
+		}
+
+		previousValues := map[string]interface{}{}
+		if previous != nil {
+			previousValues, _, _ = unstructured.NestedMap(previous.Object, field)
+		}
+
+		for key, value := range values {
+			values[key] = redactedValue
+			if previousValue, found := previousValues[key]; found && previousValue != value {
+				values[key] = redactedChangedValue
+			}
+		}
+		unstructured.SetNestedMap(obj.Object, values, field)
+	}
+}
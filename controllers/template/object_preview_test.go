@@ -0,0 +1,226 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"container/list"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+var _ = Describe("Object preview", func() {
+	object := func(kind string, data map[string]interface{}) unstructured.Unstructured {
+		obj := unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       kind,
+			"metadata": map[string]interface{}{
+				"name":              "settings",
+				"namespace":         "team-a",
+				"uid":               "object-uid",
+				"resourceVersion":   "10",
+				"generation":        int64(2),
+				"selfLink":          "/api/v1/namespaces/team-a/settings",
+				"creationTimestamp": "2020-11-01T10:00:00Z",
+				"managedFields":     []interface{}{map[string]interface{}{"manager": "kubectl"}},
+			},
+			"data":   data,
+			"status": map[string]interface{}{"phase": "Active"},
+		}}
+		return obj
+	}
+
+	It("Should remove server managed metadata and status", func() {
+		cleaned := cleanObject(object("ConfigMap", map[string]interface{}{"name": "foo"}))
+
+		Expect(cleaned.GetUID()).To(BeEmpty())
+		Expect(cleaned.GetResourceVersion()).To(BeEmpty())
+		Expect(cleaned.GetGeneration()).To(BeZero())
+		Expect(cleaned.GetSelfLink()).To(BeEmpty())
+		Expect(cleaned.GetManagedFields()).To(BeEmpty())
+		Expect(cleaned.GetCreationTimestamp()).To(Equal(metav1.Time{}))
+		Expect(cleaned.Object).NotTo(HaveKey("status"))
+		Expect(cleaned.GetName()).To(Equal("settings"))
+		Expect(cleaned.GetNamespace()).To(Equal("team-a"))
+	})
+
+	It("Should not change the original object", func() {
+		original := object("ConfigMap", map[string]interface{}{"name": "foo"})
+		cleanObject(original)
+
+		Expect(original.GetUID()).NotTo(BeEmpty())
+		Expect(original.Object).To(HaveKey("status"))
+	})
+
+	It("Should write clean manifests", func() {
+		manifest, err := toManifest(object("ConfigMap", map[string]interface{}{"name": "foo"}))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest).To(MatchYAML(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: team-a
+data:
+  name: foo
+`))
+	})
+
+	It("Should redact secret values in manifests", func() {
+		secret := object("Secret", map[string]interface{}{"password": "c2VjcmV0"})
+		secret.Object["stringData"] = map[string]interface{}{"token": "secret"}
+		manifest, err := toManifest(secret)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manifest).NotTo(ContainSubstring("c2VjcmV0"))
+		Expect(manifest).NotTo(ContainSubstring("token: secret"))
+		Expect(manifest).To(ContainSubstring("password: <redacted>"))
+		Expect(manifest).To(ContainSubstring("token: <redacted>"))
+	})
+
+	It("Should return an empty diff for equal objects", func() {
+		live := object("ConfigMap", map[string]interface{}{"name": "foo"})
+		modified := live.DeepCopy()
+		modified.SetResourceVersion("11")
+
+		diff, err := diffObjects(live, *modified)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(BeEmpty())
+	})
+
+	It("Should return a merge patch of changed fields", func() {
+		live := object("ConfigMap", map[string]interface{}{"name": "foo", "removed": "bar"})
+		modified := object("ConfigMap", map[string]interface{}{"name": "baz"})
+		modified.SetLabels(map[string]string{"tier": "frontend"})
+
+		diff, err := diffObjects(live, modified)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(MatchJSON(`{"data":{"name":"baz","removed":null},"metadata":{"labels":{"tier":"frontend"}}}`))
+	})
+
+	It("Should show changed secret keys without values", func() {
+		live := object("Secret", map[string]interface{}{"password": "b2xk", "user": "YWRtaW4="})
+		modified := object("Secret", map[string]interface{}{"password": "bmV3", "user": "YWRtaW4=", "token": "dG9rZW4="})
+
+		diff, err := diffObjects(live, modified)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff).To(MatchJSON(`{"data":{"password":"<redacted, changed>","token":"<redacted>"}}`))
+	})
+
+	It("Should summarize preview actions by parameters", func() {
+		otp := otv1.ObjectTemplateParams{ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "team-a"}}
+		summary := summarizePreviews(otp, []otv1.ObjectPreview{
+			{Action: previewActionCreate, Manifest: "kind: ConfigMap"},
+			{Action: previewActionCreate},
+			{Action: previewActionUpdate},
+			{Action: previewActionRecreate},
+			{Action: previewActionNone},
+		})
+
+		Expect(summary).To(Equal(otv1.ObjectPreviewSummary{Namespace: "team-a", Params: "params", Create: 2, Update: 1, Recreate: 1, None: 1}))
+	})
+
+	Context("With objects applied by template", func() {
+		var scheme *runtime.Scheme
+		var mapper meta.RESTMapper
+		otp := otv1.ObjectTemplateParams{ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "team-a", UID: "params-uid"}}
+		ot := otv1.ObjectTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenant"},
+			Spec: otv1.ObjectTemplateSpec{Objects: []otv1.Object{
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "settings", TemplateBody: "data:\n  value: new"},
+				{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Name: "team-a-reader", TemplateBody: "rules: []"},
+			}},
+		}
+		preview := func(common Common, obj otv1.Object) (otv1.ObjectPreview, unstructured.Unstructured) {
+			preview, err := common.PreviewSingleObjectByTemplate(ot, otp, obj, map[string]string{})
+			Expect(err).NotTo(HaveOccurred())
+
+			manifest := unstructured.Unstructured{}
+			Expect(yaml.Unmarshal([]byte(preview.Manifest), &manifest.Object)).To(Succeed())
+			return preview, manifest
+		}
+
+		BeforeEach(func() {
+			scheme = runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(otv1.AddToScheme(scheme)).To(Succeed())
+
+			defaultMapper := meta.NewDefaultRESTMapper(nil)
+			defaultMapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+			defaultMapper.Add(rbacv1.SchemeGroupVersion.WithKind("ClusterRole"), meta.RESTScopeRoot)
+			mapper = defaultMapper
+		})
+
+		It("Should preview cluster scoped objects without namespace and owner references", func() {
+			common := Common{Client: fake.NewFakeClientWithScheme(scheme), Log: ctrl.Log.WithName("test"), Mapper: mapper}
+
+			result, manifest := preview(common, ot.Spec.Objects[1])
+			Expect(result.Action).To(Equal(previewActionCreate))
+			Expect(result.Namespace).To(BeEmpty())
+			Expect(manifest.GetNamespace()).To(BeEmpty())
+			Expect(manifest.GetOwnerReferences()).To(BeEmpty())
+
+			result, manifest = preview(common, ot.Spec.Objects[0])
+			Expect(result.Namespace).To(Equal("team-a"))
+			Expect(manifest.GetOwnerReferences()).To(HaveLen(1))
+		})
+
+		It("Should remove owner references of existing cluster scoped objects", func() {
+			role := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "team-a-reader", OwnerReferences: getOwnerReferences(otp)}}
+			common := Common{Client: fake.NewFakeClientWithScheme(scheme, role), Log: ctrl.Log.WithName("test"), Mapper: mapper}
+
+			result, manifest := preview(common, ot.Spec.Objects[1])
+			Expect(result.Action).To(Equal(previewActionUpdate))
+			Expect(manifest.GetOwnerReferences()).To(BeEmpty())
+		})
+
+		It("Should read existing objects with the impersonated client", func() {
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a"},
+				Data:       map[string]string{"value": "old"},
+			}
+			serviceAccountClient := fake.NewFakeClientWithScheme(scheme, configMap)
+			common := Common{Client: fake.NewFakeClientWithScheme(scheme), Log: ctrl.Log.WithName("test"), Mapper: mapper, Impersonator: &Impersonator{}}
+			common.Impersonator.clients = map[string]*list.Element{}
+			common.Impersonator.recent = list.New()
+			userName := serviceAccountUserName("team-a", "deployer")
+			common.Impersonator.clients[userName] = common.Impersonator.recent.PushFront(&impersonatedClient{userName: userName, client: serviceAccountClient})
+
+			deployer := *otp.DeepCopy()
+			deployer.Spec.ServiceAccountName = "deployer"
+			result, err := common.PreviewSingleObjectByTemplate(ot, deployer, ot.Spec.Objects[0], map[string]string{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Action).To(Equal(previewActionUpdate))
+			Expect(result.Diff).To(ContainSubstring(`"value":"new"`))
+		})
+	})
+})
@@ -0,0 +1,148 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+)
+
+// Renderer render the body of an object template using values
+type Renderer interface {
+	Render(obj otv1.Object, values map[string]interface{}) (map[string]interface{}, error)
+}
+
+// rendererFactory build a renderer for objects of a namespace
+type rendererFactory func(c *Common, namespaceName string) Renderer
+
+// renderers rendering engines by name
+var renderers = map[otv1.Engine]rendererFactory{
+	otv1.EngineGoTemplate: func(c *Common, namespaceName string) Renderer {
+		return &goTemplateRenderer{funcs: c.lookupFuncMap(namespaceName)}
+	},
+	otv1.EngineJsonnet: func(c *Common, namespaceName string) Renderer {
+		return &jsonnetRenderer{}
+	},
+	otv1.EngineCUE: func(c *Common, namespaceName string) Renderer {
+		return &cueRenderer{}
+	},
+}
+
+// rendererFor renderer of object engine (objects with base only have templated patches)
+func (c *Common) rendererFor(obj otv1.Object, namespaceName string) (Renderer, error) {
+	if obj.Base != nil {
+		return &patchRenderer{funcs: c.lookupFuncMap(namespaceName)}, nil
+	}
+
+	engine := c.getEngine(obj)
+	factory, found := renderers[engine]
+
+	if !found {
+		return nil, fmt.Errorf("unknown engine %v", engine)
+	}
+
+	return factory(c, namespaceName), nil
+}
+
+// getEngine object engine, template engine or default
+func (c *Common) getEngine(obj otv1.Object) otv1.Engine {
+	if len(obj.Engine) > 0 {
+		return obj.Engine
+	}
+
+	if c.rendering != nil && len(c.rendering.template.Spec.Engine) > 0 {
+		return c.rendering.template.Spec.Engine
+	}
+
+	return otv1.EngineGoTemplate
+}
+
+// goTemplateRenderer go text/template producing YAML
+type goTemplateRenderer struct {
+	funcs template.FuncMap
+}
+
+func (r *goTemplateRenderer) Render(obj otv1.Object, values map[string]interface{}) (map[string]interface{}, error) {
+	// body is rendered alone to report template lines
+	body, err := renderTemplate("template", obj.TemplateBody, values, templateOptions, r.funcs)
+
+	if err != nil {
+		return nil, err
+	}
+	templateYAMLExecuted := getStringObject(obj.APIVersion, obj.Kind, body)
+
+	object := unstructured.Unstructured{}
+	dec := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
+	if _, _, err = dec.Decode([]byte(templateYAMLExecuted), nil, &object); err != nil {
+		return nil, err
+	}
+
+	return object.Object, nil
+}
+
+// jsonnetRenderer jsonnet program producing the object (imports are not allowed)
+type jsonnetRenderer struct{}
+
+func (r *jsonnetRenderer) Render(obj otv1.Object, values map[string]interface{}) (map[string]interface{}, error) {
+	return evaluateObject(otv1.EngineJsonnet, obj, values)
+}
+
+// cueRenderer cue source producing the object (values are appended as a hidden field)
+type cueRenderer struct{}
+
+func (r *cueRenderer) Render(obj otv1.Object, values map[string]interface{}) (map[string]interface{}, error) {
+	return evaluateObject(otv1.EngineCUE, obj, values)
+}
+
+// evaluateObject evaluate object template body with an engine using template options limits
+func evaluateObject(engine otv1.Engine, obj otv1.Object, values map[string]interface{}) (map[string]interface{}, error) {
+	valuesJSON, err := json.Marshal(values)
+
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := evaluateWithLimits(templateOptions, evaluation{Engine: string(engine), Name: obj.Name, Source: obj.TemplateBody, Values: valuesJSON})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeJSONObject(output)
+}
+
+// decodeJSONObject decode rendered JSON object (integers are kept as int64)
+func decodeJSONObject(data string) (map[string]interface{}, error) {
+	var decoded interface{}
+
+	if err := utiljson.Unmarshal([]byte(data), &decoded); err != nil {
+		return nil, err
+	}
+
+	object, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("rendered value must be an object, got %T", decoded)
+	}
+
+	return object, nil
+}
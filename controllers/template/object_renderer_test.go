@@ -0,0 +1,85 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rendering engines", func() {
+	common := Common{}
+	values := map[string]string{"name": "foo", "replicas": "3"}
+	object := func(engine otv1.Engine, body string) otv1.Object {
+		return otv1.Object{Kind: "ConfigMap", APIVersion: "v1", Name: "test", Engine: engine, TemplateBody: body}
+	}
+
+	It("Should render go templates by default", func() {
+		obj, _, err := common.ToObject(object("", "data:\n  name: {{ .name }}"), nil, values, "ns")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj.Object["data"]).To(Equal(map[string]interface{}{"name": "foo"}))
+	})
+
+	It("Should render jsonnet", func() {
+		obj, _, err := common.ToObject(object(otv1.EngineJsonnet, `
+local values = std.extVar("values");
+{
+  data: { name: values.name, namespace: values.__namespace },
+  spec: { replicas: std.parseInt(values.replicas) },
+}`), nil, values, "ns")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj.Object["data"]).To(Equal(map[string]interface{}{"name": "foo", "namespace": "ns"}))
+		Expect(obj.Object["spec"]).To(Equal(map[string]interface{}{"replicas": int64(3)}))
+		Expect(obj.GetKind()).To(Equal("ConfigMap"))
+	})
+
+	It("Should not import files with jsonnet", func() {
+		_, _, err := common.ToObject(object(otv1.EngineJsonnet, `import "/etc/passwd"`), nil, values, "ns")
+
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should render cue", func() {
+		obj, _, err := common.ToObject(object(otv1.EngineCUE, `
+import "strings"
+
+data: {
+	name:  strings.ToUpper(_values.name)
+	label: "\(_values.name)-\(_values["__namespace"])"
+}`), nil, values, "ns")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj.Object["data"]).To(Equal(map[string]interface{}{"name": "FOO", "label": "foo-ns"}))
+	})
+
+	It("Should fail if cue is not concrete", func() {
+		_, _, err := common.ToObject(object(otv1.EngineCUE, `data: name: string`), nil, values, "ns")
+
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should use template engine", func() {
+		rendering := &Common{rendering: &renderContext{template: otv1.ObjectTemplate{Spec: otv1.ObjectTemplateSpec{Engine: otv1.EngineJsonnet}}}}
+
+		Expect(rendering.getEngine(object("", ""))).To(Equal(otv1.EngineJsonnet))
+		Expect(rendering.getEngine(object(otv1.EngineCUE, ""))).To(Equal(otv1.EngineCUE))
+		Expect(common.getEngine(object("", ""))).To(Equal(otv1.EngineGoTemplate))
+	})
+})
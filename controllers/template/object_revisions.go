@@ -0,0 +1,365 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	templateKind = reflect.TypeOf(otv1.ObjectTemplate{}).Name()
+	revisionKind = reflect.TypeOf(otv1.ObjectTemplateRevision{}).Name()
+)
+
+// revisionHash hash of template spec used to detect changes (rollout, history and operational settings are ignored)
+func revisionHash(spec otv1.ObjectTemplateSpec) (string, error) {
+	data, err := json.Marshal(revisionSpec(spec))
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:16], nil
+}
+
+// revisionSpec template spec without the settings that are not recorded in revisions
+func revisionSpec(spec otv1.ObjectTemplateSpec) otv1.ObjectTemplateSpec {
+	keepLiveSettings(&spec, otv1.ObjectTemplateSpec{})
+
+	return spec
+}
+
+// keepLiveSettings copy the settings that are not recorded in revisions from the live template spec, so
+// suspending, dry-running or changing the service account of a template also applies to pinned revisions
+func keepLiveSettings(spec *otv1.ObjectTemplateSpec, live otv1.ObjectTemplateSpec) {
+	spec.Rollout = live.Rollout
+	spec.RollbackTo = live.RollbackTo
+	spec.RevisionHistoryLimit = live.RevisionHistoryLimit
+	spec.AllowedNamespaces = live.AllowedNamespaces
+	spec.Suspend = live.Suspend
+	spec.DryRun = live.DryRun
+	spec.ResyncPeriod = live.ResyncPeriod
+	spec.ServiceAccountName = live.ServiceAccountName
+}
+
+// revisionName name of the revision object of a template
+func revisionName(templateName string, revision int64) string {
+	return fmt.Sprintf("%v-%v", templateName, revision)
+}
+
+// FindRevisionsByTemplateName find all revisions of a template sorted by revision number
+func (c *Common) FindRevisionsByTemplateName(templateName string) ([]otv1.ObjectTemplateRevision, error) {
+	revisionList := &otv1.ObjectTemplateRevisionList{}
+	err := c.Client.List(context.Background(), revisionList, client.MatchingLabels{otv1.TemplateLabel: templateName})
+
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := revisionList.Items
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Spec.Revision < revisions[j].Spec.Revision
+	})
+
+	return revisions, nil
+}
+
+// EnsureRevision create a new revision if template spec changed since the latest revision
+func (c *Common) EnsureRevision(ot otv1.ObjectTemplate) (int64, error) {
+	hash, err := revisionHash(ot.Spec)
+
+	if err != nil {
+		return 0, err
+	}
+
+	revisions, err := c.FindRevisionsByTemplateName(ot.Name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var latest int64
+	if len(revisions) > 0 {
+		last := revisions[len(revisions)-1]
+
+		if last.Spec.Hash == hash {
+			return last.Spec.Revision, nil
+		}
+		latest = last.Spec.Revision
+	}
+
+	gvk := otv1.GroupVersion.WithKind(templateKind)
+	revision := otv1.ObjectTemplateRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            revisionName(ot.Name, latest+1),
+			Labels:          map[string]string{otv1.TemplateLabel: ot.Name},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(ot.GetObjectMeta(), gvk)},
+		},
+		Spec: otv1.ObjectTemplateRevisionSpec{
+			TemplateName: ot.Name,
+			Revision:     latest + 1,
+			Hash:         hash,
+			Template:     revisionSpec(*ot.Spec.DeepCopy()),
+		},
+	}
+
+	if err := c.Client.Create(context.Background(), &revision); err != nil {
+		return 0, fmt.Errorf("Error creating revision %v: %w", revision.Name, err)
+	}
+	c.Log.Info(fmt.Sprintf("Revision %v created", revision.Name))
+
+	return revision.Spec.Revision, nil
+}
+
+// PruneRevisions delete old revisions exceeding the history limit (latest and in use revisions are kept)
+func (c *Common) PruneRevisions(templateName string, limit int32, inUse map[int64]bool) error {
+	revisions, err := c.FindRevisionsByTemplateName(templateName)
+
+	if err != nil {
+		return err
+	}
+
+	if limit < 1 {
+		limit = 1
+	}
+
+	lu := LogUtil{Log: c.Log}
+	for i := 0; i < len(revisions)-int(limit); i++ {
+		revision := revisions[i]
+
+		if inUse[revision.Spec.Revision] {
+			continue
+		}
+
+		if err := c.Client.Delete(context.Background(), &revision); err != nil && !k8sErrors.IsNotFound(err) {
+			lu.Error(err, fmt.Sprintf("Failed to delete revision %v", revision.Name))
+			continue
+		}
+		c.Log.Info(fmt.Sprintf("Revision %v deleted", revision.Name))
+	}
+
+	return lu.AllErrors()
+}
+
+// revisionsInUse revisions applied or pinned by parameters
+func revisionsInUse(templateName string, otParams []otv1.ObjectTemplateParams) map[int64]bool {
+	inUse := map[int64]bool{}
+
+	for _, otp := range otParams {
+		if applied := appliedRevision(otp.Status.Revisions, templateName); applied > 0 {
+			inUse[applied] = true
+		}
+
+		parameters, err := otp.Spec.GetParametersByTemplateName(templateName)
+
+		if err != nil {
+			continue
+		}
+
+		if pinned, err := strconv.ParseInt(parameters.Revision, 10, 64); err == nil {
+			inUse[pinned] = true
+		}
+	}
+
+	return inUse
+}
+
+// TemplateByParams template using the revision selected by parameters and its revision number (0 if not recorded yet), with chart objects rendered using parameters values
+func (c *Common) TemplateByParams(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) (otv1.ObjectTemplate, int64, error) {
+	selected, revision, err := c.templateRevisionByParams(ot, otp)
+
+	if err != nil {
+		return selected, revision, err
+	}
+
+	// revisions may be recorded before objects were validated
+	for _, obj := range selected.Spec.Objects {
+		if err := validateObjectSource(obj); err != nil {
+			return selected, revision, fmt.Errorf("invalid revision %v of template %v: %w", revision, selected.Name, err)
+		}
+	}
+
+	expanded, err := c.expandChart(selected, otp)
+
+	return expanded, revision, err
+}
+
+// templateRevisionByParams template using the revision selected by parameters and its revision number
+func (c *Common) templateRevisionByParams(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) (otv1.ObjectTemplate, int64, error) {
+	parameters, err := otp.Spec.GetParametersByTemplateName(ot.Name)
+
+	if err != nil {
+		return ot, 0, err
+	}
+
+	if len(parameters.Revision) == 0 || parameters.Revision == otv1.RevisionLatest {
+		current := c.currentRevision(ot)
+
+		if heldByRollout(ot, otp, current) {
+			return c.TemplateByRevision(ot, appliedRevision(otp.Status.Revisions, ot.Name))
+		}
+
+		return ot, current, nil
+	}
+
+	number, err := strconv.ParseInt(parameters.Revision, 10, 64)
+
+	if err != nil {
+		return ot, 0, fmt.Errorf("invalid revision %v of template %v: must be a number or %v", parameters.Revision, ot.Name, otv1.RevisionLatest)
+	}
+
+	return c.TemplateByRevision(ot, number)
+}
+
+// TemplateByRevision template using the spec stored in a revision
+func (c *Common) TemplateByRevision(ot otv1.ObjectTemplate, number int64) (otv1.ObjectTemplate, int64, error) {
+	revision := otv1.ObjectTemplateRevision{}
+	err := c.Client.Get(context.Background(), types.NamespacedName{Name: revisionName(ot.Name, number)}, &revision)
+
+	if k8sErrors.IsNotFound(err) || (err == nil && revision.Spec.TemplateName != ot.Name) {
+		return ot, 0, fmt.Errorf("revision %v of template %v not found", number, ot.Name)
+	} else if err != nil {
+		return ot, 0, err
+	}
+
+	if err := verifyRevision(revision); err != nil {
+		return ot, 0, err
+	}
+
+	pinned := *ot.DeepCopy()
+	pinned.Spec = *revision.Spec.Template.DeepCopy()
+	keepLiveSettings(&pinned.Spec, ot.Spec)
+
+	return pinned, number, nil
+}
+
+// verifyRevision check that the stored template spec was not changed after the revision was recorded
+func verifyRevision(revision otv1.ObjectTemplateRevision) error {
+	hash, err := revisionHash(revision.Spec.Template)
+
+	if err != nil {
+		return err
+	}
+
+	if hash != revision.Spec.Hash {
+		return fmt.Errorf("revision %v of template %v was modified: hash %v does not match %v", revision.Spec.Revision, revision.Spec.TemplateName, hash, revision.Spec.Hash)
+	}
+
+	return nil
+}
+
+// validateRevisionUpdate revisions are immutable, only metadata can be changed
+func validateRevisionUpdate(old otv1.ObjectTemplateRevision, updated otv1.ObjectTemplateRevision) error {
+	if !reflect.DeepEqual(old.Spec, updated.Spec) {
+		return fmt.Errorf("spec of revision %v is immutable", updated.Name)
+	}
+
+	return nil
+}
+
+// currentRevision revision number of the current template spec
+func (c *Common) currentRevision(ot otv1.ObjectTemplate) int64 {
+	hash, err := revisionHash(ot.Spec)
+
+	if err != nil {
+		return 0
+	}
+
+	revisions, err := c.FindRevisionsByTemplateName(ot.Name)
+
+	if err != nil {
+		return 0
+	}
+
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if revisions[i].Spec.Hash == hash {
+			return revisions[i].Spec.Revision
+		}
+	}
+
+	return 0
+}
+
+// followsLatestRevision parameters use the latest revision of a template
+func followsLatestRevision(otp otv1.ObjectTemplateParams, templateName string) bool {
+	parameters, err := otp.Spec.GetParametersByTemplateName(templateName)
+
+	return err == nil && (len(parameters.Revision) == 0 || parameters.Revision == otv1.RevisionLatest)
+}
+
+// heldByRollout parameters are kept at the applied revision until rollout reaches them
+func heldByRollout(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams, current int64) bool {
+	applied := appliedRevision(otp.Status.Revisions, ot.Name)
+
+	return ot.Spec.Rollout != nil && applied > 0 && applied != current
+}
+
+// appliedRevision template revision applied by parameters (0 if none)
+func appliedRevision(revisions []otv1.AppliedRevision, templateName string) int64 {
+	for _, revision := range revisions {
+		if revision.Name == templateName {
+			return revision.Revision
+		}
+	}
+
+	return 0
+}
+
+// setAppliedRevision record template revision applied by parameters (returns true if changed)
+func setAppliedRevision(revisions *[]otv1.AppliedRevision, templateName string, revision int64) bool {
+	if revision == 0 {
+		return false
+	}
+
+	for i := range *revisions {
+		if (*revisions)[i].Name == templateName {
+			changed := (*revisions)[i].Revision != revision
+			(*revisions)[i].Revision = revision
+
+			return changed
+		}
+	}
+
+	*revisions = append(*revisions, otv1.AppliedRevision{Name: templateName, Revision: revision})
+
+	return true
+}
+
+// removeAppliedRevisions remove revisions of templates not used by parameters anymore
+func removeAppliedRevisions(revisions []otv1.AppliedRevision, spec otv1.ObjectTemplateParamsSpec) []otv1.AppliedRevision {
+	newRevisions := []otv1.AppliedRevision{}
+
+	for _, revision := range revisions {
+		if _, err := spec.GetParametersByTemplateName(revision.Name); err == nil {
+			newRevisions = append(newRevisions, revision)
+		}
+	}
+
+	return newRevisions
+}
@@ -0,0 +1,218 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Object revisions", func() {
+	Context("With template spec changes", func() {
+		It("Should change the revision hash", func() {
+			spec := otv1.ObjectTemplateSpec{Objects: []otv1.Object{{Name: "config", TemplateBody: "data: {}"}}}
+			hash, err := revisionHash(spec)
+			Expect(err).NotTo(HaveOccurred())
+
+			sameHash, _ := revisionHash(*spec.DeepCopy())
+			Expect(sameHash).To(Equal(hash))
+
+			spec.Objects[0].TemplateBody = "data: {a: b}"
+			newHash, _ := revisionHash(spec)
+			Expect(newHash).NotTo(Equal(hash))
+		})
+
+		It("Should ignore rollout and history settings", func() {
+			spec := otv1.ObjectTemplateSpec{Objects: []otv1.Object{{Name: "config"}}}
+			hash, _ := revisionHash(spec)
+
+			limit := int32(3)
+			rollbackTo := int64(1)
+			spec.Rollout = &otv1.RolloutStrategy{Paused: true}
+			spec.RevisionHistoryLimit = &limit
+			spec.RollbackTo = &rollbackTo
+			Expect(revisionHash(spec)).To(Equal(hash))
+		})
+
+		It("Should ignore operational settings", func() {
+			spec := otv1.ObjectTemplateSpec{Objects: []otv1.Object{{Name: "config"}}}
+			hash, _ := revisionHash(spec)
+
+			spec.Suspend = true
+			spec.DryRun = true
+			spec.ResyncPeriod = &metav1.Duration{Duration: time.Minute}
+			spec.ServiceAccountName = "deployer"
+			Expect(revisionHash(spec)).To(Equal(hash))
+		})
+	})
+
+	Context("With stored revisions", func() {
+		var scheme *runtime.Scheme
+		template := otv1.ObjectTemplate{ObjectMeta: metav1.ObjectMeta{Name: "template"}}
+		newRevision := func() otv1.ObjectTemplateRevision {
+			spec := otv1.ObjectTemplateSpec{Objects: []otv1.Object{{Name: "config", TemplateBody: "data: {}"}}}
+			hash, err := revisionHash(spec)
+			Expect(err).NotTo(HaveOccurred())
+
+			return otv1.ObjectTemplateRevision{
+				TypeMeta:   metav1.TypeMeta{APIVersion: otGV, Kind: revisionKind},
+				ObjectMeta: metav1.ObjectMeta{Name: revisionName("template", 1)},
+				Spec:       otv1.ObjectTemplateRevisionSpec{TemplateName: "template", Revision: 1, Hash: hash, Template: spec},
+			}
+		}
+
+		BeforeEach(func() {
+			scheme = runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(otv1.AddToScheme(scheme)).To(Succeed())
+		})
+
+		It("Should refuse revisions changed after they were recorded", func() {
+			revision := newRevision()
+			common := Common{Client: fake.NewFakeClientWithScheme(scheme, &revision)}
+
+			pinned, number, err := common.TemplateByRevision(template, 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(number).To(Equal(int64(1)))
+			Expect(pinned.Spec.Objects[0].TemplateBody).To(Equal("data: {}"))
+
+			revision.Spec.Template.Objects[0].TemplateBody = "data: {changed: true}"
+			Expect(common.Client.Update(context.Background(), &revision)).To(Succeed())
+
+			_, _, err = common.TemplateByRevision(template, 1)
+			Expect(err).To(MatchError(ContainSubstring("revision 1 of template template was modified")))
+		})
+
+		It("Should use live operational settings with pinned revisions", func() {
+			revision := newRevision()
+			common := Common{Client: fake.NewFakeClientWithScheme(scheme, &revision)}
+			live := *template.DeepCopy()
+			live.Spec.Suspend = true
+			live.Spec.DryRun = true
+			live.Spec.ResyncPeriod = &metav1.Duration{Duration: time.Minute}
+			live.Spec.ServiceAccountName = "deployer"
+			live.Generation = 7
+
+			pinned, _, err := common.TemplateByRevision(live, 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pinned.Generation).To(Equal(int64(7)))
+			Expect(pinned.Spec.Objects[0].TemplateBody).To(Equal("data: {}"))
+			Expect(pinned.Spec.Suspend).To(BeTrue())
+			Expect(pinned.Spec.DryRun).To(BeTrue())
+			Expect(pinned.Spec.ResyncPeriod.Duration).To(Equal(time.Minute))
+			Expect(pinned.Spec.ServiceAccountName).To(Equal("deployer"))
+		})
+
+		It("Should record revisions without operational settings", func() {
+			common := Common{Client: fake.NewFakeClientWithScheme(scheme), Log: ctrl.Log.WithName("test")}
+			live := *template.DeepCopy()
+			live.Spec.Objects = []otv1.Object{{Name: "config", TemplateBody: "data: {}"}}
+			live.Spec.Suspend = true
+			live.Spec.ServiceAccountName = "deployer"
+
+			number, err := common.EnsureRevision(live)
+			Expect(err).NotTo(HaveOccurred())
+
+			live.Spec.Suspend = false
+			Expect(common.EnsureRevision(live)).To(Equal(number))
+
+			revision := otv1.ObjectTemplateRevision{}
+			Expect(common.Client.Get(context.Background(), types.NamespacedName{Name: revisionName("template", number)}, &revision)).To(Succeed())
+			Expect(revision.Spec.Template.Suspend).To(BeFalse())
+			Expect(revision.Spec.Template.ServiceAccountName).To(BeEmpty())
+		})
+
+		It("Should deny revision spec updates in the webhook", func() {
+			decoder, err := admission.NewDecoder(scheme)
+			Expect(err).NotTo(HaveOccurred())
+			webhook := &PolicyWebhook{Log: ctrl.Log.WithName("test")}
+			Expect(webhook.InjectDecoder(decoder)).To(Succeed())
+
+			request := func(old, updated otv1.ObjectTemplateRevision) admission.Request {
+				oldRaw, err := json.Marshal(old)
+				Expect(err).NotTo(HaveOccurred())
+				raw, err := json.Marshal(updated)
+				Expect(err).NotTo(HaveOccurred())
+
+				return admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+					Kind:      metav1.GroupVersionKind{Group: otv1.GroupVersion.Group, Version: otv1.GroupVersion.Version, Kind: revisionKind},
+					Name:      old.Name,
+					Operation: admissionv1beta1.Update,
+					OldObject: runtime.RawExtension{Raw: oldRaw},
+					Object:    runtime.RawExtension{Raw: raw},
+				}}
+			}
+
+			labeled := newRevision()
+			labeled.Labels = map[string]string{"team": "platform"}
+			Expect(webhook.Handle(context.Background(), request(newRevision(), labeled)).Allowed).To(BeTrue())
+
+			changed := newRevision()
+			changed.Spec.Template.Description = "changed"
+			response := webhook.Handle(context.Background(), request(newRevision(), changed))
+			Expect(response.Allowed).To(BeFalse())
+			Expect(string(response.Result.Reason)).To(ContainSubstring("is immutable"))
+		})
+	})
+
+	Context("With applied revisions", func() {
+		It("Should record revision by template", func() {
+			revisions := []otv1.AppliedRevision{}
+
+			Expect(setAppliedRevision(&revisions, "template", 0)).To(BeFalse())
+			Expect(setAppliedRevision(&revisions, "template", 1)).To(BeTrue())
+			Expect(setAppliedRevision(&revisions, "template", 1)).To(BeFalse())
+			Expect(setAppliedRevision(&revisions, "template", 2)).To(BeTrue())
+			Expect(revisions).To(Equal([]otv1.AppliedRevision{{Name: "template", Revision: 2}}))
+		})
+
+		It("Should remove revisions of templates not used anymore", func() {
+			revisions := []otv1.AppliedRevision{{Name: "used", Revision: 1}, {Name: "removed", Revision: 3}}
+			spec := otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{{Name: "used"}}}
+
+			Expect(removeAppliedRevisions(revisions, spec)).To(Equal([]otv1.AppliedRevision{{Name: "used", Revision: 1}}))
+		})
+
+		It("Should keep revisions applied or pinned by parameters", func() {
+			otParams := []otv1.ObjectTemplateParams{
+				{
+					Spec:   otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{{Name: "template", Revision: "2"}}},
+					Status: otv1.ObjectTemplateParamsStatus{Revisions: []otv1.AppliedRevision{{Name: "template", Revision: 1}}},
+				},
+				{
+					Spec:   otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{{Name: "template", Revision: otv1.RevisionLatest}}},
+					Status: otv1.ObjectTemplateParamsStatus{Revisions: []otv1.AppliedRevision{{Name: "other", Revision: 4}}},
+				},
+			}
+
+			Expect(revisionsInUse("template", otParams)).To(Equal(map[int64]bool{1: true, 2: true}))
+		})
+	})
+})
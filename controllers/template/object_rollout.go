@@ -0,0 +1,169 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// rolloutTarget parameters following the latest template revision
+type rolloutTarget struct {
+	Key         string
+	Canary      bool
+	Updated     bool
+	Failed      bool
+	Progressing bool
+}
+
+// paramsKey unique key of parameters
+func paramsKey(otp otv1.ObjectTemplateParams) string {
+	return types.NamespacedName{Namespace: otp.Namespace, Name: otp.Name}.String()
+}
+
+// isCanaryNamespace namespace selected by rollout canary selector
+func (c *Common) isCanaryNamespace(selector *metav1.LabelSelector, namespaceName string) (bool, error) {
+	if selector == nil {
+		return false, nil
+	}
+
+	namespace := corev1.Namespace{}
+	if err := c.Client.Get(context.Background(), types.NamespacedName{Name: namespaceName}, &namespace); err != nil {
+		return false, err
+	}
+
+	return matchesNamespaceSelector(selector, namespace.Labels)
+}
+
+// batchSize number of parameters updated per batch
+func batchSize(strategy otv1.RolloutStrategy, total int) int {
+	if strategy.BatchSize > 0 {
+		return int(strategy.BatchSize)
+	}
+
+	if size := (total*int(strategy.BatchPercentage) + 99) / 100; size > 0 {
+		return size
+	}
+
+	return 1
+}
+
+// nextRolloutBatch parameters updated in the next batch (all canaries first, then sorted by key)
+func nextRolloutBatch(strategy otv1.RolloutStrategy, targets []rolloutTarget) map[string]bool {
+	batch := map[string]bool{}
+	targets = append([]rolloutTarget{}, targets...)
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Key < targets[j].Key
+	})
+
+	for _, target := range targets {
+		if target.Canary && !target.Updated {
+			batch[target.Key] = true
+		}
+	}
+
+	if len(batch) > 0 {
+		return batch
+	}
+
+	size := batchSize(strategy, len(targets))
+	for _, target := range targets {
+		if len(batch) == size {
+			break
+		}
+
+		if !target.Updated {
+			batch[target.Key] = true
+		}
+	}
+
+	return batch
+}
+
+// planRollout rollout status and parameters that must be updated to the latest revision now
+func planRollout(strategy otv1.RolloutStrategy, current *otv1.RolloutStatus, revision int64, targets []rolloutTarget, now time.Time) (otv1.RolloutStatus, map[string]bool, time.Duration) {
+	status := otv1.RolloutStatus{Revision: revision, Phase: otv1.RolloutPhaseProgressing, Total: int32(len(targets))}
+	halted := false
+
+	if current != nil && current.Revision == revision {
+		status.Batch = current.Batch
+		status.LastBatchTime = current.LastBatchTime
+		halted = current.Phase == otv1.RolloutPhaseHalted
+	}
+
+	progressing := false
+	for _, target := range targets {
+		if !target.Updated {
+			continue
+		}
+
+		status.Updated++
+		if target.Failed {
+			status.Failed++
+		}
+		progressing = progressing || target.Progressing
+	}
+
+	if status.Updated > 0 && status.Failed*100 > strategy.FailureThreshold*status.Updated {
+		halted = true
+	}
+
+	var pause time.Duration
+	if strategy.PauseBetweenBatches != nil {
+		pause = strategy.PauseBetweenBatches.Duration
+	}
+
+	switch {
+	case halted:
+		status.Phase = otv1.RolloutPhaseHalted
+		status.Message = fmt.Sprintf("%v of %v updated parameters failed (threshold %v%%)", status.Failed, status.Updated, strategy.FailureThreshold)
+		return status, nil, 0
+	case status.Updated == status.Total:
+		status.Phase = otv1.RolloutPhaseCompleted
+		return status, nil, 0
+	case strategy.Paused:
+		status.Phase = otv1.RolloutPhasePaused
+		return status, nil, 0
+	case strategy.HealthGate && progressing:
+		status.Message = "waiting for updated parameters to be healthy"
+		return status, nil, notReadyRequeuePeriod
+	case status.LastBatchTime != nil && now.Before(status.LastBatchTime.Add(pause)):
+		remaining := status.LastBatchTime.Add(pause).Sub(now)
+		status.Message = fmt.Sprintf("waiting %v before next batch", remaining.Round(time.Second))
+		return status, nil, remaining
+	}
+
+	batch := nextRolloutBatch(strategy, targets)
+	batchTime := metav1.NewTime(now)
+	status.Batch++
+	status.Updated += int32(len(batch))
+	status.LastBatchTime = &batchTime
+	status.Message = fmt.Sprintf("batch %v started with %v parameters", status.Batch, len(batch))
+
+	if pause > 0 {
+		return status, batch, pause
+	}
+
+	return status, batch, notReadyRequeuePeriod
+}
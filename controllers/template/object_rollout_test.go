@@ -0,0 +1,109 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Object rollout", func() {
+	now := time.Now()
+	targets := func(updated ...string) []rolloutTarget {
+		result := []rolloutTarget{{Key: "ns-a/params"}, {Key: "ns-b/params"}, {Key: "ns-c/params"}, {Key: "ns-d/params"}}
+		for i := range result {
+			for _, key := range updated {
+				if result[i].Key == key {
+					result[i].Updated = true
+				}
+			}
+		}
+		return result
+	}
+
+	Context("With batch size", func() {
+		It("Should use size, percentage or one", func() {
+			Expect(batchSize(otv1.RolloutStrategy{BatchSize: 2}, 10)).To(Equal(2))
+			Expect(batchSize(otv1.RolloutStrategy{BatchPercentage: 25}, 10)).To(Equal(3))
+			Expect(batchSize(otv1.RolloutStrategy{}, 10)).To(Equal(1))
+		})
+	})
+
+	Context("With a new revision", func() {
+		It("Should start the first batch", func() {
+			status, batch, _ := planRollout(otv1.RolloutStrategy{BatchSize: 2}, nil, 2, targets(), now)
+
+			Expect(batch).To(Equal(map[string]bool{"ns-a/params": true, "ns-b/params": true}))
+			Expect(status.Phase).To(Equal(otv1.RolloutPhaseProgressing))
+			Expect(status.Batch).To(BeEquivalentTo(1))
+			Expect(status.Updated).To(BeEquivalentTo(2))
+		})
+
+		It("Should update canaries first", func() {
+			canaries := targets()
+			canaries[3].Canary = true
+			_, batch, _ := planRollout(otv1.RolloutStrategy{BatchSize: 2}, nil, 2, canaries, now)
+
+			Expect(batch).To(Equal(map[string]bool{"ns-d/params": true}))
+		})
+	})
+
+	Context("With a rollout in progress", func() {
+		lastBatch := metav1.NewTime(now.Add(-time.Minute))
+		current := &otv1.RolloutStatus{Revision: 2, Phase: otv1.RolloutPhaseProgressing, Batch: 1, LastBatchTime: &lastBatch}
+
+		It("Should wait the pause between batches", func() {
+			strategy := otv1.RolloutStrategy{PauseBetweenBatches: &metav1.Duration{Duration: 5 * time.Minute}}
+			_, batch, requeue := planRollout(strategy, current, 2, targets("ns-a/params"), now)
+
+			Expect(batch).To(BeEmpty())
+			Expect(requeue).To(Equal(4 * time.Minute))
+		})
+
+		It("Should wait for healthy parameters with health gate", func() {
+			progressing := targets("ns-a/params")
+			progressing[0].Progressing = true
+			_, batch, _ := planRollout(otv1.RolloutStrategy{HealthGate: true}, current, 2, progressing, now)
+
+			Expect(batch).To(BeEmpty())
+		})
+
+		It("Should halt when failures exceed the threshold", func() {
+			failed := targets("ns-a/params", "ns-b/params")
+			failed[0].Failed = true
+
+			status, batch, _ := planRollout(otv1.RolloutStrategy{FailureThreshold: 50}, current, 2, failed, now)
+			Expect(batch).NotTo(BeEmpty())
+			Expect(status.Phase).To(Equal(otv1.RolloutPhaseProgressing))
+
+			status, batch, _ = planRollout(otv1.RolloutStrategy{}, current, 2, failed, now)
+			Expect(batch).To(BeEmpty())
+			Expect(status.Phase).To(Equal(otv1.RolloutPhaseHalted))
+		})
+
+		It("Should complete when all parameters are updated", func() {
+			status, batch, _ := planRollout(otv1.RolloutStrategy{}, current, 2, targets("ns-a/params", "ns-b/params", "ns-c/params", "ns-d/params"), now)
+
+			Expect(batch).To(BeEmpty())
+			Expect(status.Phase).To(Equal(otv1.RolloutPhaseCompleted))
+		})
+	})
+})
@@ -0,0 +1,373 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+var (
+	sourceKind    = reflect.TypeOf(otv1.TemplateSource{}).Name()
+	templateFiles = []string{".yaml", ".yml", ".json"}
+)
+
+// isTemplateFile file name with a template file extension
+func isTemplateFile(name string) bool {
+	for _, extension := range templateFiles {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// templateSourceFiles template files of a config map or directory by name
+func (c *Common) templateSourceFiles(source otv1.TemplateSource) (map[string][]byte, error) {
+	if source.Spec.ConfigMap != nil && len(source.Spec.Directory) > 0 {
+		return nil, fmt.Errorf("source must have only one of configMap or directory")
+	}
+
+	if source.Spec.ConfigMap != nil {
+		return c.configMapTemplateFiles(*source.Spec.ConfigMap)
+	}
+
+	if len(source.Spec.Directory) > 0 {
+		return directoryTemplateFiles(source.Spec.Directory)
+	}
+
+	return nil, fmt.Errorf("source must have a configMap or directory")
+}
+
+// configMapReader reader of source config maps (the manager client would cache every config map of the cluster)
+func (c *Common) configMapReader() client.Reader {
+	if c.ConfigMapReader != nil {
+		return c.ConfigMapReader
+	}
+
+	return c.Client
+}
+
+func (c *Common) configMapTemplateFiles(ref otv1.ConfigMapSourceRef) (map[string][]byte, error) {
+	configMap := corev1.ConfigMap{}
+
+	if err := c.configMapReader().Get(context.Background(), types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, &configMap); err != nil {
+		return nil, err
+	}
+
+	if configMap.Labels[otv1.SourceConfigMapLabel] != otv1.SourceConfigMapValue {
+		return nil, fmt.Errorf("config map %v/%v must have label %v=%v", ref.Namespace, ref.Name, otv1.SourceConfigMapLabel, otv1.SourceConfigMapValue)
+	}
+
+	files := map[string][]byte{}
+	for name, content := range configMap.Data {
+		if isTemplateFile(name) {
+			files[name] = []byte(content)
+		}
+	}
+
+	for name, content := range configMap.BinaryData {
+		if isTemplateFile(name) {
+			files[name] = content
+		}
+	}
+
+	return files, nil
+}
+
+// directoryTemplateFiles template files of a directory (hidden files, like config map volume internals, are ignored)
+func directoryTemplateFiles(directory string) (map[string][]byte, error) {
+	entries, err := ioutil.ReadDir(directory)
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{}
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if strings.HasPrefix(name, ".") || !isTemplateFile(name) {
+			continue
+		}
+
+		path := filepath.Join(directory, name)
+		// config map volumes link files to a data directory
+		info, err := os.Stat(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		if files[name], err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// decodeTemplateFiles templates of files (YAML files can have many documents)
+func decodeTemplateFiles(files map[string][]byte) ([]otv1.ObjectTemplate, error) {
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	templates := []otv1.ObjectTemplate{}
+	found := map[string]string{}
+	for _, name := range names {
+		decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(files[name]), 4096)
+
+		for document := 1; ; document++ {
+			ot := otv1.ObjectTemplate{}
+			err := decoder.Decode(&ot)
+
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("%v (document %v): %w", name, document, err)
+			}
+
+			// empty documents
+			if len(ot.Kind) == 0 && len(ot.APIVersion) == 0 && len(ot.Name) == 0 {
+				continue
+			}
+
+			if ot.APIVersion != otGV || ot.Kind != templateKind {
+				return nil, fmt.Errorf("%v (document %v): expected %v %v, found %v %v", name, document, otGV, templateKind, ot.APIVersion, ot.Kind)
+			}
+
+			if len(ot.Name) == 0 {
+				return nil, fmt.Errorf("%v (document %v): template must have a name", name, document)
+			}
+
+			if previous, duplicated := found[ot.Name]; duplicated {
+				return nil, fmt.Errorf("%v (document %v): template %v already defined in %v", name, document, ot.Name, previous)
+			}
+			found[ot.Name] = name
+
+			templates = append(templates, ot)
+		}
+	}
+
+	return templates, nil
+}
+
+// isManagedBySource template created by source
+func isManagedBySource(ot otv1.ObjectTemplate, source otv1.TemplateSource) bool {
+	return isOwnedBySource(ot, source) || ot.Labels[otv1.SourceLabel] == source.Name
+}
+
+// isOwnedBySource template with an owner reference to source
+func isOwnedBySource(ot otv1.ObjectTemplate, source otv1.TemplateSource) bool {
+	for _, ref := range ot.OwnerReferences {
+		if ref.UID == source.UID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sourceOwnerReference owner reference to source. It isn't a controller reference, so templates can be orphaned and
+// changed by other controllers (e.g. rollbacks)
+func sourceOwnerReference(source otv1.TemplateSource) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: otv1.GroupVersion.String(),
+		Kind:       sourceKind,
+		Name:       source.Name,
+		UID:        source.UID,
+	}
+}
+
+// getSourceDeletionPolicy source deletion policy or default
+func getSourceDeletionPolicy(source otv1.TemplateSource) otv1.DeletionPolicy {
+	if len(source.Spec.DeletionPolicy) > 0 {
+		return source.Spec.DeletionPolicy
+	}
+
+	return otv1.DeletionPolicyOrphan
+}
+
+// SyncTemplateSource create or update templates of source, reverting changes made to them, and return the names of source templates
+func (c *Common) SyncTemplateSource(source otv1.TemplateSource) ([]string, error) {
+	files, err := c.templateSourceFiles(source)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error reading source: %w", err)
+	}
+
+	templates, err := decodeTemplateFiles(files)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding templates: %w", err)
+	}
+
+	lu := LogUtil{Log: c.Log}
+	names := []string{}
+	for _, desired := range templates {
+		names = append(names, desired.Name)
+
+		if err := c.syncSourceTemplate(source, desired); err != nil {
+			lu.Error(err, fmt.Sprintf("Failed to sync template %v", desired.Name))
+		}
+	}
+
+	if source.Spec.Prune {
+		if err := c.pruneSourceTemplates(source, names); err != nil {
+			lu.Error(err, "Failed to prune templates")
+		}
+	}
+
+	return names, lu.AllErrors()
+}
+
+// syncSourceTemplate create or update a template with the spec, labels and annotations from source
+func (c *Common) syncSourceTemplate(source otv1.TemplateSource, desired otv1.ObjectTemplate) error {
+	ot := otv1.ObjectTemplate{ObjectMeta: metav1.ObjectMeta{Name: desired.Name}}
+
+	res, err := controllerutil.CreateOrUpdate(context.Background(), c.Client, &ot, func() error {
+		if len(ot.ResourceVersion) > 0 && !isManagedBySource(ot, source) {
+			return fmt.Errorf("template %v already exists and is not managed by source %v", ot.Name, source.Name)
+		}
+
+		labels := copyMap(ot.Labels)
+		for k, v := range desired.Labels {
+			labels[k] = v
+		}
+		labels[otv1.SourceLabel] = source.Name
+		ot.Labels = labels
+
+		annotations := copyMap(ot.Annotations)
+		for k, v := range desired.Annotations {
+			annotations[k] = v
+		}
+		ot.Annotations = annotations
+
+		// controller references of previous versions are replaced
+		ot.OwnerReferences = mergeOwnerReferences(ot.OwnerReferences, []metav1.OwnerReference{sourceOwnerReference(source)})
+		// rollbackTo, suspend and dryRun are changed on the cluster after the template is created
+		live := ot.Spec
+		ot.Spec = desired.Spec
+		if len(ot.ResourceVersion) > 0 {
+			ot.Spec.RollbackTo = live.RollbackTo
+			ot.Spec.Suspend = live.Suspend
+			ot.Spec.DryRun = live.DryRun
+		}
+
+		return nil
+	})
+
+	if err == nil && res != controllerutil.OperationResultNone {
+		c.Log.Info(fmt.Sprintf("Template %v %v from source", ot.Name, res))
+	}
+
+	return err
+}
+
+// pruneSourceTemplates delete templates of source not found in its files
+func (c *Common) pruneSourceTemplates(source otv1.TemplateSource, names []string) error {
+	otList := otv1.ObjectTemplateList{}
+
+	if err := c.Client.List(context.Background(), &otList, client.MatchingLabels{otv1.SourceLabel: source.Name}); err != nil {
+		return err
+	}
+
+	current := map[string]bool{}
+	for _, name := range names {
+		current[name] = true
+	}
+
+	for _, ot := range otList.Items {
+		if current[ot.Name] || !isOwnedBySource(ot, source) {
+			continue
+		}
+
+		if err := c.Client.Delete(context.Background(), &ot); err != nil && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+		c.Log.Info(fmt.Sprintf("Template %v removed from source, deleted", ot.Name))
+	}
+
+	return nil
+}
+
+// FinalizeTemplateSource apply source deletion policy to its templates. Orphaned templates lose the source owner
+// reference and label, otherwise they would be garbage collected with the source
+func (c *Common) FinalizeTemplateSource(source otv1.TemplateSource) error {
+	otList := otv1.ObjectTemplateList{}
+
+	if err := c.Client.List(context.Background(), &otList, client.MatchingLabels{otv1.SourceLabel: source.Name}); err != nil {
+		return err
+	}
+
+	policy := getSourceDeletionPolicy(source)
+	lu := LogUtil{Log: c.Log}
+	for _, ot := range otList.Items {
+		if !isOwnedBySource(ot, source) {
+			continue
+		}
+
+		if policy == otv1.DeletionPolicyDelete {
+			if err := c.Client.Delete(context.Background(), &ot); err != nil && !k8sErrors.IsNotFound(err) {
+				lu.Error(err, fmt.Sprintf("Failed to delete template %v", ot.Name))
+				continue
+			}
+			c.Log.Info(fmt.Sprintf("Template %v deleted with source", ot.Name))
+			continue
+		}
+
+		ot.OwnerReferences = removeOwnerReference(ot.OwnerReferences, source.UID)
+		labels := copyMap(ot.Labels)
+		delete(labels, otv1.SourceLabel)
+		ot.Labels = labels
+
+		if err := c.Client.Update(context.Background(), &ot); err != nil && !k8sErrors.IsNotFound(err) {
+			lu.Error(err, fmt.Sprintf("Failed to orphan template %v", ot.Name))
+			continue
+		}
+		c.Log.Info(fmt.Sprintf("Template %v orphaned from source", ot.Name))
+	}
+
+	return lu.AllErrors()
+}
@@ -0,0 +1,219 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	networkTemplateFile = `
+apiVersion: template.k8s.ericogr.com.br/v1
+kind: ObjectTemplate
+metadata:
+  name: network
+  labels:
+    team: platform
+spec:
+  description: network policies
+  parameters:
+  - name: cidr
+    default: 10.0.0.0/8
+---
+apiVersion: template.k8s.ericogr.com.br/v1
+kind: ObjectTemplate
+metadata:
+  name: quota
+spec:
+  parameters: []
+`
+	storageTemplateFile = `{"apiVersion":"template.k8s.ericogr.com.br/v1","kind":"ObjectTemplate","metadata":{"name":"storage"},"spec":{"parameters":[]}}`
+)
+
+var _ = Describe("Template sources", func() {
+	var common Common
+	var configMap *corev1.ConfigMap
+	templateSource := otv1.TemplateSource{
+		ObjectMeta: metav1.ObjectMeta{Name: "platform", UID: "source-uid"},
+		Spec: otv1.TemplateSourceSpec{
+			ConfigMap: &otv1.ConfigMapSourceRef{Name: "templates", Namespace: "platform"},
+			Prune:     true,
+		},
+	}
+	getTemplate := func(name string) (otv1.ObjectTemplate, error) {
+		ot := otv1.ObjectTemplate{}
+		err := common.Client.Get(context.Background(), types.NamespacedName{Name: name}, &ot)
+		return ot, err
+	}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(otv1.AddToScheme(scheme)).To(Succeed())
+
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "templates", Namespace: "platform", Labels: map[string]string{otv1.SourceConfigMapLabel: otv1.SourceConfigMapValue}},
+			Data: map[string]string{
+				"network.yaml": networkTemplateFile,
+				"storage.json": storageTemplateFile,
+				"README.md":    "not a template",
+			},
+		}
+		common = Common{Client: fake.NewFakeClientWithScheme(scheme, configMap), Log: ctrl.Log.WithName("test")}
+	})
+
+	It("Should create templates marked as source-managed and revert manual changes", func() {
+		names, err := common.SyncTemplateSource(templateSource)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names).To(Equal([]string{"network", "quota", "storage"}))
+
+		network, err := getTemplate("network")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(network.Labels).To(Equal(map[string]string{"team": "platform", otv1.SourceLabel: "platform"}))
+		Expect(isOwnedBySource(network, templateSource)).To(BeTrue())
+		Expect(metav1.GetControllerOf(&network)).To(BeNil())
+		Expect(network.Spec.Parameters).To(Equal([]otv1.Parameter{{Name: "cidr", Default: "10.0.0.0/8"}}))
+
+		network.Spec.Description = "changed"
+		Expect(common.Client.Update(context.Background(), &network)).To(Succeed())
+
+		_, err = common.SyncTemplateSource(templateSource)
+		Expect(err).NotTo(HaveOccurred())
+
+		network, err = getTemplate("network")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(network.Spec.Description).To(Equal("network policies"))
+	})
+
+	It("Should keep rollbackTo, suspend and dryRun changed on the cluster", func() {
+		_, err := common.SyncTemplateSource(templateSource)
+		Expect(err).NotTo(HaveOccurred())
+
+		network, err := getTemplate("network")
+		Expect(err).NotTo(HaveOccurred())
+		revision := int64(1)
+		network.Spec.RollbackTo = &revision
+		network.Spec.Suspend = true
+		network.Spec.DryRun = true
+		Expect(common.Client.Update(context.Background(), &network)).To(Succeed())
+
+		_, err = common.SyncTemplateSource(templateSource)
+		Expect(err).NotTo(HaveOccurred())
+
+		network, err = getTemplate("network")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(network.Spec.RollbackTo).To(Equal(&revision))
+		Expect(network.Spec.Suspend).To(BeTrue())
+		Expect(network.Spec.DryRun).To(BeTrue())
+	})
+
+	It("Should not read config maps without the source label", func() {
+		configMap.Labels = nil
+		Expect(common.Client.Update(context.Background(), configMap)).To(Succeed())
+
+		_, err := common.SyncTemplateSource(templateSource)
+		Expect(err).To(MatchError(ContainSubstring(otv1.SourceConfigMapLabel)))
+
+		_, err = getTemplate("network")
+		Expect(k8sErrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("Should prune templates removed from source", func() {
+		_, err := common.SyncTemplateSource(templateSource)
+		Expect(err).NotTo(HaveOccurred())
+
+		delete(configMap.Data, "storage.json")
+		Expect(common.Client.Update(context.Background(), configMap)).To(Succeed())
+
+		names, err := common.SyncTemplateSource(templateSource)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names).To(Equal([]string{"network", "quota"}))
+
+		_, err = getTemplate("storage")
+		Expect(k8sErrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("Should replace controller references of source", func() {
+		controlled := otv1.ObjectTemplate{ObjectMeta: metav1.ObjectMeta{
+			Name:            "quota",
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(&templateSource, otv1.GroupVersion.WithKind(sourceKind))},
+		}}
+		Expect(common.Client.Create(context.Background(), &controlled)).To(Succeed())
+
+		_, err := common.SyncTemplateSource(templateSource)
+		Expect(err).NotTo(HaveOccurred())
+
+		quota, err := getTemplate("quota")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quota.OwnerReferences).To(Equal([]metav1.OwnerReference{sourceOwnerReference(templateSource)}))
+	})
+
+	It("Should not take over templates not managed by source", func() {
+		unmanaged := otv1.ObjectTemplate{ObjectMeta: metav1.ObjectMeta{Name: "quota"}, Spec: otv1.ObjectTemplateSpec{Description: "manual"}}
+		Expect(common.Client.Create(context.Background(), &unmanaged)).To(Succeed())
+
+		_, err := common.SyncTemplateSource(templateSource)
+		Expect(err).To(MatchError(ContainSubstring("not managed by source platform")))
+
+		quota, err := getTemplate("quota")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(quota.Spec.Description).To(Equal("manual"))
+
+		_, err = getTemplate("network")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Should report files with invalid documents", func() {
+		_, err := decodeTemplateFiles(map[string][]byte{"policies.yaml": []byte(networkTemplateFile + "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: other\n")})
+		Expect(err).To(MatchError(ContainSubstring("policies.yaml (document 3)")))
+
+		_, err = decodeTemplateFiles(map[string][]byte{"a.yaml": []byte(networkTemplateFile), "b.yaml": []byte(networkTemplateFile)})
+		Expect(err).To(MatchError(ContainSubstring("already defined in a.yaml")))
+	})
+
+	It("Should read template files of a directory", func() {
+		directory, err := ioutil.TempDir("", "templates")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(directory)
+
+		// config map volume layout
+		Expect(os.Mkdir(filepath.Join(directory, "..data"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(directory, "..data", "network.yaml"), []byte(networkTemplateFile), 0644)).To(Succeed())
+		Expect(os.Symlink(filepath.Join("..data", "network.yaml"), filepath.Join(directory, "network.yaml"))).To(Succeed())
+		Expect(os.Mkdir(filepath.Join(directory, "nested.yaml"), 0755)).To(Succeed())
+
+		files, err := directoryTemplateFiles(directory)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		Expect(files).To(HaveKey("network.yaml"))
+	})
+})
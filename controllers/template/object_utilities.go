@@ -18,9 +18,9 @@ package controllers
 
 import (
 	"strings"
-	"text/template"
 
-	"github.com/Masterminds/sprig"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func getStringObject(apiVersion string, kind string, templateBody string) string {
@@ -38,20 +38,12 @@ func getStringObject(apiVersion string, kind string, templateBody string) string
 	return sb.String()
 }
 
-func executeTemplate(templateYAML string, values map[string]string) (string, error) {
-	fmap := sprig.TxtFuncMap()
-	compiledTemplate, err := template.New("template").Funcs(fmap).Parse(templateYAML)
-
-	if err != nil {
-		return "", err
-	}
-
-	sb := strings.Builder{}
-	err = compiledTemplate.Execute(&sb, values)
-
-	if err != nil {
-		return "", err
-	}
+func executeTemplate(templateYAML string, values map[string]interface{}) (string, error) {
+	return renderTemplate("template", templateYAML, values, templateOptions, nil)
+}
 
-	return sb.String(), nil
+// setStatusCondition set condition updating its observed generation (meta.SetStatusCondition keeps the old one)
+func setStatusCondition(conditions *[]metav1.Condition, condition metav1.Condition) {
+	meta.SetStatusCondition(conditions, condition)
+	meta.FindStatusCondition(*conditions, condition.Type).ObservedGeneration = condition.ObservedGeneration
 }
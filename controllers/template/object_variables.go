@@ -0,0 +1,73 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// renderContext template, parameters and namespace used to render objects
+type renderContext struct {
+	template  otv1.ObjectTemplate
+	params    otv1.ObjectTemplateParams
+	namespace corev1.Namespace
+}
+
+// renderingFor copy of common rendering template using parameters (records lookups and adds runtime variables)
+func (c *Common) renderingFor(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) (*Common, error) {
+	rendering := c.recordingLookups()
+	namespace := corev1.Namespace{}
+
+	if err := c.Client.Get(context.Background(), types.NamespacedName{Name: otp.Namespace}, &namespace); err != nil {
+		return nil, fmt.Errorf("Error getting namespace %v: %w", otp.Namespace, err)
+	}
+	rendering.recorder.record(lookupRef{GVK: namespaceGVK, Name: otp.Namespace})
+	rendering.rendering = &renderContext{template: ot, params: otp, namespace: namespace}
+
+	return rendering, nil
+}
+
+// addRuntimeVariables add template, parameters and namespace runtime variables
+func (r *renderContext) addRuntimeVariables(values map[string]interface{}) {
+	values[prefix+"namespaceLabels"] = nonNilMap(r.namespace.Labels)
+	values[prefix+"namespaceAnnotations"] = nonNilMap(r.namespace.Annotations)
+	values[prefix+"paramsName"] = r.params.Name
+	values[prefix+"paramsLabels"] = nonNilMap(r.params.Labels)
+	values[prefix+"templateName"] = r.template.Name
+	// live template generation, also when parameters apply a pinned or held revision
+	values[prefix+"templateGeneration"] = r.template.Generation
+	values[prefix+"clusterName"] = templateOptions.ClusterName
+	values[prefix+"instance"] = instanceName(r.template, r.params)
+}
+
+// instanceName unique name of a template applied by parameters in a namespace
+func instanceName(ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) string {
+	return fmt.Sprintf("%v-%v", otp.Name, ot.Name)
+}
+
+func nonNilMap(values map[string]string) map[string]string {
+	if values == nil {
+		return map[string]string{}
+	}
+
+	return values
+}
@@ -0,0 +1,58 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Runtime variables", func() {
+	template := otv1.ObjectTemplate{ObjectMeta: metav1.ObjectMeta{Name: "network", Generation: 3}}
+	params := otv1.ObjectTemplateParams{ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "team-a", Labels: map[string]string{"tier": "web"}}}
+	common := Common{Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"cost-center": "42"}}},
+	)}
+
+	It("Should add template, parameters and namespace variables", func() {
+		previous := templateOptions
+		defer SetTemplateOptions(previous)
+		SetTemplateOptions(TemplateOptions{ClusterName: "east"})
+
+		rendering, err := common.renderingFor(template, params)
+		Expect(err).NotTo(HaveOccurred())
+
+		values := rendering.addRuntimeVariablesToMap(map[string]string{}, otv1.Object{Name: "policy"}, "team-a")
+		Expect(values).To(HaveKeyWithValue("__namespaceLabels", map[string]string{"cost-center": "42"}))
+		Expect(values).To(HaveKeyWithValue("__namespaceAnnotations", map[string]string{}))
+		Expect(values).To(HaveKeyWithValue("__paramsName", "params"))
+		Expect(values).To(HaveKeyWithValue("__paramsLabels", map[string]string{"tier": "web"}))
+		Expect(values).To(HaveKeyWithValue("__templateName", "network"))
+		Expect(values).To(HaveKeyWithValue("__templateGeneration", int64(3)))
+		Expect(values).To(HaveKeyWithValue("__clusterName", "east"))
+		Expect(values).To(HaveKeyWithValue("__instance", "params-network"))
+
+		output, err := executeTemplate(`{{ index .__namespaceLabels "cost-center" }}/{{ .__clusterName }}`, values)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output).To(Equal("42/east"))
+	})
+})
@@ -18,14 +18,19 @@ package controllers
 
 import (
 	"context"
-	"reflect"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
@@ -34,8 +39,13 @@ import (
 // ObjectTemplateReconciler ot reconciler
 type ObjectTemplateReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log                  logr.Logger
+	Scheme               *runtime.Scheme
+	ResyncPeriod         time.Duration
+	RevisionHistoryLimit int32
+	Mapper               meta.RESTMapper
+	Impersonator         *Impersonator
+	Lookups              *LookupTracker
 }
 
 // SetupWithManager setup
@@ -55,13 +65,14 @@ func (r *ObjectTemplateReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 	log := r.Log.WithValues("objecttemplate", otGV)
 	var objectTemplate otv1.ObjectTemplate
 	err := r.Get(ctx, req.NamespacedName, &objectTemplate)
-	common := Common{r.Client, log}
+	common := Common{Client: r.Client, Log: log, Mapper: r.Mapper, Impersonator: r.Impersonator, Lookups: r.Lookups}
 
 	if err != nil {
 		objectTemplate.Status.Status = err.Error()
 
 		if k8sErrors.IsNotFound(err) {
 			// Object not found, return. Created objects are automatically garbage collected
+			r.Lookups.ForgetTemplate(req.Name)
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
 
@@ -69,8 +80,47 @@ func (r *ObjectTemplateReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 		return ctrl.Result{}, err
 	}
 
+	if !objectTemplate.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, common, &objectTemplate)
+	}
+
+	if !controllerutil.ContainsFinalizer(&objectTemplate, otv1.Finalizer) {
+		controllerutil.AddFinalizer(&objectTemplate, otv1.Finalizer)
+
+		if err := r.Update(ctx, &objectTemplate); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	defer common.UpdateStatus(ctx, &objectTemplate)
 
+	rollbackTo := objectTemplate.Spec.RollbackTo
+	if rollbackTo != nil {
+		restored, err := r.rollback(ctx, common, &objectTemplate)
+
+		if err != nil {
+			objectTemplate.Status.Status = err.Error()
+			return ctrl.Result{}, err
+		}
+
+		if !restored {
+			rollbackTo = nil
+		}
+	}
+
+	revision, err := common.EnsureRevision(objectTemplate)
+
+	if err != nil {
+		objectTemplate.Status.Status = err.Error()
+		return ctrl.Result{}, err
+	}
+	objectTemplate.Status.Revision = revision
+
+	if objectTemplate.Spec.Suspend && !objectTemplate.Spec.DryRun {
+		objectTemplate.Status.Status = "Suspended"
+		return ctrl.Result{}, nil
+	}
+
 	otParams, err := common.FindObjectTemplateParamsByTemplateName(objectTemplate.Name)
 
 	if err != nil {
@@ -79,29 +129,310 @@ func (r *ObjectTemplateReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 	}
 
 	lu := LogUtil{Log: log}
+	state := &templateState{namespaces: []otv1.ObjectsHealth{}, waiting: []string{}}
+	rollout := objectTemplate.Spec.Rollout != nil && !objectTemplate.Spec.DryRun && rollbackTo == nil
+	targets := []rolloutTarget{}
+	held := []heldParams{}
 	for _, otParam := range otParams {
-		paramNamespace := otParam.Namespace
-		paramValues, err := otParam.Spec.GetParametersByTemplateName(objectTemplate.Name)
+		// objects of parameters being deleted are handled by their finalizer
+		if !otParam.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		ot, revision, err := common.TemplateByParams(r.templateForParams(objectTemplate, rollout), otParam)
 
 		if err != nil {
-			lu.Error(err, "Error getting parameters by template name")
+			lu.Error(err, "Failed to get template revision")
 			continue
 		}
 
-		kind := reflect.TypeOf(otv1.ObjectTemplateParams{}).Name()
-		gvk := otv1.GroupVersion.WithKind(kind)
-		controllerRef := metav1.NewControllerRef(otParam.GetObjectMeta(), gvk)
+		if objectTemplate.Spec.DryRun || otParam.Spec.DryRun {
+			preview, err := common.PreviewObjectsByTemplate(ot, otParam)
+			state.previews = append(state.previews, summarizePreviews(otParam, preview))
 
-		if err := common.UpdateObjectsByTemplate(objectTemplate, []metav1.OwnerReference{*controllerRef}, paramNamespace, paramValues.Values); err != nil {
-			lu.Error(err, "Failed to update ObjectTemplate")
+			if err != nil {
+				lu.Error(err, "Failed to preview ObjectTemplate")
+			}
 			continue
 		}
+
+		if otParam.Spec.Suspend {
+			log.Info("Parameters suspended, skipping", "namespace", otParam.Namespace, "name", otParam.Name)
+			continue
+		}
+
+		if !rollout || !followsLatestRevision(otParam, objectTemplate.Name) {
+			r.applyTemplate(ctx, common, &lu, state, ot, revision, otParam)
+			continue
+		}
+
+		target := rolloutTarget{Key: paramsKey(otParam), Updated: revision == objectTemplate.Status.Revision}
+		if target.Canary, err = common.isCanaryNamespace(objectTemplate.Spec.Rollout.CanarySelector, otParam.Namespace); err != nil {
+			lu.Error(err, "Failed to select canary namespaces")
+		}
+
+		if target.Updated {
+			outcome := r.applyTemplate(ctx, common, &lu, state, ot, revision, otParam)
+			target.Failed = outcome.failed
+			target.Progressing = outcome.progressing
+		} else {
+			held = append(held, heldParams{key: target.Key, ot: ot, revision: revision, otParam: otParam})
+		}
+		targets = append(targets, target)
+	}
+
+	var rolloutRequeue time.Duration
+	if rollout {
+		status, batch, requeue := planRollout(*objectTemplate.Spec.Rollout, objectTemplate.Status.Rollout, objectTemplate.Status.Revision, targets, time.Now())
+		rolloutRequeue = requeue
+
+		for _, h := range held {
+			if batch[h.key] {
+				log.Info("Rolling out revision", "revision", objectTemplate.Status.Revision, "namespace", h.otParam.Namespace, "name", h.otParam.Name)
+				// latest revision resolved like other parameters (chart objects are rendered by TemplateByParams)
+				latest, revision, err := common.TemplateByParams(r.templateForParams(objectTemplate, false), h.otParam)
+
+				if err != nil {
+					lu.Error(err, "Failed to get template revision")
+					continue
+				}
+				h.ot, h.revision = latest, revision
+			}
+
+			r.applyTemplate(ctx, common, &lu, state, h.ot, h.revision, h.otParam)
+		}
+
+		objectTemplate.Status.Rollout = &status
+	} else if rollbackTo != nil && objectTemplate.Spec.Rollout != nil {
+		objectTemplate.Status.Rollout = &otv1.RolloutStatus{
+			Revision: revision,
+			Phase:    otv1.RolloutPhaseCompleted,
+			Message:  fmt.Sprintf("rolled back to revision %v", *rollbackTo),
+		}
+	} else {
+		objectTemplate.Status.Rollout = nil
+	}
+
+	if err := common.PruneRevisions(objectTemplate.Name, r.getRevisionHistoryLimit(objectTemplate), revisionsInUse(objectTemplate.Name, otParams)); err != nil {
+		lu.Error(err, "Failed to prune revisions")
 	}
 
+	objectTemplate.Status.Preview = state.previews
+	objectTemplate.Status.Namespaces = state.namespaces
 	objectTemplate.Status.Status = "OK"
+	if objectTemplate.Spec.DryRun {
+		objectTemplate.Status.Status = "DryRun"
+	}
+	if len(state.waiting) > 0 {
+		objectTemplate.Status.Status = strings.Join(state.waiting, "\n")
+	}
 	if lu.HasError() {
 		objectTemplate.Status.Status = lu.AllErrorsMessages()
 	}
 
-	return ctrl.Result{Requeue: false}, nil
+	result := ctrl.Result{RequeueAfter: r.getResyncPeriod(objectTemplate)}
+	if len(state.waiting) > 0 {
+		result.RequeueAfter = requeueNotReady(result.RequeueAfter)
+	}
+	if rolloutRequeue > 0 && (result.RequeueAfter == 0 || rolloutRequeue < result.RequeueAfter) {
+		result.RequeueAfter = rolloutRequeue
+	}
+
+	return result, nil
+}
+
+// templateState status collected while applying a template to all parameters
+type templateState struct {
+	previews   []otv1.ObjectPreviewSummary
+	waiting    []string
+	namespaces []otv1.ObjectsHealth
+}
+
+// paramsOutcome result of applying a template to parameters
+type paramsOutcome struct {
+	failed      bool
+	progressing bool
+}
+
+// heldParams parameters kept at a previous revision until rollout reaches them
+type heldParams struct {
+	key      string
+	ot       otv1.ObjectTemplate
+	revision int64
+	otParam  otv1.ObjectTemplateParams
+}
+
+// applyTemplate apply template revision to parameters and collect status
+func (r *ObjectTemplateReconciler) applyTemplate(ctx context.Context, common Common, lu *LogUtil, state *templateState, ot otv1.ObjectTemplate, revision int64, otParam otv1.ObjectTemplateParams) paramsOutcome {
+	outcome := paramsOutcome{}
+	err := common.UpdateObjectsByTemplate(ot, otParam)
+
+	if health, _, healthErr := common.HealthByTemplate(ot, otParam, err); healthErr == nil {
+		state.namespaces = addObjectsHealth(state.namespaces, health)
+		outcome.failed = health.Failed > 0
+		outcome.progressing = health.InProgress > 0
+	} else {
+		common.Log.Error(healthErr, "Failed to get objects health", "namespace", otParam.Namespace)
+	}
+
+	statusChanged := setAppliedRevision(&otParam.Status.Revisions, ot.Name, revision)
+
+	if err != nil {
+		var notReady *NotReadyError
+		if errors.As(err, &notReady) {
+			common.Log.Info(notReady.Error(), "namespace", otParam.Namespace)
+			state.waiting = append(state.waiting, fmt.Sprintf("%v namespace: %v", otParam.Namespace, notReady.Error()))
+			outcome.progressing = true
+		}
+
+		if isConflictError(err) {
+			setConflictCondition(&otParam.Status.Conditions, otParam.Generation, []string{fmt.Sprintf("%v: %v", ot.Name, err.Error())})
+			statusChanged = true
+		}
+
+		if err := failedObjectsError(err); err != nil {
+			lu.Error(err, "Failed to update ObjectTemplate")
+			outcome.failed = true
+		}
+	}
+
+	if statusChanged {
+		common.UpdateStatus(ctx, &otParam)
+	}
+
+	return outcome
+}
+
+// finalize apply deletion policy to objects in all namespaces and remove finalizer (finalizer is kept while template or parameters are suspended)
+func (r *ObjectTemplateReconciler) finalize(ctx context.Context, common Common, ot *otv1.ObjectTemplate) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(ot, otv1.Finalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	if ot.Spec.Suspend {
+		ot.Status.Status = "Suspended, deletion waits until template is resumed"
+		common.UpdateStatus(ctx, ot)
+		return ctrl.Result{}, nil
+	}
+
+	otParams, err := common.FindAllObjectTemplateParamsByTemplateName(ot.Name)
+
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	lu := LogUtil{Log: common.Log}
+	suspended := []string{}
+	for _, otParam := range otParams {
+		if otParam.Spec.Suspend {
+			suspended = append(suspended, paramsKey(otParam))
+			continue
+		}
+
+		applied, _, err := common.TemplateByParams(*ot, otParam)
+
+		if err != nil {
+			common.Log.Error(err, "Failed to get template revision, using current template", "namespace", otParam.Namespace)
+		}
+
+		if err := common.CleanupObjectsByTemplate(applied, otParam); err != nil {
+			lu.Error(err, "Failed to cleanup objects")
+		}
+	}
+
+	if lu.HasError() {
+		return ctrl.Result{}, lu.AllErrors()
+	}
+
+	// parameters changes don't trigger template reconcile
+	if len(suspended) > 0 {
+		ot.Status.Status = fmt.Sprintf("Deletion waits until parameters are resumed: %v", strings.Join(suspended, ", "))
+		common.UpdateStatus(ctx, ot)
+		return ctrl.Result{RequeueAfter: requeueNotReady(0)}, nil
+	}
+
+	common.Lookups.ForgetTemplate(ot.Name)
+	controllerutil.RemoveFinalizer(ot, otv1.Finalizer)
+
+	return ctrl.Result{}, r.Update(ctx, ot)
+}
+
+// rollback restore template spec from a revision keeping live settings (see keepLiveSettings) and return if it was restored.
+// Revisions that can't be restored (e.g. pruned) are reported in the RolledBack condition and rollbackTo is removed,
+// so the template keeps being reconciled
+func (r *ObjectTemplateReconciler) rollback(ctx context.Context, common Common, ot *otv1.ObjectTemplate) (bool, error) {
+	number := *ot.Spec.RollbackTo
+	restored, _, err := common.TemplateByRevision(*ot, number)
+
+	var apiStatus k8sErrors.APIStatus
+	if err != nil && errors.As(err, &apiStatus) {
+		return false, fmt.Errorf("Error rolling back: %w", err)
+	}
+
+	if err != nil {
+		ot.Spec.RollbackTo = nil
+		common.Log.Info(fmt.Sprintf("Unable to roll back to revision %v: %v", number, err))
+
+		if err := r.Update(ctx, ot); err != nil {
+			return false, err
+		}
+
+		setStatusCondition(&ot.Status.Conditions, metav1.Condition{
+			Type:               otv1.ConditionRolledBack,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: ot.Generation,
+			Reason:             "RollbackFailed",
+			Message:            err.Error(),
+		})
+
+		return false, nil
+	}
+
+	ot.Spec = restored.Spec
+	ot.Spec.RollbackTo = nil
+	common.Log.Info(fmt.Sprintf("Rolling back to revision %v", number))
+
+	if err := r.Update(ctx, ot); err != nil {
+		return false, err
+	}
+
+	setStatusCondition(&ot.Status.Conditions, metav1.Condition{
+		Type:               otv1.ConditionRolledBack,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: ot.Generation,
+		Reason:             "RevisionRestored",
+		Message:            fmt.Sprintf("rolled back to revision %v", number),
+	})
+
+	return true, nil
+}
+
+// templateForParams template used to select parameters revision (rollout is ignored when disabled for this reconcile)
+func (r *ObjectTemplateReconciler) templateForParams(ot otv1.ObjectTemplate, rollout bool) otv1.ObjectTemplate {
+	if rollout || ot.Spec.Rollout == nil {
+		return ot
+	}
+
+	withoutRollout := *ot.DeepCopy()
+	withoutRollout.Spec.Rollout = nil
+
+	return withoutRollout
+}
+
+// getRevisionHistoryLimit template revision history limit or operator default
+func (r *ObjectTemplateReconciler) getRevisionHistoryLimit(ot otv1.ObjectTemplate) int32 {
+	if ot.Spec.RevisionHistoryLimit != nil {
+		return *ot.Spec.RevisionHistoryLimit
+	}
+
+	return r.RevisionHistoryLimit
+}
+
+// getResyncPeriod template resync period or operator default
+func (r *ObjectTemplateReconciler) getResyncPeriod(ot otv1.ObjectTemplate) time.Duration {
+	if ot.Spec.ResyncPeriod != nil {
+		return ot.Spec.ResyncPeriod.Duration
+	}
+
+	return r.ResyncPeriod
 }
@@ -0,0 +1,214 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("ObjectTemplate reconciler", func() {
+	withResyncPeriod := func(period *metav1.Duration) otv1.ObjectTemplate {
+		return otv1.ObjectTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "settings", Finalizers: []string{otv1.Finalizer}},
+			Spec:       otv1.ObjectTemplateSpec{ResyncPeriod: period},
+		}
+	}
+
+	Context("With resync period", func() {
+		It("Should use the operator default without template period", func() {
+			Expect((&ObjectTemplateReconciler{}).getResyncPeriod(withResyncPeriod(nil))).To(BeZero())
+			Expect((&ObjectTemplateReconciler{ResyncPeriod: time.Hour}).getResyncPeriod(withResyncPeriod(nil))).To(Equal(time.Hour))
+		})
+
+		It("Should prefer the template period", func() {
+			reconciler := &ObjectTemplateReconciler{ResyncPeriod: time.Hour}
+
+			Expect(reconciler.getResyncPeriod(withResyncPeriod(&metav1.Duration{Duration: 10 * time.Minute}))).To(Equal(10 * time.Minute))
+			Expect(reconciler.getResyncPeriod(withResyncPeriod(&metav1.Duration{}))).To(BeZero())
+		})
+
+		It("Should requeue templates after the resync period", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(otv1.AddToScheme(scheme)).To(Succeed())
+			template := withResyncPeriod(&metav1.Duration{Duration: 10 * time.Minute})
+			reconciler := &ObjectTemplateReconciler{
+				Client:       fake.NewFakeClientWithScheme(scheme, &template),
+				Log:          ctrl.Log.WithName("test"),
+				Scheme:       scheme,
+				ResyncPeriod: time.Hour,
+			}
+
+			result, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: "settings"}})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(10 * time.Minute))
+		})
+	})
+
+	Context("With rollback", func() {
+		var scheme *runtime.Scheme
+		limit := int32(5)
+		rollbackTo := int64(1)
+		newTemplate := func() otv1.ObjectTemplate {
+			return otv1.ObjectTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "template", Finalizers: []string{otv1.Finalizer}},
+				Spec: otv1.ObjectTemplateSpec{
+					Objects:              []otv1.Object{{Name: "config", TemplateBody: "data: {current: true}"}},
+					Rollout:              &otv1.RolloutStrategy{Paused: true},
+					RevisionHistoryLimit: &limit,
+					AllowedNamespaces:    &otv1.AllowedNamespaces{Names: []string{"team-a"}},
+					RollbackTo:           &rollbackTo,
+					Suspend:              true,
+					ServiceAccountName:   "deployer",
+				},
+			}
+		}
+		newRevision := func() otv1.ObjectTemplateRevision {
+			spec := otv1.ObjectTemplateSpec{Objects: []otv1.Object{{Name: "config", TemplateBody: "data: {}"}}}
+			hash, err := revisionHash(spec)
+			Expect(err).NotTo(HaveOccurred())
+
+			return otv1.ObjectTemplateRevision{
+				ObjectMeta: metav1.ObjectMeta{Name: revisionName("template", 1)},
+				Spec:       otv1.ObjectTemplateRevisionSpec{TemplateName: "template", Revision: 1, Hash: hash, Template: spec},
+			}
+		}
+		rollback := func(objs ...runtime.Object) (otv1.ObjectTemplate, bool, error) {
+			template := newTemplate()
+			reconciler := &ObjectTemplateReconciler{
+				Client: fake.NewFakeClientWithScheme(scheme, append(objs, &template)...),
+				Log:    ctrl.Log.WithName("test"),
+				Scheme: scheme,
+			}
+			common := Common{Client: reconciler.Client, Log: reconciler.Log}
+			restored, err := reconciler.rollback(context.Background(), common, &template)
+
+			return template, restored, err
+		}
+
+		BeforeEach(func() {
+			scheme = runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(otv1.AddToScheme(scheme)).To(Succeed())
+		})
+
+		It("Should restore the revision spec", func() {
+			revision := newRevision()
+			template, restored, err := rollback(&revision)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restored).To(BeTrue())
+			Expect(template.Spec.Objects[0].TemplateBody).To(Equal("data: {}"))
+			Expect(template.Spec.RollbackTo).To(BeNil())
+
+			condition := meta.FindStatusCondition(template.Status.Conditions, otv1.ConditionRolledBack)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(condition.Message).To(Equal("rolled back to revision 1"))
+		})
+
+		It("Should keep rollout, history and operational settings", func() {
+			revision := newRevision()
+			template, _, err := rollback(&revision)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(template.Spec.Rollout).To(Equal(&otv1.RolloutStrategy{Paused: true}))
+			Expect(*template.Spec.RevisionHistoryLimit).To(Equal(limit))
+			Expect(template.Spec.AllowedNamespaces.Names).To(Equal([]string{"team-a"}))
+			Expect(template.Spec.Suspend).To(BeTrue())
+			Expect(template.Spec.ServiceAccountName).To(Equal("deployer"))
+		})
+
+		It("Should clear unknown revisions and report them in a condition", func() {
+			template, restored, err := rollback()
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restored).To(BeFalse())
+			Expect(template.Spec.RollbackTo).To(BeNil())
+			Expect(template.Spec.Objects[0].TemplateBody).To(Equal("data: {current: true}"))
+
+			condition := meta.FindStatusCondition(template.Status.Conditions, otv1.ConditionRolledBack)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Message).To(ContainSubstring("revision 1 of template template not found"))
+		})
+
+		It("Should keep reconciling templates with unknown revisions", func() {
+			template := newTemplate()
+			reconciler := &ObjectTemplateReconciler{
+				Client: fake.NewFakeClientWithScheme(scheme, &template),
+				Log:    ctrl.Log.WithName("test"),
+				Scheme: scheme,
+			}
+
+			_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: "template"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			updated := otv1.ObjectTemplate{}
+			Expect(reconciler.Get(context.Background(), types.NamespacedName{Name: "template"}, &updated)).To(Succeed())
+			Expect(updated.Spec.RollbackTo).To(BeNil())
+			Expect(meta.IsStatusConditionFalse(updated.Status.Conditions, otv1.ConditionRolledBack)).To(BeTrue())
+		})
+	})
+
+	Context("With template deletion", func() {
+		It("Should cleanup objects of parameters from namespaces not allowed anymore", func() {
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(otv1.AddToScheme(scheme)).To(Succeed())
+			now := metav1.Now()
+			template := otv1.ObjectTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "settings", Finalizers: []string{otv1.Finalizer}, DeletionTimestamp: &now},
+				Spec: otv1.ObjectTemplateSpec{
+					AllowedNamespaces: &otv1.AllowedNamespaces{Names: []string{"platform-*"}},
+					Objects:           []otv1.Object{{APIVersion: "v1", Kind: "ConfigMap", Name: "settings"}},
+				},
+			}
+			params := otv1.ObjectTemplateParams{
+				ObjectMeta: metav1.ObjectMeta{Name: "params", Namespace: "team-a", UID: "params-uid"},
+				Spec:       otv1.ObjectTemplateParamsSpec{Templates: []otv1.Parameters{{Name: "settings"}}},
+			}
+			configMap := corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team-a", OwnerReferences: getOwnerReferences(params)}}
+			reconciler := &ObjectTemplateReconciler{
+				Client: fake.NewFakeClientWithScheme(scheme, &template, &params, &configMap, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}),
+				Log:    ctrl.Log.WithName("test"),
+				Scheme: scheme,
+			}
+
+			_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Name: "settings"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = reconciler.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "settings"}, &corev1.ConfigMap{})
+			Expect(k8sErrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+})
@@ -18,15 +18,19 @@ package controllers
 
 import (
 	"context"
-	"reflect"
+	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
@@ -39,16 +43,30 @@ var (
 // ObjectTemplateParamsReconciler reconciles a ObjectTemplateParams object
 type ObjectTemplateParamsReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log          logr.Logger
+	Scheme       *runtime.Scheme
+	Mapper       meta.RESTMapper
+	Impersonator *Impersonator
+	Lookups      *LookupTracker
 }
 
 // SetupWithManager setup
 func (r *ObjectTemplateParamsReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	c, err := ctrl.NewControllerManagedBy(mgr).
 		For(&otv1.ObjectTemplateParams{}).
 		WithEventFilter(predicate.GenerationChangedPredicate{}).
-		Complete(r)
+		Build(r)
+
+	if err != nil {
+		return err
+	}
+
+	// objects read by templates are watched by this controller
+	if r.Lookups != nil {
+		r.Lookups.Controller = c
+	}
+
+	return nil
 }
 
 // +kubebuilder:rbac:groups=template.k8s.ericogr.com.br,resources=objecttemplateparams,verbs=get;list;watch;create;update;patch;delete
@@ -60,13 +78,14 @@ func (r *ObjectTemplateParamsReconciler) Reconcile(req ctrl.Request) (ctrl.Resul
 	log := r.Log.WithValues("objecttemplateparams", otGV)
 	var otp otv1.ObjectTemplateParams
 	err := r.Get(ctx, req.NamespacedName, &otp)
-	common := Common{r.Client, r.Log}
+	common := Common{Client: r.Client, Log: r.Log, Mapper: r.Mapper, Impersonator: r.Impersonator, Lookups: r.Lookups}
 
 	if err != nil {
 		otp.Status.Status = err.Error()
 
 		if k8sErrors.IsNotFound(err) {
 			// Object not found, return. Created objects are automatically garbage collected
+			r.Lookups.ForgetParams(req.NamespacedName)
 			return ctrl.Result{}, client.IgnoreNotFound(err)
 		}
 
@@ -74,35 +93,191 @@ func (r *ObjectTemplateParamsReconciler) Reconcile(req ctrl.Request) (ctrl.Resul
 		return ctrl.Result{}, err
 	}
 
+	if !otp.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, common, &otp)
+	}
+
+	if !controllerutil.ContainsFinalizer(&otp, otv1.Finalizer) {
+		controllerutil.AddFinalizer(&otp, otv1.Finalizer)
+
+		if err := r.Update(ctx, &otp); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	defer common.UpdateStatus(ctx, &otp)
 
+	// lookups are recorded again while rendering, templates not rendered anymore are not tracked
+	r.Lookups.ForgetParams(req.NamespacedName)
+
+	if otp.Spec.Suspend && !otp.Spec.DryRun {
+		otp.Status.Status = "Suspended"
+		return ctrl.Result{}, nil
+	}
+
 	lu := LogUtil{Log: log}
+	var previews []otv1.ObjectPreview
+	conflicts := []string{}
+	waiting := []string{}
+	refused := []string{}
+	var health *otv1.ObjectsHealth
+	healthMessages := []string{}
+	otp.Status.Revisions = removeAppliedRevisions(otp.Status.Revisions, otp.Spec)
 	for _, parameter := range otp.Spec.Templates {
-		ot, err := common.GetObjectTemplateByName(parameter.Name)
+		currentOt, err := common.GetObjectTemplateByName(parameter.Name)
 
 		if err != nil {
 			lu.Error(err, "Failed to get object template")
 			continue
 		}
 
-		if ot != nil {
-			kind := reflect.TypeOf(otv1.ObjectTemplateParams{}).Name()
-			gvk := otv1.GroupVersion.WithKind(kind)
-			controllerRef := metav1.NewControllerRef(otp.GetObjectMeta(), gvk)
+		if currentOt != nil {
+			allowed, err := common.isNamespaceAllowedByTemplate(*currentOt, otp.Namespace)
 
-			err = common.UpdateObjectsByTemplate(*ot, []metav1.OwnerReference{*controllerRef}, req.NamespacedName.Namespace, parameter.Values)
+			if err != nil {
+				lu.Error(err, "Failed to check allowed namespaces")
+				continue
+			}
+
+			if !allowed {
+				log.Info("Namespace not allowed by template, skipping", "template", currentOt.Name)
+				refused = append(refused, namespaceNotAllowedMessage(currentOt.Name, otp.Namespace))
+				continue
+			}
+
+			ot, revision, err := common.TemplateByParams(*currentOt, otp)
 
 			if err != nil {
-				lu.Error(err, "Failed to update object template")
+				lu.Error(err, "Failed to get template revision")
+				continue
+			}
+
+			if otp.Spec.DryRun || currentOt.Spec.DryRun {
+				preview, err := common.PreviewObjectsByTemplate(ot, otp)
+				previews = append(previews, preview...)
+
+				if err != nil {
+					lu.Error(err, "Failed to preview object template")
+				}
+				continue
+			}
+
+			if currentOt.Spec.Suspend {
+				log.Info("Template suspended, skipping", "template", ot.Name)
+				continue
+			}
+
+			err = common.UpdateObjectsByTemplate(ot, otp)
+			setAppliedRevision(&otp.Status.Revisions, ot.Name, revision)
+
+			if templateHealth, messages, healthErr := common.HealthByTemplate(ot, otp, err); healthErr == nil {
+				health = sumObjectsHealth(health, templateHealth)
+				healthMessages = append(healthMessages, messages...)
+			} else {
+				lu.Error(healthErr, "Failed to get objects health")
+			}
+
+			if err != nil {
+				var notReady *NotReadyError
+				if errors.As(err, &notReady) {
+					log.Info(notReady.Error(), "template", ot.Name)
+					waiting = append(waiting, fmt.Sprintf("%v: %v", ot.Name, notReady.Error()))
+				}
+
+				if isConflictError(err) {
+					conflicts = append(conflicts, fmt.Sprintf("%v: %v", ot.Name, err.Error()))
+				}
+
+				if err := failedObjectsError(err); err != nil {
+					lu.Error(err, "Failed to update object template")
+				}
 				continue
 			}
 		}
 	}
 
+	setConflictCondition(&otp.Status.Conditions, otp.Generation, conflicts)
+	setNamespaceAllowedCondition(&otp.Status.Conditions, otp.Generation, refused)
+	if health != nil {
+		setHealthyCondition(&otp.Status.Conditions, otp.Generation, *health, healthMessages)
+	}
+	otp.Status.Preview = previews
 	otp.Status.Status = "OK"
+	if otp.Spec.DryRun {
+		otp.Status.Status = "DryRun"
+	}
+	if len(waiting) > 0 {
+		otp.Status.Status = strings.Join(waiting, "\n")
+	}
+	if len(refused) > 0 {
+		otp.Status.Status = strings.Join(refused, "\n")
+	}
 	if lu.HasError() {
 		otp.Status.Status = lu.AllErrorsMessages()
 	}
 
+	if len(waiting) > 0 || (health != nil && health.InProgress > 0) {
+		return ctrl.Result{RequeueAfter: requeueNotReady(0)}, nil
+	}
+
 	return ctrl.Result{Requeue: false}, nil
 }
+
+// finalize apply deletion policy to objects of all templates and remove finalizer (finalizer is kept while parameters or templates are suspended, otherwise objects would be garbage collected)
+func (r *ObjectTemplateParamsReconciler) finalize(ctx context.Context, common Common, otp *otv1.ObjectTemplateParams) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(otp, otv1.Finalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	if otp.Spec.Suspend {
+		otp.Status.Status = "Suspended, deletion waits until parameters are resumed"
+		common.UpdateStatus(ctx, otp)
+		return ctrl.Result{}, nil
+	}
+
+	lu := LogUtil{Log: common.Log}
+	suspended := []string{}
+	for _, parameter := range otp.Spec.Templates {
+		ot, err := common.GetObjectTemplateByName(parameter.Name)
+
+		if err != nil {
+			lu.Error(err, "Failed to get object template")
+			continue
+		}
+
+		if ot == nil {
+			continue
+		}
+
+		if ot.Spec.Suspend {
+			suspended = append(suspended, ot.Name)
+			continue
+		}
+
+		applied, _, err := common.TemplateByParams(*ot, *otp)
+
+		if err != nil {
+			common.Log.Error(err, "Failed to get template revision, using current template", "template", ot.Name)
+		}
+
+		if err := common.CleanupObjectsByTemplate(applied, *otp); err != nil {
+			lu.Error(err, "Failed to cleanup objects")
+		}
+	}
+
+	if lu.HasError() {
+		return ctrl.Result{}, lu.AllErrors()
+	}
+
+	// template changes don't trigger parameters reconcile
+	if len(suspended) > 0 {
+		otp.Status.Status = fmt.Sprintf("Deletion waits until templates are resumed: %v", strings.Join(suspended, ", "))
+		common.UpdateStatus(ctx, otp)
+		return ctrl.Result{RequeueAfter: requeueNotReady(0)}, nil
+	}
+
+	common.Lookups.ForgetParams(types.NamespacedName{Namespace: otp.Namespace, Name: otp.Name})
+	controllerutil.RemoveFinalizer(otp, otv1.Finalizer)
+
+	return ctrl.Result{}, r.Update(ctx, otp)
+}
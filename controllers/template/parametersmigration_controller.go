@@ -0,0 +1,139 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+)
+
+// ParametersMigrationReconciler rewrite stored parameters values using template migrations
+type ParametersMigrationReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// SetupWithManager setup
+func (r *ParametersMigrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("parametersmigration").
+		For(&otv1.ObjectTemplate{}).
+		WithEventFilter(predicate.GenerationChangedPredicate{}).
+		Complete(r)
+}
+
+// Reconcile k8s reconcile
+func (r *ParametersMigrationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("objecttemplate", req.Name)
+	var objectTemplate otv1.ObjectTemplate
+	common := Common{Client: r.Client, Log: log}
+
+	if err := r.Get(ctx, req.NamespacedName, &objectTemplate); err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	if !objectTemplate.DeletionTimestamp.IsZero() || len(objectTemplate.Spec.Migrations) == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	otParams, err := common.FindObjectTemplateParamsByTemplateName(objectTemplate.Name)
+
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// held parameters can't be checked until the template controller records the revision of the new spec
+	current := common.currentRevision(objectTemplate)
+	if current == 0 && objectTemplate.Spec.Rollout != nil {
+		return ctrl.Result{RequeueAfter: requeueNotReady(0)}, nil
+	}
+
+	lu := LogUtil{Log: log}
+	held := false
+	for _, otParam := range otParams {
+		// parameters pinned to a revision keep the old parameters
+		if !followsLatestRevision(otParam, objectTemplate.Name) {
+			continue
+		}
+
+		// parameters held by rollout keep values used by their applied revision until rollout reaches them
+		if heldByRollout(objectTemplate, otParam, current) {
+			held = true
+			continue
+		}
+
+		if err := r.migrateParams(ctx, common, objectTemplate, otParam); err != nil {
+			lu.Error(err, fmt.Sprintf("Failed to migrate parameters %v", paramsKey(otParam)))
+		}
+	}
+
+	// template status changes don't trigger this controller
+	if held && !lu.HasError() {
+		return ctrl.Result{RequeueAfter: requeueNotReady(0)}, nil
+	}
+
+	return ctrl.Result{}, lu.AllErrors()
+}
+
+// migrateParams rewrite parameters values and report rewrites in Migrated condition
+func (r *ParametersMigrationReconciler) migrateParams(ctx context.Context, common Common, ot otv1.ObjectTemplate, otp otv1.ObjectTemplateParams) error {
+	parameters, err := otp.Spec.GetParametersByTemplateName(ot.Name)
+
+	if err != nil {
+		return err
+	}
+
+	values, rewrites, err := migrateParametersValues(ot.Spec.Migrations, parameters.Values, true)
+
+	if err != nil || len(rewrites) == 0 {
+		return err
+	}
+
+	otp.Spec.SetValuesByName(ot.Name, values)
+	if err := r.Update(ctx, &otp); err != nil {
+		return err
+	}
+	common.Log.Info("Parameters values migrated", "params", paramsKey(otp), "rewrites", rewrites)
+
+	setStatusCondition(&otp.Status.Conditions, metav1.Condition{
+		Type:               otv1.ConditionMigrated,
+		Status:             metav1.ConditionTrue,
+		Reason:             "ValuesRewritten",
+		ObservedGeneration: otp.Generation,
+		Message:            fmt.Sprintf("%v: %v", ot.Name, strings.Join(rewrites, ", ")),
+	})
+	common.UpdateStatus(ctx, &otp)
+
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+)
+
+const (
+	templateWebhookPath = "/validate-template-k8s-ericogr-com-br-v1-objecttemplate"
+	paramsWebhookPath   = "/validate-template-k8s-ericogr-com-br-v1-objecttemplateparams"
+	revisionWebhookPath = "/validate-template-k8s-ericogr-com-br-v1-objecttemplaterevision"
+)
+
+// +kubebuilder:webhook:path=/validate-template-k8s-ericogr-com-br-v1-objecttemplate,mutating=false,failurePolicy=fail,groups=template.k8s.ericogr.com.br,resources=objecttemplates,verbs=create;update,versions=v1,name=vobjecttemplate.k8s.ericogr.com.br
+// +kubebuilder:webhook:path=/validate-template-k8s-ericogr-com-br-v1-objecttemplateparams,mutating=false,failurePolicy=fail,groups=template.k8s.ericogr.com.br,resources=objecttemplateparams,verbs=create;update,versions=v1,name=vobjecttemplateparams.k8s.ericogr.com.br
+// +kubebuilder:webhook:path=/validate-template-k8s-ericogr-com-br-v1-objecttemplaterevision,mutating=false,failurePolicy=fail,groups=template.k8s.ericogr.com.br,resources=objecttemplaterevisions,verbs=update,versions=v1,name=vobjecttemplaterevision.k8s.ericogr.com.br
+
+// PolicyWebhook validate templates and parameters against template policies and reject changes to revisions
+type PolicyWebhook struct {
+	Client  client.Client
+	Log     logr.Logger
+	Mapper  meta.RESTMapper
+	decoder *admission.Decoder
+}
+
+// SetupWebhookWithManager register webhook paths
+func (w *PolicyWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	server := mgr.GetWebhookServer()
+	server.Register(templateWebhookPath, &webhook.Admission{Handler: w})
+	server.Register(paramsWebhookPath, &webhook.Admission{Handler: w})
+	server.Register(revisionWebhookPath, &webhook.Admission{Handler: w})
+
+	return nil
+}
+
+// InjectDecoder inject admission decoder
+func (w *PolicyWebhook) InjectDecoder(d *admission.Decoder) error {
+	w.decoder = d
+	return nil
+}
+
+// Handle validate admission request
+func (w *PolicyWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	common := Common{Client: w.Client, Log: w.Log.WithValues("name", req.Name, "namespace", req.Namespace), Mapper: w.Mapper}
+	var err error
+
+	switch req.Kind.Kind {
+	case templateKind:
+		ot := otv1.ObjectTemplate{}
+		if err := w.decoder.Decode(req, &ot); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		if err := validateObjectBases(ot); err != nil {
+			return admission.Denied(err.Error())
+		}
+
+		if err := validateChartSource(ot); err != nil {
+			return admission.Denied(err.Error())
+		}
+		err = common.validateTemplatePolicies(ot)
+	case paramsKind:
+		otp := otv1.ObjectTemplateParams{}
+		if err := w.decoder.Decode(req, &otp); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		err = common.validateParamsPolicies(otp)
+	case revisionKind:
+		if len(req.OldObject.Raw) == 0 {
+			return admission.Allowed("")
+		}
+
+		old := otv1.ObjectTemplateRevision{}
+		revision := otv1.ObjectTemplateRevision{}
+		if err := w.decoder.DecodeRaw(req.OldObject, &old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		if err := w.decoder.Decode(req, &revision); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		if err := validateRevisionUpdate(old, revision); err != nil {
+			return admission.Denied(err.Error())
+		}
+	default:
+		return admission.Allowed("")
+	}
+
+	if isPolicyViolationError(err) {
+		common.Log.Info(err.Error())
+		return admission.Denied(err.Error())
+	} else if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	return admission.Allowed("")
+}
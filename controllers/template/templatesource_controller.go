@@ -0,0 +1,247 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+)
+
+// TemplateSourceReconciler keep templates in sync with template sources
+type TemplateSourceReconciler struct {
+	client.Client
+	Log        logr.Logger
+	Scheme     *runtime.Scheme
+	SyncPeriod time.Duration
+	// APIReader uncached reader of source config maps (uses Client if not set)
+	APIReader client.Reader
+
+	mu sync.Mutex
+	// configMaps config map read by each source
+	configMaps map[string]types.NamespacedName
+}
+
+// SetupWithManager setup
+func (r *TemplateSourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	configMaps, err := r.sourceConfigMapInformer(mgr)
+
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&otv1.TemplateSource{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// templates have a non controller reference to their source
+		Watches(&source.Kind{Type: &otv1.ObjectTemplate{}}, &handler.EnqueueRequestForOwner{OwnerType: &otv1.TemplateSource{}},
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Informer{Informer: configMaps}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.sourcesByConfigMap),
+		}, builder.WithPredicates(r.configMapPredicate())).
+		Complete(r)
+}
+
+// sourceConfigMapInformer informer of config maps labeled as template sources (a watch of every config map would
+// cache the whole cluster)
+func (r *TemplateSourceReconciler) sourceConfigMapInformer(mgr ctrl.Manager) (cache.Informer, error) {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+
+	if err != nil {
+		return nil, err
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = labels.SelectorFromSet(labels.Set{otv1.SourceConfigMapLabel: otv1.SourceConfigMapValue}).String()
+	}))
+	informer := factory.Core().V1().ConfigMaps().Informer()
+
+	err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		factory.Start(stop)
+		<-stop
+		return nil
+	}))
+
+	return informer, err
+}
+
+// +kubebuilder:rbac:groups=template.k8s.ericogr.com.br,resources=templatesources,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=template.k8s.ericogr.com.br,resources=templatesources/status,verbs=get;update;patch
+
+// Reconcile k8s reconcile
+func (r *TemplateSourceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("templatesource", req.Name)
+	var templateSource otv1.TemplateSource
+	common := Common{Client: r.Client, Log: log, ConfigMapReader: r.APIReader}
+
+	if err := r.Get(ctx, req.NamespacedName, &templateSource); err != nil {
+		// templates were handled by the finalizer
+		r.forgetConfigMap(req.Name)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !templateSource.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, common, &templateSource)
+	}
+
+	if !controllerutil.ContainsFinalizer(&templateSource, otv1.Finalizer) {
+		controllerutil.AddFinalizer(&templateSource, otv1.Finalizer)
+
+		if err := r.Update(ctx, &templateSource); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	r.trackConfigMap(templateSource)
+	defer common.UpdateStatus(ctx, &templateSource)
+
+	names, err := common.SyncTemplateSource(templateSource)
+	templateSource.Status.Templates = names
+
+	if err != nil {
+		templateSource.Status.Status = err.Error()
+		return ctrl.Result{}, err
+	}
+
+	now := metav1.Now()
+	templateSource.Status.LastSyncTime = &now
+	templateSource.Status.Status = "OK"
+
+	return ctrl.Result{RequeueAfter: r.getSyncPeriod(templateSource)}, nil
+}
+
+// finalize apply deletion policy to templates of source and remove finalizer
+func (r *TemplateSourceReconciler) finalize(ctx context.Context, common Common, templateSource *otv1.TemplateSource) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(templateSource, otv1.Finalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	if err := common.FinalizeTemplateSource(*templateSource); err != nil {
+		templateSource.Status.Status = err.Error()
+		common.UpdateStatus(ctx, templateSource)
+		return ctrl.Result{}, err
+	}
+
+	r.forgetConfigMap(templateSource.Name)
+	controllerutil.RemoveFinalizer(templateSource, otv1.Finalizer)
+
+	return ctrl.Result{}, r.Update(ctx, templateSource)
+}
+
+// getSyncPeriod source sync period or operator default
+func (r *TemplateSourceReconciler) getSyncPeriod(templateSource otv1.TemplateSource) time.Duration {
+	if templateSource.Spec.SyncPeriod != nil {
+		return templateSource.Spec.SyncPeriod.Duration
+	}
+
+	return r.SyncPeriod
+}
+
+// trackConfigMap remember the config map read by source
+func (r *TemplateSourceReconciler) trackConfigMap(templateSource otv1.TemplateSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.configMaps == nil {
+		r.configMaps = map[string]types.NamespacedName{}
+	}
+
+	ref := templateSource.Spec.ConfigMap
+	if ref == nil {
+		delete(r.configMaps, templateSource.Name)
+		return
+	}
+
+	r.configMaps[templateSource.Name] = types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}
+}
+
+// forgetConfigMap stop watching the config map of a deleted source
+func (r *TemplateSourceReconciler) forgetConfigMap(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.configMaps, name)
+}
+
+// sourcesByConfigMap template sources reading a config map (all sources are reconciled on start, so every source
+// reading a config map is known)
+func (r *TemplateSourceReconciler) sourcesByConfigMap(obj handler.MapObject) []reconcile.Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := types.NamespacedName{Namespace: obj.Meta.GetNamespace(), Name: obj.Meta.GetName()}
+	requests := []reconcile.Request{}
+	for name, configMap := range r.configMaps {
+		if configMap == key {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: name}})
+		}
+	}
+
+	return requests
+}
+
+// configMapPredicate ignore config maps not read by sources and updates that don't change their data
+func (r *TemplateSourceReconciler) configMapPredicate() predicate.Predicate {
+	isSourceConfigMap := func(obj handler.MapObject) bool {
+		return len(r.sourcesByConfigMap(obj)) > 0
+	}
+
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return isSourceConfigMap(handler.MapObject{Meta: e.Meta, Object: e.Object})
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return isSourceConfigMap(handler.MapObject{Meta: e.Meta, Object: e.Object})
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if !isSourceConfigMap(handler.MapObject{Meta: e.MetaNew, Object: e.ObjectNew}) {
+				return false
+			}
+
+			previous, ok := e.ObjectOld.(*corev1.ConfigMap)
+			current, ok2 := e.ObjectNew.(*corev1.ConfigMap)
+
+			return !ok || !ok2 || !reflect.DeepEqual(previous.Data, current.Data) || !reflect.DeepEqual(previous.BinaryData, current.BinaryData)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return isSourceConfigMap(handler.MapObject{Meta: e.Meta, Object: e.Object})
+		},
+	}
+}
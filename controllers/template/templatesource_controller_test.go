@@ -0,0 +1,169 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	otv1 "github.com/ericogr/k8s-object-template/apis/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("TemplateSource reconciler", func() {
+	var reconciler *TemplateSourceReconciler
+	var configMap *corev1.ConfigMap
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: "platform"}}
+	getSource := func() otv1.TemplateSource {
+		templateSource := otv1.TemplateSource{}
+		Expect(reconciler.Get(context.Background(), request.NamespacedName, &templateSource)).To(Succeed())
+		return templateSource
+	}
+	getTemplate := func(name string) (otv1.ObjectTemplate, error) {
+		ot := otv1.ObjectTemplate{}
+		err := reconciler.Get(context.Background(), types.NamespacedName{Name: name}, &ot)
+		return ot, err
+	}
+	deleteSource := func() {
+		templateSource := getSource()
+		now := metav1.Now()
+		templateSource.DeletionTimestamp = &now
+		Expect(reconciler.Update(context.Background(), &templateSource)).To(Succeed())
+
+		_, err := reconciler.Reconcile(request)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	newReconciler := func(policy otv1.DeletionPolicy) *TemplateSourceReconciler {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(otv1.AddToScheme(scheme)).To(Succeed())
+
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "templates", Namespace: "platform", Labels: map[string]string{otv1.SourceConfigMapLabel: otv1.SourceConfigMapValue}},
+			Data:       map[string]string{"network.yaml": networkTemplateFile},
+		}
+		templateSource := &otv1.TemplateSource{
+			ObjectMeta: metav1.ObjectMeta{Name: "platform", UID: "source-uid"},
+			Spec: otv1.TemplateSourceSpec{
+				ConfigMap:      &otv1.ConfigMapSourceRef{Name: "templates", Namespace: "platform"},
+				DeletionPolicy: policy,
+			},
+		}
+
+		return &TemplateSourceReconciler{
+			Client:     fake.NewFakeClientWithScheme(scheme, configMap, templateSource),
+			Log:        ctrl.Log.WithName("test"),
+			Scheme:     scheme,
+			SyncPeriod: time.Hour,
+		}
+	}
+
+	BeforeEach(func() {
+		reconciler = newReconciler("")
+	})
+
+	It("Should sync templates and requeue after the sync period", func() {
+		result, err := reconciler.Reconcile(request)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Hour))
+
+		templateSource := getSource()
+		Expect(templateSource.Finalizers).To(ConsistOf(otv1.Finalizer))
+		Expect(templateSource.Status.Status).To(Equal("OK"))
+		Expect(templateSource.Status.Templates).To(Equal([]string{"network", "quota"}))
+
+		network, err := getTemplate("network")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(network.OwnerReferences).To(Equal([]metav1.OwnerReference{sourceOwnerReference(templateSource)}))
+	})
+
+	It("Should orphan templates when source is deleted", func() {
+		_, err := reconciler.Reconcile(request)
+		Expect(err).NotTo(HaveOccurred())
+
+		deleteSource()
+
+		network, err := getTemplate("network")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(network.OwnerReferences).To(BeEmpty())
+		Expect(network.Labels).To(Equal(map[string]string{"team": "platform"}))
+
+		Expect(getSource().Finalizers).To(BeEmpty())
+	})
+
+	It("Should delete templates when source is deleted with delete policy", func() {
+		reconciler = newReconciler(otv1.DeletionPolicyDelete)
+		_, err := reconciler.Reconcile(request)
+		Expect(err).NotTo(HaveOccurred())
+
+		unmanaged := otv1.ObjectTemplate{ObjectMeta: metav1.ObjectMeta{Name: "manual", Labels: map[string]string{otv1.SourceLabel: "platform"}}}
+		Expect(reconciler.Create(context.Background(), &unmanaged)).To(Succeed())
+
+		deleteSource()
+
+		for _, name := range []string{"network", "quota"} {
+			_, err = getTemplate(name)
+			Expect(k8sErrors.IsNotFound(err)).To(BeTrue())
+		}
+
+		_, err = getTemplate("manual")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Should only watch data changes of source config maps", func() {
+		configMapEvent := func(cm *corev1.ConfigMap) event.GenericEvent {
+			return event.GenericEvent{Meta: cm, Object: cm}
+		}
+		other := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "templates", Namespace: "other"}}
+		predicate := reconciler.configMapPredicate()
+
+		// sources are known after reconcile
+		Expect(predicate.Generic(configMapEvent(configMap))).To(BeFalse())
+
+		_, err := reconciler.Reconcile(request)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(predicate.Generic(configMapEvent(configMap))).To(BeTrue())
+		Expect(predicate.Generic(configMapEvent(other))).To(BeFalse())
+		Expect(reconciler.sourcesByConfigMap(handler.MapObject{Meta: configMap, Object: configMap})).To(ConsistOf(reconcile.Request{NamespacedName: request.NamespacedName}))
+
+		labeled := configMap.DeepCopy()
+		labeled.Labels = map[string]string{"team": "platform"}
+		Expect(predicate.Update(event.UpdateEvent{MetaOld: configMap, ObjectOld: configMap, MetaNew: labeled, ObjectNew: labeled})).To(BeFalse())
+
+		changed := configMap.DeepCopy()
+		changed.Data["storage.json"] = storageTemplateFile
+		Expect(predicate.Update(event.UpdateEvent{MetaOld: configMap, ObjectOld: configMap, MetaNew: changed, ObjectNew: changed})).To(BeTrue())
+
+		deleteSource()
+		Expect(predicate.Generic(configMapEvent(configMap))).To(BeFalse())
+	})
+})
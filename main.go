@@ -18,7 +18,9 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -43,16 +45,66 @@ func init() {
 }
 
 func main() {
+	// jsonnet, cue and helm charts are evaluated by a child process of this binary, killed after the template timeout
+	if len(os.Args) > 1 && os.Args[1] == controllers.EvaluatorArg {
+		if err := controllers.RunEvaluator(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
+	var resyncPeriod time.Duration
+	var revisionHistoryLimit int
+	var enableParamsMigration bool
+	var enablePolicyWebhook bool
+	var sourceSyncPeriod time.Duration
+	var requireServiceAccount bool
+	var templateOptions controllers.TemplateOptions
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 0,
+		"Default period to re-render and re-apply template objects. "+
+			"Zero disables periodic resync unless the template sets its own resyncPeriod.")
+	flag.IntVar(&revisionHistoryLimit, "revision-history-limit", 10,
+		"Default number of template revisions to keep. "+
+			"Revisions applied or pinned by parameters are never removed.")
+	flag.BoolVar(&enableParamsMigration, "enable-params-migration", false,
+		"Rewrite stored ObjectTemplateParams values using template parameter migrations.")
+	flag.BoolVar(&enablePolicyWebhook, "enable-policy-webhook", false,
+		"Validate templates and parameters against ObjectTemplatePolicy using an admission webhook.")
+	flag.DurationVar(&sourceSyncPeriod, "template-source-sync-period", time.Minute,
+		"Default period to read TemplateSource files again. Zero disables periodic sync.")
+	flag.BoolVar(&requireServiceAccount, "require-service-account", false,
+		"Apply objects only impersonating a service account. "+
+			"Templates and parameters without serviceAccountName fail instead of using the operator permissions.")
+	flag.BoolVar(&templateOptions.UnsafeFunctions, "template-unsafe-functions", false,
+		"Allow template functions that read the operator environment (env, expandenv, getHostByName) "+
+			"or are not deterministic (now, randAlphaNum, uuidv4, genPrivateKey, ...).")
+	flag.DurationVar(&templateOptions.Timeout, "template-timeout", 5*time.Second,
+		"Maximum execution time of a template. Zero disables the timeout.")
+	flag.IntVar(&templateOptions.MaxOutputSize, "template-max-output-size", 1024*1024,
+		"Maximum size in bytes of a rendered template. Zero disables the limit.")
+	flag.StringVar(&templateOptions.ClusterName, "cluster-name", "",
+		"Cluster name available to templates as __clusterName.")
+	flag.BoolVar(&templateOptions.UnrestrictedLookups, "template-unrestricted-lookups", false,
+		"Allow templates without a service account to look up Secrets and objects of other namespaces.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
+	evaluator, err := controllers.EvaluatorCommand()
+	if err != nil {
+		setupLog.Error(err, "unable to find evaluator command")
+		os.Exit(1)
+	}
+	templateOptions.Evaluator = evaluator
+	controllers.SetTemplateOptions(templateOptions)
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
@@ -65,23 +117,75 @@ func main() {
 		os.Exit(1)
 	}
 
+	impersonator := &controllers.Impersonator{
+		Config:   mgr.GetConfig(),
+		Scheme:   mgr.GetScheme(),
+		Mapper:   mgr.GetRESTMapper(),
+		Required: requireServiceAccount,
+	}
+	lookups := &controllers.LookupTracker{
+		Reader: mgr.GetCache(),
+		Log:    ctrl.Log.WithName("lookups"),
+	}
+
 	if err = (&controllers.ObjectTemplateReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ObjectTemplate"),
-		Scheme: mgr.GetScheme(),
+		Client:               mgr.GetClient(),
+		Log:                  ctrl.Log.WithName("controllers").WithName("ObjectTemplate"),
+		Scheme:               mgr.GetScheme(),
+		ResyncPeriod:         resyncPeriod,
+		RevisionHistoryLimit: int32(revisionHistoryLimit),
+		Mapper:               mgr.GetRESTMapper(),
+		Impersonator:         impersonator,
+		Lookups:              lookups,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ObjectTemplate")
 		os.Exit(1)
 	}
 
 	if err = (&controllers.ObjectTemplateParamsReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ObjectTemplateParams"),
-		Scheme: mgr.GetScheme(),
+		Client:       mgr.GetClient(),
+		Log:          ctrl.Log.WithName("controllers").WithName("ObjectTemplateParams"),
+		Scheme:       mgr.GetScheme(),
+		Mapper:       mgr.GetRESTMapper(),
+		Impersonator: impersonator,
+		Lookups:      lookups,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ObjectTemplateParams")
 		os.Exit(1)
 	}
+
+	if err = (&controllers.TemplateSourceReconciler{
+		Client:     mgr.GetClient(),
+		Log:        ctrl.Log.WithName("controllers").WithName("TemplateSource"),
+		Scheme:     mgr.GetScheme(),
+		SyncPeriod: sourceSyncPeriod,
+		APIReader:  mgr.GetAPIReader(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TemplateSource")
+		os.Exit(1)
+	}
+
+	if enableParamsMigration {
+		if err = (&controllers.ParametersMigrationReconciler{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("controllers").WithName("ParametersMigration"),
+			Scheme: mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ParametersMigration")
+			os.Exit(1)
+		}
+	}
+
+	if enablePolicyWebhook {
+		if err = (&controllers.PolicyWebhook{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("webhooks").WithName("ObjectTemplatePolicy"),
+			Mapper: mgr.GetRESTMapper(),
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ObjectTemplatePolicy")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -1,3 +1,3 @@
 package main
 
-// +kubebuilder:rbac:groups=*,resources=*,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=*,resources=*,verbs=get;list;watch;create;update;patch;delete